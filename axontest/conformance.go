@@ -0,0 +1,493 @@
+// Package axontest helps testing code built on axon. Record decorates a store to assert on the messages going
+// through it, and RunConformance checks that axon.EventStore implementations behave alike.
+//
+// RunConformance exercises a backend through the EventStore interface only: publish/subscribe delivery,
+// load balancing within a service, ack and redelivery, request/reply with errors and timeouts, concurrent use
+// and Close. Backends that deliberately differ, such as at-most-once delivery, declare it with an Option so the
+// difference is spelled out in their tests.
+package axontest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Just4Ease/axon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	defaultAckWait           = time.Second
+	defaultRedeliveryTimeout = 10 * time.Second
+	defaultReadyTimeout      = 10 * time.Second
+	probePrefix              = "axontest-probe-"
+	probeInterval            = 50 * time.Millisecond
+)
+
+// Factory opens a store on the backend under test with opts, which carry the service name and the ack wait of
+// the topics used. Stores opened during a test must share the backend, and the factory closes them when the
+// test ends, for example with t.Cleanup.
+type Factory func(t *testing.T, opts axon.Options) axon.EventStore
+
+type Option func(*suite)
+
+// AckWait sets the ack wait the suite configures on its topics, for backends with a longer minimum.
+// Defaults to one second.
+func AckWait(d time.Duration) Option {
+	return func(s *suite) {
+		s.ackWait = d
+	}
+}
+
+// RedeliveryTimeout sets how long the suite waits for an unacknowledged message to be delivered again.
+func RedeliveryTimeout(d time.Duration) Option {
+	return func(s *suite) {
+		s.redeliveryTimeout = d
+	}
+}
+
+// AtMostOnce declares that the backend does not redeliver unacknowledged messages; the suite then checks that
+// they are not delivered again.
+func AtMostOnce() Option {
+	return func(s *suite) {
+		s.atMostOnce = true
+	}
+}
+
+// RedeliveryOnReconnect declares that the backend delivers unacknowledged messages again only once their
+// subscriber reconnects; the suite then checks they are not delivered again before, and are to a new store of
+// the service.
+func RedeliveryOnReconnect() Option {
+	return func(s *suite) {
+		s.onReconnect = true
+	}
+}
+
+type suite struct {
+	factory           Factory
+	ackWait           time.Duration
+	redeliveryTimeout time.Duration
+	readyTimeout      time.Duration
+	atMostOnce        bool
+	onReconnect       bool
+}
+
+// RunConformance runs the conformance suite against the stores opened by factory, each check as a subtest.
+func RunConformance(t *testing.T, factory Factory, options ...Option) {
+	s := &suite{
+		factory:           factory,
+		ackWait:           defaultAckWait,
+		redeliveryTimeout: defaultRedeliveryTimeout,
+		readyTimeout:      defaultReadyTimeout,
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	t.Run("PublishSubscribe", s.testPublishSubscribe)
+	t.Run("LoadBalancing", s.testLoadBalancing)
+	t.Run("AckAndRedelivery", s.testAckAndRedelivery)
+	t.Run("RequestReply", s.testRequestReply)
+	t.Run("RequestTimeout", s.testRequestTimeout)
+	t.Run("Concurrency", s.testConcurrency)
+	t.Run("Close", s.testClose)
+}
+
+// open opens a store for service with the suite's ack wait on topic.
+func (s *suite) open(t *testing.T, service, topic string) axon.EventStore {
+	store := s.factory(t, axon.Options{
+		ServiceName: service,
+		Topics:      map[string]axon.TopicOptions{topic: {AckWait: s.ackWait}},
+	})
+	require.NotNil(t, store)
+	return store
+}
+
+// topic returns a topic name unique to the running test.
+func topic(t *testing.T) string {
+	name := strings.NewReplacer("/", ".", " ", "_").Replace(t.Name())
+	return "axontest." + name + "." + axon.GenerateRandomString()[:8]
+}
+
+func isProbe(data []byte) bool {
+	return strings.HasPrefix(string(data), probePrefix)
+}
+
+// subscription runs Subscribe in the background. Probes are acknowledged and kept from the handler.
+type subscription struct {
+	probed    chan struct{}
+	probeOnce sync.Once
+	returned  chan error
+}
+
+func subscribe(store axon.EventStore, topic string, handler axon.SubscriptionHandler) *subscription {
+	sub := &subscription{probed: make(chan struct{}), returned: make(chan error, 1)}
+	go func() {
+		sub.returned <- store.Subscribe(topic, func(e axon.Event) {
+			if isProbe(e.Data()) {
+				e.Ack()
+				sub.probeOnce.Do(func() { close(sub.probed) })
+				return
+			}
+			handler(e)
+		})
+	}()
+	return sub
+}
+
+// waitReady publishes probes on topic until every subscription received one. Subscriptions are established
+// asynchronously, and new ones only receive the messages published afterwards on most backends.
+func (s *suite) waitReady(t *testing.T, publisher axon.EventStore, topic string, subs ...*subscription) {
+	t.Helper()
+	deadline := time.After(s.readyTimeout)
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		require.NoError(t, publisher.Publish(topic, []byte(fmt.Sprintf("%s%d", probePrefix, i))))
+
+		ready := true
+		for _, sub := range subs {
+			select {
+			case err := <-sub.returned:
+				t.Fatalf("Subscribe returned before the store was closed: %v", err)
+			case <-sub.probed:
+			default:
+				ready = false
+			}
+		}
+		if ready {
+			return
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("subscriptions to %s did not receive messages within %s", topic, s.readyTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+type greeting struct {
+	Name string
+}
+
+// reply runs ReplyContext in the background with a handler greeting the caller, failing on an empty name, and
+// waits for it to answer. It returns a func counting the requests handled, probes excluded.
+func (s *suite) reply(t *testing.T, caller, replier axon.EventStore, topic string) func() int64 {
+	t.Helper()
+	var handled int64
+	go func() {
+		_ = replier.ReplyContext(topic, func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			var in greeting
+			if err := req.ParsePayload(&in); err != nil {
+				return axon.Response{}, err
+			}
+			if isProbe([]byte(in.Name)) {
+				return axon.Response{Payload: []byte(`{}`)}, nil
+			}
+
+			atomic.AddInt64(&handled, 1)
+			if in.Name == "" {
+				return axon.Response{}, errors.New("name is required")
+			}
+			out, err := json.Marshal(map[string]string{"greeting": "hello " + in.Name, "from": req.ServiceName})
+			return axon.Response{Payload: out, Headers: map[string]string{"language": req.Headers["language"]}}, err
+		})
+	}()
+
+	deadline := time.Now().Add(s.readyTimeout)
+	for {
+		payload, _ := json.Marshal(greeting{Name: probePrefix})
+		var out map[string]string
+		err := caller.Request(topic, payload, &out, axon.WithTimeout(500*time.Millisecond))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no reply on %s within %s: %v", topic, s.readyTimeout, err)
+		}
+		time.Sleep(probeInterval)
+	}
+
+	return func() int64 { return atomic.LoadInt64(&handled) }
+}
+
+func receive(t *testing.T, events <-chan axon.Event, timeout time.Duration) axon.Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(timeout):
+		t.Fatalf("no message received within %s", timeout)
+		return nil
+	}
+}
+
+// testPublishSubscribe checks that a subscriber receives every message published with its topic and data.
+func (s *suite) testPublishSubscribe(t *testing.T) {
+	topic := topic(t)
+	publisher := s.open(t, "publisher", topic)
+	subscriber := s.open(t, "subscriber", topic)
+
+	events := make(chan axon.Event, 16)
+	sub := subscribe(subscriber, topic, func(e axon.Event) {
+		e.Ack()
+		events <- e
+	})
+	s.waitReady(t, publisher, topic, sub)
+
+	want := []string{"first", "second", "third"}
+	for _, data := range want {
+		require.NoError(t, publisher.Publish(topic, []byte(data)))
+	}
+	var got []string
+	for range want {
+		e := receive(t, events, s.redeliveryTimeout)
+		assert.Equal(t, topic, e.Topic())
+		got = append(got, string(e.Data()))
+	}
+	assert.ElementsMatch(t, want, got)
+}
+
+// testLoadBalancing checks that each message is delivered to one subscriber of a service, and to every service.
+func (s *suite) testLoadBalancing(t *testing.T) {
+	const messages = 20
+	topic := topic(t)
+	publisher := s.open(t, "publisher", topic)
+	first, second := s.open(t, "orders", topic), s.open(t, "orders", topic)
+	billing := s.open(t, "billing", topic)
+
+	var mu sync.Mutex
+	received := map[string]map[string]int{"first": {}, "second": {}, "billing": {}}
+	done := make(chan struct{}, 3*messages)
+	handler := func(name string) axon.SubscriptionHandler {
+		return func(e axon.Event) {
+			mu.Lock()
+			received[name][string(e.Data())]++
+			mu.Unlock()
+			e.Ack()
+			done <- struct{}{}
+		}
+	}
+	subs := []*subscription{
+		subscribe(first, topic, handler("first")),
+		subscribe(second, topic, handler("second")),
+		subscribe(billing, topic, handler("billing")),
+	}
+	s.waitReady(t, publisher, topic, subs...)
+
+	for i := 0; i < messages; i++ {
+		require.NoError(t, publisher.Publish(topic, []byte(fmt.Sprintf("order-%d", i))))
+	}
+	for i := 0; i < 2*messages; i++ {
+		select {
+		case <-done:
+		case <-time.After(s.redeliveryTimeout):
+			t.Fatalf("received %d of %d messages", i, 2*messages)
+		}
+	}
+	time.Sleep(100 * time.Millisecond) // Let duplicates, if any, arrive.
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < messages; i++ {
+		data := fmt.Sprintf("order-%d", i)
+		assert.Equal(t, 1, received["first"][data]+received["second"][data], "%s is delivered to one orders subscriber", data)
+		assert.Equal(t, 1, received["billing"][data], "%s is delivered to billing", data)
+	}
+	t.Logf("orders subscribers received %d and %d messages", len(received["first"]), len(received["second"]))
+}
+
+// testAckAndRedelivery checks that a message left unacknowledged is delivered again, and an acknowledged one
+// is not, or that nothing is redelivered from an AtMostOnce backend. A RedeliveryOnReconnect backend delivers it
+// again to the next store of the service only.
+func (s *suite) testAckAndRedelivery(t *testing.T) {
+	topic := topic(t)
+	publisher := s.open(t, "publisher", topic)
+	subscriber := s.open(t, "subscriber", topic)
+
+	var mu sync.Mutex
+	deliveries := 0
+	events := make(chan axon.Event, 16)
+	sub := subscribe(subscriber, topic, func(e axon.Event) {
+		mu.Lock()
+		deliveries++
+		ack := deliveries > 1
+		mu.Unlock()
+		if ack {
+			e.Ack() // The first delivery is left unacknowledged.
+		}
+		events <- e
+	})
+	s.waitReady(t, publisher, topic, sub)
+
+	require.NoError(t, publisher.Publish(topic, []byte("redeliver-me")))
+	assert.Equal(t, "redeliver-me", string(receive(t, events, s.redeliveryTimeout).Data()))
+	if s.atMostOnce {
+		select {
+		case e := <-events:
+			t.Fatalf("an AtMostOnce backend delivered %q again", e.Data())
+		case <-time.After(3 * s.ackWait):
+		}
+		return
+	}
+	if s.onReconnect {
+		select {
+		case e := <-events:
+			t.Fatalf("a RedeliveryOnReconnect backend delivered %q again before its subscriber reconnected", e.Data())
+		case <-time.After(3 * s.ackWait):
+		}
+		require.NoError(t, subscriber.Close())
+		subscriber = s.open(t, "subscriber", topic)
+		subscribe(subscriber, topic, func(e axon.Event) {
+			e.Ack()
+			events <- e
+		})
+	}
+
+	assert.Equal(t, "redeliver-me", string(receive(t, events, s.redeliveryTimeout).Data()), "an unacknowledged message is delivered again")
+	select {
+	case e := <-events:
+		t.Fatalf("an acknowledged message was delivered again: %q", e.Data())
+	case <-time.After(3 * s.ackWait):
+	}
+}
+
+// testRequestReply checks replies, their headers, errors returned through the ReplyPayload and that each request
+// is handled once, however the backend acknowledges requests.
+func (s *suite) testRequestReply(t *testing.T) {
+	topic := topic(t)
+	caller := s.open(t, "caller", topic)
+	replier := s.open(t, "greeter", topic)
+	handled := s.reply(t, caller, replier, topic)
+
+	var out map[string]string
+	var headers map[string]string
+	require.NoError(t, caller.Request(topic, []byte(`{"Name":"axon"}`), &out, axon.WithHeader("language", "en"), axon.WithReplyHeaders(&headers)))
+	assert.Equal(t, map[string]string{"greeting": "hello axon", "from": "caller"}, out)
+	assert.Equal(t, "en", headers["language"], "the headers of the Response reach the caller")
+
+	err := caller.Request(topic, []byte(`{}`), &out)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "name is required")
+
+	time.Sleep(3 * s.ackWait)
+	assert.Equal(t, int64(2), handled(), "each request is handled once")
+}
+
+// testRequestTimeout checks that Request gives up once the timeout of the caller passed.
+func (s *suite) testRequestTimeout(t *testing.T) {
+	topic := topic(t)
+	caller := s.open(t, "caller", topic)
+	replier := s.open(t, "sleeper", topic)
+
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	go func() {
+		_ = replier.ReplyContext(topic, func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			var in greeting
+			if err := req.ParsePayload(&in); err != nil || !isProbe([]byte(in.Name)) {
+				<-release
+			}
+			return axon.Response{Payload: []byte(`{}`)}, nil
+		})
+	}()
+	probe, _ := json.Marshal(greeting{Name: probePrefix})
+	deadline := time.Now().Add(s.readyTimeout)
+	for caller.Request(topic, probe, &struct{}{}, axon.WithTimeout(500*time.Millisecond)) != nil {
+		require.True(t, time.Now().Before(deadline), "no reply on %s within %s", topic, s.readyTimeout)
+		time.Sleep(probeInterval)
+	}
+
+	const timeout = 200 * time.Millisecond
+	start := time.Now()
+	err := caller.Request(topic, []byte(`{}`), &struct{}{}, axon.WithTimeout(timeout))
+	require.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), timeout)
+	assert.Less(t, time.Since(start), timeout+2*time.Second, "Request returns soon after its timeout")
+	t.Logf("Request timed out with: %v", err)
+}
+
+// testConcurrency publishes and requests from several goroutines at once on shared stores.
+func (s *suite) testConcurrency(t *testing.T) {
+	const publishers, perPublisher, requests = 4, 25, 10
+	topic := topic(t)
+	publisher := s.open(t, "publisher", topic)
+	first, second := s.open(t, "orders", topic), s.open(t, "orders", topic)
+
+	var mu sync.Mutex
+	received := map[string]bool{}
+	done := make(chan struct{})
+	handler := func(e axon.Event) {
+		e.Ack()
+		mu.Lock()
+		defer mu.Unlock()
+		received[string(e.Data())] = true
+		if len(received) == publishers*perPublisher {
+			close(done)
+		}
+	}
+	s.waitReady(t, publisher, topic, subscribe(first, topic, handler), subscribe(second, topic, handler))
+
+	requestTopic := topic + ".requests"
+	replier := s.open(t, "greeter", requestTopic)
+	s.reply(t, publisher, replier, requestTopic)
+
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perPublisher; i++ {
+				assert.NoError(t, publisher.Publish(topic, []byte(fmt.Sprintf("%d-%d", p, i))))
+			}
+		}(p)
+	}
+	for r := 0; r < requests; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			name := fmt.Sprintf("caller-%d", r)
+			var out map[string]string
+			if assert.NoError(t, publisher.Request(requestTopic, []byte(`{"Name":"`+name+`"}`), &out)) {
+				assert.Equal(t, "hello "+name, out["greeting"])
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	select {
+	case <-done:
+	case <-time.After(s.redeliveryTimeout):
+		mu.Lock()
+		defer mu.Unlock()
+		t.Fatalf("received %d of %d messages", len(received), publishers*perPublisher)
+	}
+}
+
+// testClose checks that Close ends the blocking calls with axon.ErrCloseConn and reports the store closed.
+func (s *suite) testClose(t *testing.T) {
+	topic := topic(t)
+	publisher := s.open(t, "publisher", topic)
+	subscriber := s.open(t, "subscriber", topic)
+
+	sub := subscribe(subscriber, topic, func(e axon.Event) { e.Ack() })
+	s.waitReady(t, publisher, topic, sub)
+	assert.NoError(t, subscriber.Health())
+
+	require.NoError(t, subscriber.Close())
+	select {
+	case err := <-sub.returned:
+		assert.Equal(t, axon.ErrCloseConn, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe did not return after Close")
+	}
+	assert.Equal(t, axon.ErrCloseConn, subscriber.Health())
+	assert.Error(t, subscriber.Publish(topic, []byte("closed")), "Publish fails once the store is closed")
+}
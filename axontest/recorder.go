@@ -0,0 +1,328 @@
+package axontest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"time"
+)
+
+const defaultWaitTimeout = 5 * time.Second
+
+var (
+	ErrWaitTimeout     = errors.New("Sorry, the expected messages did not arrive in time")
+	ErrInjectedFailure = errors.New("Sorry, this failure was injected by the test")
+)
+
+// Message is a message recorded by a Recorder.
+type Message struct {
+	Topic string
+	Data  []byte
+	Time  time.Time
+}
+
+// Decode unmarshals the JSON data of the message into v.
+func (m Message) Decode(v interface{}) error {
+	return json.Unmarshal(m.Data, v)
+}
+
+// failure is a run of handler failures injected on a topic.
+type failure struct {
+	remaining int
+	err       error
+}
+
+// Recorder is an axon.EventStore decorating another store, usually a mem store, for unit tests. It records the
+// messages published, requested and received through it, answers requests on stubbed topics itself and makes
+// handlers fail on demand. Waiting on the recordings replaces sleeping in tests.
+type Recorder struct {
+	axon.EventStore
+	timeout time.Duration
+
+	mu        sync.Mutex
+	published map[string][]Message
+	requested map[string][]Message
+	received  map[string][]Message
+	stubs     map[string]axon.ContextReplyHandler
+	failures  map[string]*failure
+	changed   chan struct{} // Closed and replaced whenever a message is recorded.
+}
+
+type RecorderOption func(*Recorder)
+
+// WaitTimeout sets how long the Assert methods wait for a matching message. Defaults to five seconds.
+func WaitTimeout(d time.Duration) RecorderOption {
+	return func(r *Recorder) {
+		r.timeout = d
+	}
+}
+
+// Record returns a Recorder decorating store.
+func Record(store axon.EventStore, options ...RecorderOption) *Recorder {
+	r := &Recorder{
+		EventStore: store,
+		timeout:    defaultWaitTimeout,
+		published:  make(map[string][]Message),
+		requested:  make(map[string][]Message),
+		received:   make(map[string][]Message),
+		stubs:      make(map[string]axon.ContextReplyHandler),
+		failures:   make(map[string]*failure),
+		changed:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+func (r *Recorder) record(messages map[string][]Message, topic string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	messages[topic] = append(messages[topic], Message{Topic: topic, Data: append([]byte(nil), data...), Time: time.Now()})
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Publish records the message, then publishes it on the decorated store.
+func (r *Recorder) Publish(topic string, message []byte) error {
+	r.record(r.published, topic, message)
+	return r.EventStore.Publish(topic, message)
+}
+
+// Subscribe subscribes to topic on the decorated store, recording the events received. Events failed with
+// InjectFailures are neither acknowledged nor passed to handler.
+func (r *Recorder) Subscribe(topic string, handler axon.SubscriptionHandler) error {
+	return r.EventStore.Subscribe(topic, r.handler(topic, handler))
+}
+
+// handler records the events of topic passed to handler, dropping those failed with InjectFailures.
+func (r *Recorder) handler(topic string, handler axon.SubscriptionHandler) axon.SubscriptionHandler {
+	return func(event axon.Event) {
+		r.record(r.received, topic, event.Data())
+		if err := r.fail(topic); err != nil {
+			return
+		}
+		handler(event)
+	}
+}
+
+// Request records the payload, then answers it with the stub of topic if there is one, or requests it on the
+// decorated store.
+func (r *Recorder) Request(topic string, payload []byte, v interface{}, opts ...axon.RequestOption) error {
+	r.record(r.requested, topic, payload)
+	r.mu.Lock()
+	stub, ok := r.stubs[topic]
+	r.mu.Unlock()
+	if !ok {
+		return r.EventStore.Request(topic, payload, v, opts...)
+	}
+
+	req := axon.NewRequestPayload(topic, payload, opts...)
+	req.ServiceName = r.GetServiceName()
+	data, err := req.Compact()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout(r.timeout))
+	defer cancel()
+	replyChan := make(chan []byte, 1)
+	errChan := make(chan error, 1)
+	go func() {
+		_, out, err := axon.ServeRequest(topic, data, stub)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		replyChan <- out
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errChan:
+		return err
+	case out := <-replyChan:
+		var reply axon.ReplyPayload
+		if err := json.Unmarshal(out, &reply); err != nil {
+			return err
+		}
+		req.SetReplyHeaders(reply.Headers)
+		if err := reply.GetError(); err != nil {
+			return err
+		}
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(reply.GetPayload(), v)
+	}
+}
+
+func (r *Recorder) Reply(topic string, handler axon.ReplyHandler) error {
+	return r.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+// Replay replays topic with the decorated store, recording the events received like Subscribe, or fails with
+// axon.ErrReplayUnsupported when it is not an axon.Replayer.
+func (r *Recorder) Replay(topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	replayer, ok := r.EventStore.(axon.Replayer)
+	if !ok {
+		return axon.ErrReplayUnsupported
+	}
+	return replayer.Replay(topic, from, r.handler(topic, handler))
+}
+
+func (r *Recorder) ReplayContext(ctx context.Context, topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	return axon.Replay(ctx, r.EventStore, topic, from, r.handler(topic, handler))
+}
+
+// ReplyContext replies on topic through the decorated store, recording the requests received. Requests failed
+// with InjectFailures are answered with the injected error without running handler.
+func (r *Recorder) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	return r.EventStore.ReplyContext(topic, func(ctx context.Context, req axon.Request) (axon.Response, error) {
+		r.record(r.received, topic, req.Payload)
+		if err := r.fail(topic); err != nil {
+			return axon.Response{}, err
+		}
+		return handler(ctx, req)
+	})
+}
+
+// StubRequest answers the requests made through the recorder on topic with handler, without reaching the
+// decorated store. A nil handler removes the stub.
+func (r *Recorder) StubRequest(topic string, handler axon.ContextReplyHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if handler == nil {
+		delete(r.stubs, topic)
+		return
+	}
+	r.stubs[topic] = handler
+}
+
+// StubReply answers the requests made through the recorder on topic with v encoded as JSON, or with err.
+func (r *Recorder) StubReply(topic string, v interface{}, err error) {
+	r.StubRequest(topic, func(ctx context.Context, req axon.Request) (axon.Response, error) {
+		if err != nil {
+			return axon.Response{}, err
+		}
+		out, err := json.Marshal(v)
+		return axon.Response{Payload: out}, err
+	})
+}
+
+// InjectFailures makes the next n deliveries on topic fail, to exercise redelivery and error handling.
+// Subscription events are dropped unacknowledged and requests are answered with err, or ErrInjectedFailure
+// when err is nil.
+func (r *Recorder) InjectFailures(topic string, n int, err error) {
+	if err == nil {
+		err = ErrInjectedFailure
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[topic] = &failure{remaining: n, err: err}
+}
+
+func (r *Recorder) fail(topic string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.failures[topic]
+	if !ok || f.remaining <= 0 {
+		return nil
+	}
+	f.remaining--
+	return f.err
+}
+
+// Published returns the messages published on topic so far.
+func (r *Recorder) Published(topic string) []Message {
+	return r.messages(r.published, topic)
+}
+
+// Requested returns the payloads of the requests made on topic so far.
+func (r *Recorder) Requested(topic string) []Message {
+	return r.messages(r.requested, topic)
+}
+
+// Received returns the events and requests received on topic so far, failed deliveries included.
+func (r *Recorder) Received(topic string) []Message {
+	return r.messages(r.received, topic)
+}
+
+func (r *Recorder) messages(messages map[string][]Message, topic string) []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), messages[topic]...)
+}
+
+// WaitPublished waits until n messages were published on topic and returns them, or returns the messages
+// published so far with ErrWaitTimeout once timeout passed.
+func (r *Recorder) WaitPublished(topic string, n int, timeout time.Duration) ([]Message, error) {
+	return r.wait(r.published, topic, timeout, func(messages []Message) bool { return len(messages) >= n })
+}
+
+// WaitReceived waits until n events or requests were received on topic, like WaitPublished.
+func (r *Recorder) WaitReceived(topic string, n int, timeout time.Duration) ([]Message, error) {
+	return r.wait(r.received, topic, timeout, func(messages []Message) bool { return len(messages) >= n })
+}
+
+func (r *Recorder) wait(messages map[string][]Message, topic string, timeout time.Duration, done func([]Message) bool) ([]Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		r.mu.Lock()
+		recorded := append([]Message(nil), messages[topic]...)
+		changed := r.changed
+		r.mu.Unlock()
+		if done(recorded) {
+			return recorded, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return recorded, errors.Wrapf(ErrWaitTimeout, "%d messages on %s after %s", len(recorded), topic, timeout)
+		}
+	}
+}
+
+// AssertPublished asserts that a message matching match is published on topic within the wait timeout.
+func (r *Recorder) AssertPublished(t assert.TestingT, topic string, match func(Message) bool, msgAndArgs ...interface{}) bool {
+	return r.assertMatch(t, r.published, "published", topic, match, msgAndArgs...)
+}
+
+// AssertReceived asserts that an event or request matching match is received on topic within the wait timeout.
+func (r *Recorder) AssertReceived(t assert.TestingT, topic string, match func(Message) bool, msgAndArgs ...interface{}) bool {
+	return r.assertMatch(t, r.received, "received", topic, match, msgAndArgs...)
+}
+
+func (r *Recorder) assertMatch(t assert.TestingT, messages map[string][]Message, verb, topic string, match func(Message) bool, msgAndArgs ...interface{}) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	recorded, err := r.wait(messages, topic, r.timeout, func(messages []Message) bool {
+		for _, m := range messages {
+			if match(m) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		failure := fmt.Sprintf("no matching message %s on %s within %s, recorded: %q", verb, topic, r.timeout, dataOf(recorded))
+		return assert.Fail(t, failure, msgAndArgs...)
+	}
+	return true
+}
+
+func dataOf(messages []Message) []string {
+	data := make([]string, len(messages))
+	for i, m := range messages {
+		data[i] = string(m.Data)
+	}
+	return data
+}
@@ -0,0 +1,136 @@
+package axontest
+
+import (
+	"context"
+	"errors"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/filelog"
+	"github.com/Just4Ease/axon/mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newMemStore(t *testing.T, b *mem.Broker, serviceName string) axon.EventStore {
+	store, err := mem.Init(axon.Options{
+		ServiceName: serviceName,
+		Topics:      map[string]axon.TopicOptions{"order.created": {AckWait: 50 * time.Millisecond}},
+	}, mem.WithBroker(b))
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestRecorder_Publish(t *testing.T) {
+	b := mem.NewBroker()
+	orders := Record(newMemStore(t, b, "orders"), WaitTimeout(time.Second))
+	billing := Record(newMemStore(t, b, "billing"))
+	go func() { _ = billing.Subscribe("order.created", func(e axon.Event) { e.Ack() }) }()
+	time.Sleep(20 * time.Millisecond)
+
+	go func() {
+		_ = orders.Publish("order.created", []byte(`{"id":1}`))
+		_ = orders.Publish("order.created", []byte(`{"id":2}`))
+	}()
+	assert.True(t, orders.AssertPublished(t, "order.created", func(m Message) bool {
+		var order struct{ ID int }
+		return m.Decode(&order) == nil && order.ID == 2
+	}))
+	messages, err := orders.WaitPublished("order.created", 2, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, `{"id":1}`, string(messages[0].Data))
+
+	received, err := billing.WaitReceived("order.created", 2, time.Second)
+	require.Nil(t, err)
+	assert.Len(t, received, 2)
+
+	messages, err = orders.WaitPublished("order.created", 3, 20*time.Millisecond)
+	assert.ErrorIs(t, err, ErrWaitTimeout)
+	assert.Len(t, messages, 2)
+
+	mock := &assert.CollectT{}
+	assert.False(t, Record(orders, WaitTimeout(10*time.Millisecond)).AssertPublished(mock, "order.created", func(Message) bool { return true }))
+}
+
+func TestRecorder_StubRequest(t *testing.T) {
+	b := mem.NewBroker()
+	caller := Record(newMemStore(t, b, "caller"))
+	caller.StubReply("user.get", map[string]string{"name": "ada"}, nil)
+	caller.StubReply("user.delete", nil, errors.New("not allowed"))
+
+	var user map[string]string
+	require.Nil(t, caller.Request("user.get", []byte(`{"id":1}`), &user))
+	assert.Equal(t, map[string]string{"name": "ada"}, user)
+	assert.EqualError(t, caller.Request("user.delete", []byte(`{"id":1}`), &user), "not allowed")
+	assert.Equal(t, `{"id":1}`, string(caller.Requested("user.get")[0].Data))
+	require.Nil(t, caller.Request("user.get", []byte(`{"id":1}`), nil), "callers may ignore the reply")
+
+	caller.StubRequest("user.get", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+		time.Sleep(200 * time.Millisecond)
+		return axon.Response{Payload: []byte(`{}`)}, nil
+	})
+	err := caller.Request("user.get", []byte(`{}`), &user, axon.WithTimeout(20*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// Without a stub, the request goes to the decorated store.
+	caller.StubRequest("user.get", nil)
+	assert.Equal(t, mem.ErrNoReplier, caller.Request("user.get", []byte(`{}`), &user))
+}
+
+func TestRecorder_InjectFailures(t *testing.T) {
+	b := mem.NewBroker()
+	publisher := newMemStore(t, b, "publisher")
+	subscriber := Record(newMemStore(t, b, "orders"))
+	subscriber.InjectFailures("order.created", 1, nil)
+
+	handled := make(chan axon.Event, 2)
+	go func() {
+		_ = subscriber.Subscribe("order.created", func(e axon.Event) {
+			e.Ack()
+			handled <- e
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+
+	// The failed delivery is not acknowledged, so the message comes back after the ack wait.
+	received, err := subscriber.WaitReceived("order.created", 2, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, "#1", string(received[1].Data))
+	assert.Equal(t, "#1", string((<-handled).Data()))
+
+	replier := Record(newMemStore(t, b, "greeter"))
+	replier.InjectFailures("greet", 1, errors.New("unavailable"))
+	go func() {
+		_ = replier.Reply("greet", func(input []byte) ([]byte, error) { return []byte(`"hello"`), nil })
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	var out string
+	assert.EqualError(t, publisher.Request("greet", []byte(`{}`), &out), "unavailable")
+	require.Nil(t, publisher.Request("greet", []byte(`{}`), &out))
+	assert.Equal(t, "hello", out)
+}
+
+func TestRecorder_Replay(t *testing.T) {
+	store, err := filelog.Init(axon.Options{ServiceName: "orders", Address: t.TempDir()})
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	require.Nil(t, store.Publish("order.created", []byte("#0")))
+	require.Nil(t, store.Publish("order.created", []byte("#1")))
+
+	recorder := Record(store)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan axon.Event, 2)
+	go func() {
+		_ = axon.Replay(ctx, recorder, "order.created", axon.Earliest(), func(e axon.Event) { events <- e })
+	}()
+	received, err := recorder.WaitReceived("order.created", 2, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, "#1", string(received[1].Data))
+	assert.Equal(t, "#0", string((<-events).Data()))
+
+	assert.Equal(t, axon.ErrReplayUnsupported, Record(newMemStore(t, mem.NewBroker(), "orders")).Replay("order.created", axon.Earliest(), func(axon.Event) {}))
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidPayload    = errors.New("Sorry, request and reply payloads must be JSON")
+	ErrFollowUnsupported = errors.New("Sorry, this backend cannot follow a topic without a subscription, pass --service to subscribe as a service")
+)
+
+// readData returns arg, or the content of the file it names when it starts with @, or stdin for @-.
+func (c *cli) readData(arg string) ([]byte, error) {
+	if !strings.HasPrefix(arg, "@") {
+		return []byte(arg), nil
+	}
+	if arg == "@-" {
+		return ioutil.ReadAll(c.stdin)
+	}
+	return ioutil.ReadFile(arg[1:])
+}
+
+func publish(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("pub", "<topic> <data|@file>")
+	positional, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	data, err := c.readData(positional[1])
+	if err != nil {
+		return err
+	}
+
+	store, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.Publish(positional[0], data)
+}
+
+// subscribe prints the messages published on a topic from now on. Without --service it follows the topic with a
+// replay from axon.Latest, which leaves no durable subscription behind; with it, it joins the subscription of the
+// service and acknowledges what it prints.
+func subscribe(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("sub", "<topic>")
+	asJSON := fs.Bool("json", false, "print one JSON object per message instead of pretty-printing")
+	count := fs.Int("count", 0, "exit after receiving this many messages; unlimited when zero")
+	positional, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	durable := c.service != ""
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	store, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	p := &printer{w: c.stdout, json: *asJSON}
+	received := 0
+	done := make(chan struct{})
+	errChan := make(chan error, 1)
+	handler := func(event axon.Event) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if *count > 0 && received >= *count {
+			return // Left unacknowledged for the next subscriber.
+		}
+		p.print(event.Topic(), "", event.Data())
+		event.Ack()
+		if received++; received == *count {
+			close(done)
+		}
+	}
+	go func() {
+		if durable {
+			errChan <- store.Subscribe(positional[0], handler)
+			return
+		}
+		errChan <- axon.Replay(ctx, store, positional[0], axon.Latest(), handler)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-done:
+		return nil
+	case err := <-errChan:
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, axon.ErrReplayUnsupported) || errors.Is(err, axon.ErrUnsupportedPosition) {
+			return ErrFollowUnsupported
+		}
+		return err
+	}
+}
+
+// headers collects repeated -header key=value flags.
+type headers map[string]string
+
+func (h headers) String() string {
+	pairs := make([]string, 0, len(h))
+	for k, v := range h {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h headers) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if !ok || k == "" {
+		return errors.Errorf("invalid header %q, expected key=value", value)
+	}
+	h[k] = v
+	return nil
+}
+
+func request(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("req", "<topic> <payload|@file>")
+	timeout := fs.Duration("timeout", 0, "how long to wait for the reply; defaults to the request timeout of the store")
+	header := headers{}
+	fs.Var(header, "header", "request header as key=value; may be repeated")
+	positional, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	payload, err := c.readData(positional[1])
+	if err != nil {
+		return err
+	}
+	if !json.Valid(payload) {
+		return ErrInvalidPayload
+	}
+
+	var opts []axon.RequestOption
+	if *timeout > 0 {
+		opts = append(opts, axon.WithTimeout(*timeout))
+	}
+	for k, v := range header {
+		opts = append(opts, axon.WithHeader(k, v))
+	}
+
+	store, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var out json.RawMessage
+	if err := store.Request(positional[0], payload, &out, opts...); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.stdout, "%s\n", indent(out))
+	return err
+}
+
+func reply(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("reply", "<topic> --echo | --data <payload|@file>")
+	echo := fs.Bool("echo", false, "answer every request with its own payload")
+	data := fs.String("data", "", "answer every request with this JSON payload, read from a file with @file")
+	asJSON := fs.Bool("json", false, "print one JSON object per request instead of pretty-printing")
+	positional, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *echo == (*data != "") {
+		return &usageError{msg: "either --echo or --data is required"}
+	}
+
+	var response []byte
+	if *data != "" {
+		if response, err = c.readData(*data); err != nil {
+			return err
+		}
+		if !json.Valid(response) {
+			return ErrInvalidPayload
+		}
+	}
+
+	store, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	p := &printer{w: c.stdout, json: *asJSON}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- store.ReplyContext(positional[0], func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			p.mu.Lock()
+			p.print(req.Topic, req.ServiceName, req.Payload)
+			p.mu.Unlock()
+			if *echo {
+				return axon.Response{Payload: req.Payload}, nil
+			}
+			return axon.Response{Payload: response}, nil
+		})
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errChan:
+		return err
+	}
+}
+
+// printer writes the messages received by sub and reply. Callers hold mu.
+type printer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+}
+
+type printedMessage struct {
+	Time  time.Time   `json:"time"`
+	Topic string      `json:"topic"`
+	From  string      `json:"from,omitempty"`
+	Data  interface{} `json:"data"`
+}
+
+func (p *printer) print(topic, from string, data []byte) {
+	now := time.Now()
+	if p.json {
+		m := printedMessage{Time: now, Topic: topic, From: from, Data: string(data)}
+		if json.Valid(data) {
+			m.Data = json.RawMessage(data)
+		}
+		line, _ := json.Marshal(m)
+		fmt.Fprintf(p.w, "%s\n", line)
+		return
+	}
+
+	if from != "" {
+		topic += " from " + from
+	}
+	fmt.Fprintf(p.w, "[%s] %s\n%s\n", now.Format("15:04:05.000"), topic, indent(data))
+}
+
+// indent indents JSON data, and returns other data as is.
+func indent(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,177 @@
+// Command axon publishes, subscribes, requests and replies on any axon backend, to poke at a running system.
+//
+//	axon pub order.created '{"id":1}'
+//	axon sub order.created --json
+//	axon req user.get '{"id":1}'
+//	axon reply user.get --echo
+//
+// Connection settings come from the AXON_* environment variables read by axon.FromEnv, overridden by flags.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Just4Ease/axon"
+	_ "github.com/Just4Ease/axon/filelog"
+	_ "github.com/Just4Ease/axon/jet"
+	_ "github.com/Just4Ease/axon/kafka"
+	_ "github.com/Just4Ease/axon/mem"
+	_ "github.com/Just4Ease/axon/pulse"
+	_ "github.com/Just4Ease/axon/redisstream"
+	_ "github.com/Just4Ease/axon/stand"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `Usage: axon <command> [flags] <arguments>
+
+Commands:
+  pub <topic> <data|@file>      publish data, read from a file with @file or from stdin with @-
+  sub <topic>                   print the messages published on topic from now on until interrupted; joins the
+                                subscription of the service only with --service
+  req <topic> <payload|@file>   send a JSON request and print the payload of the reply
+  reply <topic> --echo          answer requests on topic with their own payload, or with --data
+
+Run "axon <command> -h" for the flags of a command.
+Connection flags default to the AXON_* environment variables, such as AXON_ADDRESS and AXON_CONFIG_FILE.
+`
+
+// defaultService is the service name of commands run without one. It is fixed, as durable backends keep a
+// consumer group or reply topic per service name, which a name per run would leave behind. sub joins no
+// subscription under it, it follows topics with a replay unless --service is given.
+const defaultService = "axon-cli"
+
+type command struct {
+	name string
+	run  func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = []command{
+	{name: "pub", run: publish},
+	{name: "sub", run: subscribe},
+	{name: "req", run: request},
+	{name: "reply", run: reply},
+}
+
+// usageError is reported with the usage of the command and exit code 2.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// cli holds the streams and the connection flags shared by every command.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	address, service, clusterID, config, token string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command named by args[0] and returns the exit code of the process.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(stderr, usage)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(ctx, &cli{stdin: stdin, stdout: stdout, stderr: stderr}, args[1:])
+		switch e := err.(type) {
+		case nil:
+			return 0
+		case *usageError:
+			fmt.Fprintf(stderr, "axon %s: %s\nRun \"axon %s -h\" for usage.\n", cmd.name, e.msg, cmd.name)
+			return 2
+		default:
+			if err == flag.ErrHelp {
+				return 0
+			}
+			fmt.Fprintf(stderr, "axon %s: %v\n", cmd.name, err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(stderr, "axon: unknown command %q\n\n%s", args[0], usage)
+	return 2
+}
+
+// flags returns the flag set of a command, with the connection flags.
+func (c *cli) flags(name, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: axon %s [flags] %s\n\nFlags:\n", name, arguments)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&c.address, "address", "", "URL of the broker, such as nats://localhost:4222 (AXON_ADDRESS)")
+	fs.StringVar(&c.service, "service", "", "service name; subscribers of the same service share the messages (AXON_SERVICE_NAME, default "+defaultService+")")
+	fs.StringVar(&c.clusterID, "cluster", "", "NATS Streaming cluster id (AXON_CLUSTER_ID)")
+	fs.StringVar(&c.config, "config", "", "YAML or JSON configuration file (AXON_CONFIG_FILE)")
+	fs.StringVar(&c.token, "token", "", "authentication token (AXON_TOKEN)")
+	return fs
+}
+
+// parse parses args with fs, accepting flags after the positional arguments as in `axon reply <topic> --echo`,
+// and checks that n positional arguments are left.
+func parse(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if len(positional) != n {
+		return nil, &usageError{msg: fmt.Sprintf("expected %d arguments, got %d", n, len(positional))}
+	}
+	return positional, nil
+}
+
+// connect opens the store configured by the configuration file, then the environment, then the connection
+// flags, later settings overriding earlier ones.
+func (c *cli) connect() (axon.EventStore, error) {
+	options := []axon.Option{axon.WithServiceName(defaultService)}
+	if c.config != "" {
+		options = append(options, axon.FromFile(c.config))
+	}
+	options = append(options, axon.FromEnv())
+	if c.address != "" {
+		options = append(options, axon.WithAddress(c.address))
+	}
+	if c.service != "" {
+		options = append(options, axon.WithServiceName(c.service))
+	}
+	if c.clusterID != "" {
+		options = append(options, axon.WithClusterID(c.clusterID))
+	}
+	if c.token != "" {
+		options = append(options, axon.WithToken(c.token))
+	}
+
+	return axon.Connect(options...)
+}
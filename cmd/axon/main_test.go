@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// output is an io.Writer safe to read while a command writes to it.
+type output struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *output) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+func runCommand(ctx context.Context, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr output
+	code := run(ctx, args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestPubSub(t *testing.T) {
+	address := "file://" + t.TempDir()
+	dataFile := filepath.Join(t.TempDir(), "order.json")
+	require.Nil(t, os.WriteFile(dataFile, []byte(`{"id":2}`), 0o600))
+
+	type result struct {
+		code           int
+		stdout, stderr string
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, stdout, stderr := runCommand(context.Background(), "", "sub", "order.created", "--json", "--count", "3", "-address", address)
+		done <- result{code, stdout, stderr}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	for _, data := range []string{`{"id":1}`, "@" + dataFile, "@-"} {
+		code, _, stderr := runCommand(context.Background(), "plain text", "pub", "-address", address, "order.created", data)
+		require.Equal(t, 0, code, stderr)
+	}
+
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sub did not exit after --count messages")
+	}
+	require.Equal(t, 0, r.code, r.stderr)
+
+	var data []interface{}
+	for _, line := range strings.Split(strings.TrimSpace(r.stdout), "\n") {
+		var m printedMessage
+		require.Nil(t, json.Unmarshal([]byte(line), &m), line)
+		assert.Equal(t, "order.created", m.Topic)
+		data = append(data, m.Data)
+	}
+	assert.ElementsMatch(t, []interface{}{
+		map[string]interface{}{"id": float64(1)},
+		map[string]interface{}{"id": float64(2)},
+		"plain text",
+	}, data)
+}
+
+func TestRequestReply(t *testing.T) {
+	address := "mem://" + t.Name()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	replied := make(chan int, 1)
+	replierOut := &output{}
+	go func() {
+		replied <- run(ctx, []string{"reply", "user.get", "--echo", "-address", address, "-service", "users"}, nil, replierOut, &output{})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	code, stdout, stderr := runCommand(context.Background(), "", "req", "-address", address, "-service", "gateway", "-header", "trace=1", "user.get", `{"id":1}`)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "{\n  \"id\": 1\n}\n", stdout)
+	assert.Contains(t, replierOut.String(), "user.get from gateway")
+
+	code, _, stderr = runCommand(context.Background(), "", "req", "-address", address, "user.get", "not json")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, ErrInvalidPayload.Error())
+
+	cancel()
+	select {
+	case code := <-replied:
+		assert.Equal(t, 0, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reply did not exit once interrupted")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := runCommand(context.Background(), "")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "Usage: axon <command>")
+
+	code, _, stderr = runCommand(context.Background(), "", "publish")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown command "publish"`)
+
+	code, _, stderr = runCommand(context.Background(), "", "reply", "user.get")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "either --echo or --data is required")
+
+	code, _, stderr = runCommand(context.Background(), "", "pub", "order.created")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "expected 2 arguments, got 1")
+
+	code, _, stderr = runCommand(context.Background(), "", "sub", "-h")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stderr, "Usage: axon sub [flags] <topic>")
+}
+
+func TestConnect_DefaultService(t *testing.T) {
+	c := &cli{address: "mem://" + t.Name()}
+	// Every run shares the same name, so durable backends keep one reply group rather than one per run.
+	for i := 0; i < 2; i++ {
+		store, err := c.connect()
+		require.Nil(t, err)
+		assert.Equal(t, defaultService, store.GetServiceName())
+		require.Nil(t, store.Close())
+	}
+
+	c.service = "orders"
+	store, err := c.connect()
+	require.Nil(t, err)
+	defer store.Close()
+	assert.Equal(t, "orders", store.GetServiceName())
+}
+
+func TestSub_Durable(t *testing.T) {
+	address := "file://" + t.TempDir()
+	code, _, stderr := runCommand(context.Background(), "", "pub", "-address", address, "-service", "orders", "order.created", "before")
+	require.Equal(t, 0, code, stderr)
+
+	// Without --service nothing is left behind for the next run: messages published meanwhile are not kept.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	code, stdout, stderr := runCommand(ctx, "", "sub", "order.created", "-address", address)
+	require.Equal(t, 0, code, stderr)
+	assert.Empty(t, stdout)
+
+	// With it, the subscription of the service holds the messages published while nobody reads them.
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	code, _, stderr = runCommand(ctx, "", "sub", "order.created", "-address", address, "-service", "billing")
+	require.Equal(t, 0, code, stderr)
+	code, _, stderr = runCommand(context.Background(), "", "pub", "-address", address, "order.created", "held")
+	require.Equal(t, 0, code, stderr)
+	code, stdout, stderr = runCommand(context.Background(), "", "sub", "order.created", "--count", "1", "-address", address, "-service", "billing")
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "held")
+}
+
+func TestSub_ReplayUnsupported(t *testing.T) {
+	code, _, stderr := runCommand(context.Background(), "", "sub", "order.created", "-address", "mem://"+t.Name())
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, ErrFollowUnsupported.Error())
+}
@@ -0,0 +1,219 @@
+// Package compress compresses the payloads of an axon.EventStore transparently.
+//
+// Payloads over a threshold are compressed with gzip, snappy or zstd before being published, requested or
+// replied, and marked with the algorithm used: published payloads in a versioned frame, requests and replies in
+// the Header header. Stores wrapped by this package decompress the marked payloads they receive whatever the
+// algorithm, and pass the others on untouched, so services can enable compression one at a time. Services reading
+// the topics without it see the compressed payloads, while replies are only compressed for callers sending the
+// AcceptHeader header.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("Sorry, this compression algorithm is not supported")
+	ErrCorruptPayload   = errors.New("Sorry, the compressed payload is corrupt")
+)
+
+// Algorithm is a compression algorithm.
+type Algorithm string
+
+const (
+	Gzip   Algorithm = "gzip"
+	Snappy Algorithm = "snappy"
+	Zstd   Algorithm = "zstd"
+)
+
+// Published payloads compressed by this package are framed as follows, integers being big endian:
+//
+//	"\x00axc" | version (1 byte) | algorithm id (1 byte) | decompressed length (4 bytes) | compressed payload
+//
+// The version tells future framings apart, and the length is checked once decompressed, so a payload which
+// merely starts like a frame fails with ErrCorruptPayload instead of being handed over garbled.
+const (
+	magic      = "\x00axc"
+	version    = 1
+	headerSize = len(magic) + 1 + 1 + 4
+)
+
+// Header names the algorithm a request payload, or the payload of the Response to a request, was compressed
+// with. Compressed request and reply payloads are JSON strings holding the base64 encoded compressed bytes, as
+// they must stay JSON.
+const Header = "axon-compression"
+
+// AcceptHeader lists the algorithms the caller of a request decompresses, separated by commas. Replies are only
+// compressed for callers sending it, so services can wrap their stores one at a time.
+const AcceptHeader = "axon-accept-compression"
+
+var ids = map[Algorithm]byte{Gzip: 1, Snappy: 2, Zstd: 3}
+
+// accepted is the AcceptHeader sent by wrapped stores, which decompress every algorithm.
+const accepted = "gzip,snappy,zstd"
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the zstd encoder and decoder, shared as they are safe for concurrent use.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+func compress(algorithm Algorithm, data []byte) ([]byte, error) {
+	switch algorithm {
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Snappy:
+		return snappy.Encode(nil, data), nil
+	case Zstd:
+		encoder, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	}
+	return nil, errors.Wrap(ErrUnknownAlgorithm, string(algorithm))
+}
+
+func decompress(algorithm Algorithm, data []byte) ([]byte, error) {
+	var out []byte
+	var err error
+	switch algorithm {
+	case Gzip:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			out, err = ioutil.ReadAll(r)
+		}
+	case Snappy:
+		out, err = snappy.Decode(nil, data)
+	case Zstd:
+		var decoder *zstd.Decoder
+		if _, decoder, err = zstdCodec(); err != nil {
+			return nil, err
+		}
+		out, err = decoder.DecodeAll(data, nil)
+	default:
+		return nil, errors.Wrap(ErrUnknownAlgorithm, string(algorithm))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(ErrCorruptPayload, "%s: %v", algorithm, err)
+	}
+	return out, nil
+}
+
+// encode compresses a published payload of threshold bytes or more, unless compressing does not make it smaller.
+func encode(algorithm Algorithm, threshold int, data []byte) ([]byte, error) {
+	if len(data) < threshold || int64(len(data)) > math.MaxUint32 {
+		return data, nil
+	}
+	compressed, err := compress(algorithm, data)
+	if err != nil {
+		return nil, err
+	}
+	if headerSize+len(compressed) >= len(data) {
+		return data, nil
+	}
+	out := make([]byte, headerSize, headerSize+len(compressed))
+	copy(out, magic)
+	out[len(magic)] = version
+	out[len(magic)+1] = ids[algorithm]
+	binary.BigEndian.PutUint32(out[len(magic)+2:], uint32(len(data)))
+	return append(out, compressed...), nil
+}
+
+// decode decompresses a published payload compressed by encode, and returns the others as they are.
+func decode(data []byte) ([]byte, error) {
+	if len(data) < headerSize || string(data[:len(magic)]) != magic {
+		return data, nil
+	}
+	if data[len(magic)] != version {
+		return nil, errors.Wrapf(ErrCorruptPayload, "unknown version %d", data[len(magic)])
+	}
+	id := data[len(magic)+1]
+	for algorithm, known := range ids {
+		if id != known {
+			continue
+		}
+		out, err := decompress(algorithm, data[headerSize:])
+		if err != nil {
+			return nil, err
+		}
+		if n := binary.BigEndian.Uint32(data[len(magic)+2:]); uint64(len(out)) != uint64(n) {
+			return nil, errors.Wrapf(ErrCorruptPayload, "%s: %d bytes decompressed instead of %d", algorithm, len(out), n)
+		}
+		return out, nil
+	}
+	return nil, errors.Wrapf(ErrUnknownAlgorithm, "id %d", id)
+}
+
+// encodeJSON compresses a request or reply payload like encode, into a JSON string. It tells whether the payload
+// was compressed, to be marked with Header.
+func encodeJSON(algorithm Algorithm, threshold int, data []byte) ([]byte, bool, error) {
+	if len(data) < threshold {
+		return data, false, nil
+	}
+	compressed, err := compress(algorithm, data)
+	if err != nil {
+		return nil, false, err
+	}
+	out, err := json.Marshal(compressed)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(out) >= len(data) {
+		return data, false, nil
+	}
+	return out, true, nil
+}
+
+// decodeJSON decompresses a request or reply payload compressed by encodeJSON with algorithm, as named by Header.
+// Payloads without algorithm are returned as they are.
+func decodeJSON(algorithm Algorithm, data []byte) ([]byte, error) {
+	if algorithm == "" {
+		return data, nil
+	}
+	var compressed []byte
+	if err := json.Unmarshal(data, &compressed); err != nil {
+		return nil, errors.Wrap(ErrCorruptPayload, err.Error())
+	}
+	return decompress(algorithm, compressed)
+}
+
+// accepts tells whether the AcceptHeader header lists algorithm.
+func accepts(header string, algorithm Algorithm) bool {
+	for _, name := range strings.Split(header, ",") {
+		if Algorithm(strings.TrimSpace(name)) == algorithm {
+			return true
+		}
+	}
+	return false
+}
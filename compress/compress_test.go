@@ -0,0 +1,182 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/Just4Ease/axon/mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var document = []byte(`{"items":[` + strings.Repeat(`{"sku":"A-1","name":"large json document","quantity":1},`, 100) + `{}]}`)
+
+func TestEncodeDecode(t *testing.T) {
+	for _, algorithm := range []Algorithm{Gzip, Snappy, Zstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			encoded, err := encode(algorithm, 10, document)
+			require.Nil(t, err)
+			assert.True(t, bytes.HasPrefix(encoded, []byte(magic)))
+			assert.Less(t, len(encoded), len(document))
+			decoded, err := decode(encoded)
+			require.Nil(t, err)
+			assert.Equal(t, document, decoded)
+
+			encoded, compressed, err := encodeJSON(algorithm, 10, document)
+			require.Nil(t, err)
+			assert.True(t, compressed)
+			assert.True(t, json.Valid(encoded), "request and reply payloads stay JSON")
+			decoded, err = decodeJSON(algorithm, encoded)
+			require.Nil(t, err)
+			assert.Equal(t, document, decoded)
+		})
+	}
+}
+
+func TestEncode_Threshold(t *testing.T) {
+	small := []byte(`{"id":1}`)
+	encoded, err := encode(Zstd, 1024, small)
+	require.Nil(t, err)
+	assert.Equal(t, small, encoded)
+
+	// Compressing a payload which does not shrink would only cost the consumers.
+	random := []byte(axon.GenerateRandomString() + axon.GenerateRandomString())
+	encoded, err = encode(Gzip, 10, random)
+	require.Nil(t, err)
+	assert.Equal(t, random, encoded)
+
+	decoded, err := decode(small)
+	require.Nil(t, err)
+	assert.Equal(t, small, decoded)
+	encoded, compressed, err := encodeJSON(Zstd, 1024, small)
+	require.Nil(t, err)
+	assert.False(t, compressed)
+	assert.Equal(t, small, encoded)
+	decoded, err = decodeJSON("", small)
+	require.Nil(t, err)
+	assert.Equal(t, small, decoded)
+}
+
+func TestDecode_Corrupt(t *testing.T) {
+	_, err := decode([]byte(magic + "\x01\x03\x00\x00\x00\x07garbage"))
+	assert.ErrorIs(t, err, ErrCorruptPayload)
+	_, err = decode([]byte(magic + "\x01\x09\x00\x00\x00\x07garbage"))
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+	_, err = decode([]byte(magic + "\x02\x01\x00\x00\x00\x07garbage"))
+	assert.ErrorIs(t, err, ErrCorruptPayload, "unknown version")
+	_, err = decodeJSON("lz4", []byte(`"AAAA"`))
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+	_, err = decodeJSON(Gzip, []byte(`{"data":"AAAA"}`))
+	assert.ErrorIs(t, err, ErrCorruptPayload)
+
+	// The decompressed length is checked against the frame.
+	encoded, err := encode(Snappy, 10, document)
+	require.Nil(t, err)
+	encoded[len(magic)+5]++
+	_, err = decode(encoded)
+	assert.ErrorIs(t, err, ErrCorruptPayload)
+
+	// Payloads too short to be a frame are not one.
+	decoded, err := decode([]byte(magic + "\x01"))
+	require.Nil(t, err)
+	assert.Equal(t, []byte(magic+"\x01"), decoded)
+}
+
+func TestWrap_UnknownAlgorithm(t *testing.T) {
+	store, err := mem.Init(axon.Options{ServiceName: "orders"}, mem.WithBroker(mem.NewBroker()))
+	require.Nil(t, err)
+	defer store.Close()
+	_, err = Wrap(store, "lz4")
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
+
+func TestStore(t *testing.T) {
+	broker := mem.NewBroker()
+	newStore := func(name string, algorithm Algorithm) (*Store, *axontest.Recorder) {
+		store, err := mem.Init(axon.Options{ServiceName: name}, mem.WithBroker(broker))
+		require.Nil(t, err)
+		t.Cleanup(func() { store.Close() })
+		recorder := axontest.Record(store)
+		wrapped, err := Wrap(recorder, algorithm, Threshold(512))
+		require.Nil(t, err)
+		return wrapped, recorder
+	}
+	orders, sent := newStore("orders", Gzip)
+	billing, received := newStore("billing", Zstd)
+
+	t.Run("PublishSubscribe", func(t *testing.T) {
+		go billing.Subscribe("order.created", func(e axon.Event) {
+			e.Ack()
+		})
+		time.Sleep(50 * time.Millisecond)
+		require.Nil(t, orders.Publish("order.created", document))
+		require.Nil(t, orders.Publish("order.created", []byte(`{"small":true}`)))
+
+		published := sent.Published("order.created")
+		require.Len(t, published, 2)
+		assert.True(t, bytes.HasPrefix(published[0].Data, []byte(magic)))
+		assert.Equal(t, `{"small":true}`, string(published[1].Data))
+
+		got, err := received.WaitReceived("order.created", 2, time.Second)
+		require.Nil(t, err)
+		assert.True(t, bytes.HasPrefix(got[0].Data, []byte(magic)) || bytes.HasPrefix(got[1].Data, []byte(magic)))
+	})
+
+	t.Run("DecompressesForHandlers", func(t *testing.T) {
+		data := make(chan []byte, 1)
+		go billing.Subscribe("invoice.created", func(e axon.Event) {
+			e.Ack()
+			data <- e.Data()
+		})
+		time.Sleep(50 * time.Millisecond)
+		require.Nil(t, orders.Publish("invoice.created", document))
+		select {
+		case d := <-data:
+			assert.Equal(t, document, d)
+		case <-time.After(time.Second):
+			t.Fatal("handler did not run")
+		}
+	})
+
+	t.Run("RequestReply", func(t *testing.T) {
+		go billing.ReplyContext("invoice.render", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			assert.Equal(t, document, req.Payload)
+			return axon.Response{Payload: document}, nil
+		})
+		time.Sleep(50 * time.Millisecond)
+
+		var reply map[string]interface{}
+		var headers map[string]string
+		require.Nil(t, orders.Request("invoice.render", document, &reply, axon.WithReplyHeaders(&headers)))
+		assert.Len(t, reply["items"], 101)
+		assert.Equal(t, "zstd", headers[Header])
+		requested := sent.Requested("invoice.render")
+		require.Len(t, requested, 1)
+		assert.True(t, json.Valid(requested[0].Data))
+		assert.Less(t, len(requested[0].Data), len(document))
+
+		// Callers without compression get the reply as it is.
+		var plain map[string]interface{}
+		require.Nil(t, sent.Request("invoice.render", document, &plain, axon.WithReplyHeaders(&headers)))
+		assert.Len(t, plain["items"], 101)
+		assert.Equal(t, "", headers[Header])
+	})
+
+	t.Run("UnmarkedPayloads", func(t *testing.T) {
+		// A reply which looks like a compressed payload is only decompressed when marked by the Header header.
+		lookalike := []byte(`{"axon_compression":"gzip","data":"AAAA"}`)
+		go received.Reply("invoice.lookalike", func([]byte) ([]byte, error) {
+			return lookalike, nil
+		})
+		time.Sleep(50 * time.Millisecond)
+
+		var reply json.RawMessage
+		require.Nil(t, orders.Request("invoice.lookalike", []byte(`{}`), &reply))
+		assert.Equal(t, string(lookalike), string(reply))
+	})
+}
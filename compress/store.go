@@ -0,0 +1,128 @@
+package compress
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/internal/payload"
+	"github.com/pkg/errors"
+)
+
+const defaultThreshold = 1024
+
+// Store is an axon.EventStore decorating another store to compress the payloads it sends and decompress the
+// payloads it receives.
+type Store struct {
+	axon.EventStore
+	algorithm Algorithm
+	threshold int
+}
+
+type Option func(*Store)
+
+// Threshold sets the size in bytes from which payloads are compressed. Defaults to 1KB, below which compressing
+// seldom pays off.
+func Threshold(n int) Option {
+	return func(s *Store) {
+		s.threshold = n
+	}
+}
+
+// Wrap returns a Store compressing the payloads sent through store with algorithm.
+func Wrap(store axon.EventStore, algorithm Algorithm, options ...Option) (*Store, error) {
+	if _, ok := ids[algorithm]; !ok {
+		return nil, errors.Wrap(ErrUnknownAlgorithm, string(algorithm))
+	}
+	s := &Store{EventStore: store, algorithm: algorithm, threshold: defaultThreshold}
+	for _, option := range options {
+		option(s)
+	}
+	return s, nil
+}
+
+// handler decompresses the events passed to handler. Decompression only fails on payloads which never will
+// decompress.
+func (s *Store) handler(topic string, handler axon.SubscriptionHandler) axon.SubscriptionHandler {
+	return payload.Handler(topic, decode, func(error) bool { return true }, handler)
+}
+
+func (s *Store) Publish(topic string, message []byte) error {
+	data, err := encode(s.algorithm, s.threshold, message)
+	if err != nil {
+		return err
+	}
+	return s.EventStore.Publish(topic, data)
+}
+
+func (s *Store) Subscribe(topic string, handler axon.SubscriptionHandler) error {
+	return s.EventStore.Subscribe(topic, s.handler(topic, handler))
+}
+
+// Request compresses the payload and decompresses the reply before decoding it into v. The algorithms used are
+// named by the Header header of the request and of the reply.
+func (s *Store) Request(topic string, message []byte, v interface{}, opts ...axon.RequestOption) error {
+	data, compressed, err := encodeJSON(s.algorithm, s.threshold, message)
+	if err != nil {
+		return err
+	}
+	var headers map[string]string
+	opts = append(opts[:len(opts):len(opts)], axon.WithHeader(AcceptHeader, accepted), axon.WithReplyHeaders(&headers))
+	if compressed {
+		opts = append(opts, axon.WithHeader(Header, string(s.algorithm)))
+	}
+	var reply json.RawMessage
+	if err := s.EventStore.Request(topic, data, &reply, opts...); err != nil {
+		return err
+	}
+	if v == nil || len(reply) == 0 {
+		return nil
+	}
+	out, err := decodeJSON(Algorithm(headers[Header]), reply)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(out, v)
+}
+
+func (s *Store) Reply(topic string, handler axon.ReplyHandler) error {
+	return s.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+// ReplyContext decompresses the requests passed to handler and compresses its replies to callers sending the
+// AcceptHeader header.
+func (s *Store) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	return s.EventStore.ReplyContext(topic, func(ctx context.Context, req axon.Request) (axon.Response, error) {
+		data, err := decodeJSON(Algorithm(req.Headers[Header]), req.Payload)
+		if err != nil {
+			return axon.Response{}, err
+		}
+		req.Payload = data
+		res, err := handler(ctx, req)
+		if err != nil || !accepts(req.Headers[AcceptHeader], s.algorithm) {
+			return res, err
+		}
+		data, compressed, err := encodeJSON(s.algorithm, s.threshold, res.Payload)
+		if err != nil {
+			return axon.Response{}, err
+		}
+		if compressed {
+			res.Payload = data
+			res.Headers = payload.WithHeader(res.Headers, Header, string(s.algorithm))
+		}
+		return res, nil
+	})
+}
+
+// Replay replays topic with the decorated store, decompressing the events, or fails with
+// axon.ErrReplayUnsupported when it is not an axon.Replayer.
+func (s *Store) Replay(topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	r, ok := s.EventStore.(axon.Replayer)
+	if !ok {
+		return axon.ErrReplayUnsupported
+	}
+	return r.Replay(topic, from, s.handler(topic, handler))
+}
+
+func (s *Store) ReplayContext(ctx context.Context, topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	return axon.Replay(ctx, s.EventStore, topic, from, s.handler(topic, handler))
+}
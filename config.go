@@ -0,0 +1,413 @@
+package axon
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix prefixes every environment variable read by FromEnv.
+const EnvPrefix = "AXON_"
+
+var (
+	ErrInvalidValue  = errors.New("Sorry, you have provided an invalid value")
+	ErrMissingValue  = errors.New("Sorry, you must provide a value")
+	ErrInvalidConfig = errors.New("Sorry, the configuration file could not be read")
+)
+
+// FieldError reports which configuration field is invalid. It unwraps to the underlying error, such as
+// ErrInvalidURL or ErrEmptyStoreName.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+func fieldError(field string, err error, format string, args ...interface{}) error {
+	if format != "" {
+		err = errors.Wrap(err, fmt.Sprintf(format, args...))
+	}
+	return &FieldError{Field: field, Err: err}
+}
+
+// Option sets part of the Options of a store.
+type Option func(*Options) error
+
+// NewOptions applies opts in order, so later options override earlier ones, and validates the result.
+func NewOptions(opts ...Option) (Options, error) {
+	var o Options
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return Options{}, err
+		}
+	}
+	return o, o.Validate()
+}
+
+// Connect builds the options from opts and opens the store registered for the scheme of their address.
+func Connect(opts ...Option) (EventStore, error) {
+	o, err := NewOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return Open(o.Address, o)
+}
+
+func WithServiceName(name string) Option {
+	return func(o *Options) error {
+		o.ServiceName = name
+		return nil
+	}
+}
+
+func WithAddress(address string) Option {
+	return func(o *Options) error {
+		o.Address = address
+		return nil
+	}
+}
+
+func WithClusterID(clusterID string) Option {
+	return func(o *Options) error {
+		o.ClusterID = clusterID
+		return nil
+	}
+}
+
+func WithToken(token string) Option {
+	return func(o *Options) error {
+		o.AuthenticationToken = token
+		return nil
+	}
+}
+
+func WithAuth(auth AuthOptions) Option {
+	return func(o *Options) error {
+		o.Auth = &auth
+		return nil
+	}
+}
+
+func WithTLS(tls TLSOptions) Option {
+	return func(o *Options) error {
+		o.TLS = &tls
+		return nil
+	}
+}
+
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		o.RequestTimeout = timeout
+		return nil
+	}
+}
+
+func WithConcurrency(n int) Option {
+	return func(o *Options) error {
+		o.Concurrency = n
+		return nil
+	}
+}
+
+// WithTopic sets the overrides of a single topic.
+func WithTopic(topic string, t TopicOptions) Option {
+	return func(o *Options) error {
+		if o.Topics == nil {
+			o.Topics = make(map[string]TopicOptions)
+		}
+		o.Topics[topic] = t
+		return nil
+	}
+}
+
+// FromFile loads options from a YAML or JSON file. Fields absent from the file are left untouched.
+func FromFile(path string) Option {
+	return func(o *Options) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(ErrInvalidConfig, err.Error())
+		}
+		// JSON documents are valid YAML, so a single decoder reads both formats.
+		if err := yaml.Unmarshal(data, o); err != nil {
+			return errors.Wrap(ErrInvalidConfig, fmt.Sprintf("%s: %v", path, err))
+		}
+		return nil
+	}
+}
+
+// FromEnv loads options from AXON_* environment variables. When AXON_CONFIG_FILE is set, that file is loaded
+// first and the other variables override it. Unset variables leave their fields untouched.
+func FromEnv() Option {
+	return func(o *Options) error {
+		if path := os.Getenv(EnvPrefix + "CONFIG_FILE"); path != "" {
+			if err := FromFile(path)(o); err != nil {
+				return err
+			}
+		}
+
+		env := envReader{}
+		env.string("SERVICE_NAME", &o.ServiceName)
+		env.string("ADDRESS", &o.Address)
+		env.string("CLUSTER_ID", &o.ClusterID)
+		env.string("TOKEN", &o.AuthenticationToken)
+		env.duration("REQUEST_TIMEOUT", &o.RequestTimeout)
+		env.int("CONCURRENCY", &o.Concurrency)
+
+		auth := AuthOptions{}
+		if o.Auth != nil {
+			auth = *o.Auth
+		}
+		oauth2 := OAuth2Options{}
+		if auth.OAuth2 != nil {
+			oauth2 = *auth.OAuth2
+		}
+		tls := TLSOptions{}
+		if o.TLS != nil {
+			tls = *o.TLS
+		}
+
+		authSet := env.string("USERNAME", &auth.Username)
+		authSet = env.string("PASSWORD", &auth.Password) || authSet
+		authSet = env.string("CREDENTIALS_FILE", &auth.CredentialsFile) || authSet
+		authSet = env.string("NKEY_SEED_FILE", &auth.NKeySeedFile) || authSet
+		authSet = env.string("TOKEN_FILE", &auth.TokenFile) || authSet
+
+		oauth2Set := env.string("OAUTH2_ISSUER_URL", &oauth2.IssuerURL)
+		oauth2Set = env.string("OAUTH2_KEY_FILE", &oauth2.KeyFile) || oauth2Set
+		oauth2Set = env.string("OAUTH2_TOKEN_URL", &oauth2.TokenURL) || oauth2Set
+		oauth2Set = env.string("OAUTH2_CLIENT_ID", &oauth2.ClientID) || oauth2Set
+		oauth2Set = env.string("OAUTH2_CLIENT_SECRET", &oauth2.ClientSecret) || oauth2Set
+		oauth2Set = env.string("OAUTH2_AUDIENCE", &oauth2.Audience) || oauth2Set
+		oauth2Set = env.list("OAUTH2_SCOPES", &oauth2.Scopes) || oauth2Set
+
+		tlsSet := env.string("TLS_CA_CERT_FILE", &tls.CACertFile)
+		tlsSet = env.string("TLS_CLIENT_CERT_FILE", &tls.ClientCertFile) || tlsSet
+		tlsSet = env.string("TLS_CLIENT_KEY_FILE", &tls.ClientKeyFile) || tlsSet
+		tlsSet = env.string("TLS_SERVER_NAME", &tls.ServerName) || tlsSet
+		tlsSet = env.bool("TLS_INSECURE_SKIP_VERIFY", &tls.InsecureSkipVerify) || tlsSet
+
+		if oauth2Set {
+			auth.OAuth2 = &oauth2
+		}
+		if authSet || oauth2Set {
+			o.Auth = &auth
+		}
+		if tlsSet {
+			o.TLS = &tls
+		}
+		return env.err
+	}
+}
+
+// envReader reads AXON_* variables into fields, keeping the first parse error.
+type envReader struct {
+	err error
+}
+
+func (r *envReader) lookup(name string) (string, bool) {
+	value, ok := os.LookupEnv(EnvPrefix + name)
+	return strings.TrimSpace(value), ok
+}
+
+func (r *envReader) string(name string, field *string) bool {
+	value, ok := r.lookup(name)
+	if ok {
+		*field = value
+	}
+	return ok
+}
+
+func (r *envReader) list(name string, field *[]string) bool {
+	value, ok := r.lookup(name)
+	if ok {
+		*field = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	}
+	return ok
+}
+
+func (r *envReader) duration(name string, field *time.Duration) bool {
+	value, ok := r.lookup(name)
+	if !ok {
+		return false
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		r.fail(name, err)
+		return false
+	}
+	*field = d
+	return true
+}
+
+func (r *envReader) int(name string, field *int) bool {
+	value, ok := r.lookup(name)
+	if !ok {
+		return false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		r.fail(name, err)
+		return false
+	}
+	*field = n
+	return true
+}
+
+func (r *envReader) bool(name string, field *bool) bool {
+	value, ok := r.lookup(name)
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		r.fail(name, err)
+		return false
+	}
+	*field = b
+	return true
+}
+
+func (r *envReader) fail(name string, err error) {
+	if r.err == nil {
+		r.err = fieldError(EnvPrefix+name, ErrInvalidValue, "%v", err)
+	}
+}
+
+// Validate checks the options and returns a *FieldError naming the first invalid field.
+func (o Options) Validate() error {
+	if strings.TrimSpace(o.ServiceName) == "" {
+		return fieldError("service_name", ErrEmptyStoreName, "")
+	}
+
+	address := strings.TrimSpace(o.Address)
+	if address == "" {
+		return fieldError("address", ErrInvalidURL, "")
+	}
+	if u, err := url.Parse(address); err != nil || u.Scheme == "" || u.Opaque != "" {
+		return fieldError("address", ErrInvalidURL, "%q is not a URL such as scheme://host:port", address)
+	}
+
+	if o.RequestTimeout < 0 {
+		return fieldError("request_timeout", ErrInvalidValue, "must not be negative")
+	}
+	if o.Concurrency < 0 {
+		return fieldError("concurrency", ErrInvalidValue, "must not be negative")
+	}
+
+	for topic, t := range o.Topics {
+		field := "topics." + topic
+		switch {
+		case strings.TrimSpace(topic) == "":
+			return fieldError("topics", ErrMissingValue, "topic names must not be empty")
+		case t.RequestTimeout < 0:
+			return fieldError(field+".request_timeout", ErrInvalidValue, "must not be negative")
+		case t.Concurrency < 0:
+			return fieldError(field+".concurrency", ErrInvalidValue, "must not be negative")
+		case t.AckWait < 0:
+			return fieldError(field+".ack_wait", ErrInvalidValue, "must not be negative")
+		}
+	}
+
+	if o.AuthenticationToken != "" && o.Auth != nil && (o.Auth.TokenFile != "" || o.Auth.TokenSupplier != nil) {
+		return fieldError("token", ErrInvalidValue, "set either token or auth.token_file and the token supplier, not both")
+	}
+	if err := o.Auth.validate(); err != nil {
+		return err
+	}
+	return o.ResolvedTLS().validate()
+}
+
+func (a *AuthOptions) validate() error {
+	if a == nil {
+		return nil
+	}
+	if a.Password != "" && a.Username == "" {
+		return fieldError("auth.username", ErrMissingValue, "a password is set")
+	}
+
+	if err := checkFiles(
+		"auth.credentials_file", a.CredentialsFile,
+		"auth.nkey_seed_file", a.NKeySeedFile,
+		"auth.token_file", a.TokenFile,
+	); err != nil {
+		return err
+	}
+
+	if o := a.OAuth2; o != nil {
+		if o.KeyFile != "" {
+			return checkFiles("auth.oauth2.key_file", o.KeyFile)
+		}
+		if o.TokenURL == "" {
+			return fieldError("auth.oauth2.token_url", ErrMissingValue, "set either key_file or token_url")
+		}
+		if u, err := url.Parse(o.TokenURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fieldError("auth.oauth2.token_url", ErrInvalidURL, "")
+		}
+		if o.ClientID == "" {
+			return fieldError("auth.oauth2.client_id", ErrMissingValue, "")
+		}
+	}
+	return nil
+}
+
+func (t *TLSOptions) validate() error {
+	if t == nil {
+		return nil
+	}
+	if (t.ClientCertContent == "") != (t.ClientKeyContent == "") {
+		return fieldError("tls.client_key", ErrInvalidTlsConfiguration, "client_cert and client_key must be set together")
+	}
+	if (t.ClientCertFile == "") != (t.ClientKeyFile == "") {
+		return fieldError("tls.client_key_file", ErrInvalidTlsConfiguration, "client_cert_file and client_key_file must be set together")
+	}
+	if err := checkFiles(
+		"tls.ca_cert_file", t.CACertFile,
+		"tls.client_cert_file", t.ClientCertFile,
+		"tls.client_key_file", t.ClientKeyFile,
+	); err != nil {
+		return err
+	}
+
+	if _, err := t.Config(); err != nil {
+		field := "tls.ca_cert"
+		if t.ClientCertContent != "" || t.ClientCertFile != "" {
+			if _, certErr := t.ClientCertificate(); certErr != nil {
+				field = "tls.client_cert"
+			}
+		}
+		return &FieldError{Field: field, Err: err}
+	}
+	return nil
+}
+
+// checkFiles takes field and path pairs and reports the first path set to a file that cannot be found.
+func checkFiles(fieldsAndPaths ...string) error {
+	for i := 0; i+1 < len(fieldsAndPaths); i += 2 {
+		field, path := fieldsAndPaths[i], fieldsAndPaths[i+1]
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fieldError(field, ErrInvalidValue, "%v", err)
+		}
+	}
+	return nil
+}
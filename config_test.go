@@ -0,0 +1,156 @@
+package axon
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestNewOptions(t *testing.T) {
+	opts, err := NewOptions(
+		WithServiceName("orders"),
+		WithAddress("nats://localhost:4222"),
+		WithClusterID("test-cluster"),
+		WithRequestTimeout(3*time.Second),
+		WithConcurrency(4),
+		WithTopic("order.created", TopicOptions{Concurrency: 1, AckWait: time.Minute}),
+	)
+	require.Nil(t, err)
+	assert.Equal(t, "orders", opts.ServiceName)
+	assert.Equal(t, "test-cluster", opts.ClusterID)
+
+	assert.Equal(t, TopicOptions{RequestTimeout: 3 * time.Second, Concurrency: 1, AckWait: time.Minute}, opts.Topic("order.created"))
+	assert.Equal(t, TopicOptions{RequestTimeout: 3 * time.Second, Concurrency: 4}, opts.Topic("order.shipped"))
+}
+
+func TestFromFile(t *testing.T) {
+	yamlFile := writeConfig(t, "axon.yaml", `
+service_name: orders
+address: pulsar://localhost:6650
+request_timeout: 5s
+concurrency: 8
+auth:
+  username: axon
+  password: pa55
+tls:
+  insecure_skip_verify: true
+topics:
+  order.created:
+    concurrency: 2
+    ack_wait: 30s
+`)
+	jsonFile := writeConfig(t, "axon.json", `{
+  "service_name": "orders",
+  "address": "pulsar://localhost:6650",
+  "request_timeout": "5s",
+  "concurrency": 8,
+  "auth": {"username": "axon", "password": "pa55"},
+  "tls": {"insecure_skip_verify": true},
+  "topics": {"order.created": {"concurrency": 2, "ack_wait": "30s"}}
+}`)
+
+	for _, path := range []string{yamlFile, jsonFile} {
+		opts, err := NewOptions(FromFile(path))
+		require.Nil(t, err, path)
+		assert.Equal(t, "orders", opts.ServiceName)
+		assert.Equal(t, 5*time.Second, opts.RequestTimeout)
+		assert.Equal(t, 8, opts.Concurrency)
+		assert.Equal(t, "pa55", opts.Auth.Password)
+		assert.True(t, opts.TLS.InsecureSkipVerify)
+		assert.Equal(t, TopicOptions{RequestTimeout: 5 * time.Second, Concurrency: 2, AckWait: 30 * time.Second}, opts.Topic("order.created"))
+	}
+
+	_, err := NewOptions(FromFile(filepath.Join(t.TempDir(), "missing.yaml")))
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	_, err = NewOptions(FromFile(writeConfig(t, "broken.yaml", "concurrency: [")))
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+}
+
+func TestFromEnv(t *testing.T) {
+	file := writeConfig(t, "axon.yaml", "service_name: orders\naddress: nats://localhost:4222\nconcurrency: 8\n")
+	t.Setenv("AXON_CONFIG_FILE", file)
+	t.Setenv("AXON_CONCURRENCY", "2")
+	t.Setenv("AXON_REQUEST_TIMEOUT", "1500ms")
+	t.Setenv("AXON_USERNAME", "axon")
+	t.Setenv("AXON_PASSWORD", "pa55")
+	t.Setenv("AXON_OAUTH2_TOKEN_URL", "https://auth.example.com/token")
+	t.Setenv("AXON_OAUTH2_CLIENT_ID", "orders")
+	t.Setenv("AXON_OAUTH2_SCOPES", "read, write")
+	t.Setenv("AXON_TLS_SERVER_NAME", "broker.internal")
+
+	opts, err := NewOptions(FromEnv())
+	require.Nil(t, err)
+	assert.Equal(t, "orders", opts.ServiceName)
+	assert.Equal(t, 2, opts.Concurrency, "environment variables override the config file")
+	assert.Equal(t, 1500*time.Millisecond, opts.RequestTimeout)
+	assert.Equal(t, "axon", opts.Auth.Username)
+	assert.Equal(t, []string{"read", "write"}, opts.Auth.OAuth2.Scopes)
+	assert.Equal(t, "broker.internal", opts.TLS.ServerName)
+
+	// Options after FromEnv take precedence.
+	opts, err = NewOptions(FromEnv(), WithConcurrency(16))
+	require.Nil(t, err)
+	assert.Equal(t, 16, opts.Concurrency)
+
+	t.Setenv("AXON_CONCURRENCY", "many")
+	_, err = NewOptions(FromEnv())
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, "AXON_CONCURRENCY", fieldErr.Field)
+	assert.True(t, errors.Is(err, ErrInvalidValue))
+}
+
+func TestValidate(t *testing.T) {
+	valid := Options{ServiceName: "orders", Address: "mem://"}
+	require.Nil(t, valid.Validate())
+
+	cases := []struct {
+		name  string
+		edit  func(o *Options)
+		field string
+		err   error
+	}{
+		{"service name", func(o *Options) { o.ServiceName = " " }, "service_name", ErrEmptyStoreName},
+		{"missing address", func(o *Options) { o.Address = "" }, "address", ErrInvalidURL},
+		{"address without scheme", func(o *Options) { o.Address = "localhost:4222" }, "address", ErrInvalidURL},
+		{"request timeout", func(o *Options) { o.RequestTimeout = -time.Second }, "request_timeout", ErrInvalidValue},
+		{"topic ack wait", func(o *Options) {
+			o.Topics = map[string]TopicOptions{"order.created": {AckWait: -time.Second}}
+		}, "topics.order.created.ack_wait", ErrInvalidValue},
+		{"password without user", func(o *Options) { o.Auth = &AuthOptions{Password: "pa55"} }, "auth.username", ErrMissingValue},
+		{"missing token file", func(o *Options) {
+			o.Auth = &AuthOptions{TokenFile: filepath.Join(t.TempDir(), "token")}
+		}, "auth.token_file", ErrInvalidValue},
+		{"token and token supplier", func(o *Options) {
+			o.AuthenticationToken = "secret"
+			o.Auth = &AuthOptions{TokenSupplier: func() (string, error) { return "rotated", nil }}
+		}, "token", ErrInvalidValue},
+		{"oauth2 token url", func(o *Options) {
+			o.Auth = &AuthOptions{OAuth2: &OAuth2Options{TokenURL: "not a url", ClientID: "orders"}}
+		}, "auth.oauth2.token_url", ErrInvalidURL},
+		{"client key without cert", func(o *Options) { o.TLS = &TLSOptions{ClientKeyContent: "key"} }, "tls.client_key", ErrInvalidTlsConfiguration},
+		{"ca cert", func(o *Options) { o.TLS = &TLSOptions{CACertContent: "not a certificate"} }, "tls.ca_cert", ErrInvalidTlsConfiguration},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := valid
+			c.edit(&opts)
+			err := opts.Validate()
+			var fieldErr *FieldError
+			require.True(t, errors.As(err, &fieldErr), "got %v", err)
+			assert.Equal(t, c.field, fieldErr.Field)
+			assert.True(t, errors.Is(err, c.err), "got %v", err)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Package encrypt encrypts the payloads of an axon.EventStore with AES-GCM, so topics carrying personal data
+// stay confidential whatever the broker and its storage.
+//
+// Payloads are sealed with the current key of a KeyProvider, and the id of that key travels in the frame of
+// published payloads, or in the KeyHeader header of requests and replies. Receivers look the key up by its id,
+// so payloads sealed before a key rotation still open as long as the provider keeps the old key. To compress
+// payloads too, wrap the encrypting store with compress: once encrypted, payloads no longer compress.
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+)
+
+var (
+	ErrUnknownKey   = errors.New("Sorry, no key has this id")
+	ErrInvalidKey   = errors.New("Sorry, AES keys are 16, 24 or 32 bytes long")
+	ErrNotEncrypted = errors.New("Sorry, the payload is not encrypted")
+	ErrCorrupt      = errors.New("Sorry, the encrypted payload is corrupt or was sealed with another key")
+)
+
+// KeyHeader is the header carrying the id of the key a request, or the Response to a request, was sealed with.
+// It marks the encrypted request and reply payloads, which are JSON strings holding the base64 encoded nonce and
+// sealed payload as they must stay JSON, and lets replying services and middlewares see the key id.
+const KeyHeader = "axon-encryption-key"
+
+// Published payloads encrypted by this package are framed as follows:
+//
+//	"\x00axe" | version (1 byte) | key id length (1 byte) | key id | nonce | sealed payload
+//
+// The version tells future framings apart. Frames of an unknown version, or too short for their key id, nonce and
+// authentication tag, fail with ErrCorrupt.
+const (
+	magic   = "\x00axe"
+	version = 1
+)
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidKey, err.Error())
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data with the current key of keys, returning the key id and the nonce followed by the sealed
+// data. The key id is authenticated along with the data.
+func seal(keys KeyProvider, data []byte) (string, []byte, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "unable to get the current key")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "key %s", id)
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(data)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, err
+	}
+	return id, gcm.Seal(nonce, nonce, data, []byte(id)), nil
+}
+
+// open decrypts the nonce and sealed data made by seal with the key id.
+func open(keys KeyProvider, id string, sealed []byte) ([]byte, error) {
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "key %s", id)
+	}
+	if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrCorrupt
+	}
+	data, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
+
+// encode encrypts a published payload into a binary frame.
+func encode(keys KeyProvider, data []byte) ([]byte, error) {
+	id, sealed, err := seal(keys, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, errors.Errorf("key id %s is longer than 255 bytes", id)
+	}
+	out := make([]byte, 0, len(magic)+2+len(id)+len(sealed))
+	out = append(append(append([]byte(magic), version, byte(len(id))), id...), sealed...)
+	return out, nil
+}
+
+// decode decrypts a published payload encrypted by encode. Other payloads fail with ErrNotEncrypted.
+func decode(keys KeyProvider, data []byte) ([]byte, error) {
+	if len(data) < len(magic)+2 || string(data[:len(magic)]) != magic {
+		return nil, ErrNotEncrypted
+	}
+	data = data[len(magic):]
+	if data[0] != version {
+		return nil, errors.Wrapf(ErrCorrupt, "unknown version %d", data[0])
+	}
+	n := int(data[1])
+	if len(data) < 2+n {
+		return nil, ErrCorrupt
+	}
+	return open(keys, string(data[2:2+n]), data[2+n:])
+}
+
+// encodeJSON encrypts a request or reply payload into a JSON string, returning the id of the key used for the
+// KeyHeader header.
+func encodeJSON(keys KeyProvider, data []byte) (string, []byte, error) {
+	id, sealed, err := seal(keys, data)
+	if err != nil {
+		return "", nil, err
+	}
+	out, err := json.Marshal(sealed)
+	return id, out, err
+}
+
+// decodeJSON decrypts a request or reply payload encrypted by encodeJSON with the key id, as named by the
+// KeyHeader header. Payloads without key id fail with ErrNotEncrypted.
+func decodeJSON(keys KeyProvider, id string, data []byte) ([]byte, error) {
+	if id == "" {
+		return nil, ErrNotEncrypted
+	}
+	var sealed []byte
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, errors.Wrap(ErrCorrupt, err.Error())
+	}
+	return open(keys, id, sealed)
+}
@@ -0,0 +1,301 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/Just4Ease/axon/compress"
+	"github.com/Just4Ease/axon/mem"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	september = bytes.Repeat([]byte{9}, 32)
+	october   = bytes.Repeat([]byte{10}, 32)
+)
+
+// writeKeys writes a key file whose current key is current, dated at so successive writes are told apart.
+func writeKeys(t *testing.T, path, current string, keys map[string][]byte, at time.Time) {
+	var b strings.Builder
+	b.WriteString("current: " + current + "\nkeys:\n")
+	for id, key := range keys {
+		b.WriteString("  " + id + ": " + base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	require.Nil(t, ioutil.WriteFile(path, []byte(b.String()), 0o600))
+	require.Nil(t, os.Chtimes(path, at, at))
+}
+
+func newKeys(t *testing.T) (KeyProvider, string) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeys(t, path, "2024-09", map[string][]byte{"2024-09": september}, time.Now().Add(-time.Hour))
+	keys, err := NewFileKeyProvider(path)
+	require.Nil(t, err)
+	return keys, path
+}
+
+func TestEncodeDecode(t *testing.T) {
+	keys, _ := newKeys(t)
+	message := []byte(`{"email":"jane@example.com"}`)
+
+	encoded, err := encode(keys, message)
+	require.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(encoded, []byte(magic+"\x01\x072024-09")))
+	assert.NotContains(t, string(encoded), "jane")
+	decoded, err := decode(keys, encoded)
+	require.Nil(t, err)
+	assert.Equal(t, message, decoded)
+
+	id, encoded, err := encodeJSON(keys, message)
+	require.Nil(t, err)
+	assert.Equal(t, "2024-09", id)
+	assert.True(t, json.Valid(encoded), "request and reply payloads stay JSON")
+	decoded, err = decodeJSON(keys, id, encoded)
+	require.Nil(t, err)
+	assert.Equal(t, message, decoded)
+
+	tampered, err := encode(keys, message)
+	require.Nil(t, err)
+	tampered[len(tampered)-1] ^= 1
+	_, err = decode(keys, tampered)
+	assert.Equal(t, ErrCorrupt, err)
+
+	_, err = decode(keys, message)
+	assert.Equal(t, ErrNotEncrypted, err)
+	_, err = decodeJSON(keys, "", message)
+	assert.Equal(t, ErrNotEncrypted, err)
+	_, err = decodeJSON(keys, id, message)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	_, err = decode(keys, []byte(magic+"\x01\x07unknownsealed"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = decode(keys, []byte(magic+"\x02\x072024-09sealed"))
+	assert.ErrorIs(t, err, ErrCorrupt, "unknown version")
+	_, err = decode(keys, []byte(magic+"\x01\x072024-09short"))
+	assert.Equal(t, ErrCorrupt, err, "shorter than a nonce and tag")
+}
+
+func TestFileKeyProvider_Rotation(t *testing.T) {
+	keys, path := newKeys(t)
+	old, err := encode(keys, []byte("sealed in september"))
+	require.Nil(t, err)
+
+	writeKeys(t, path, "2024-10", map[string][]byte{"2024-09": september, "2024-10": october}, time.Now())
+	id, key, err := keys.CurrentKey()
+	require.Nil(t, err)
+	assert.Equal(t, "2024-10", id)
+	assert.Equal(t, october, key)
+	decoded, err := decode(keys, old)
+	require.Nil(t, err)
+	assert.Equal(t, "sealed in september", string(decoded))
+
+	writeKeys(t, path, "2024-10", map[string][]byte{"2024-10": october}, time.Now().Add(time.Hour))
+	_, err = decode(keys, old)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestFileKeyProvider_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	_, err := NewFileKeyProvider(path)
+	assert.NotNil(t, err)
+
+	writeKeys(t, path, "short", map[string][]byte{"short": []byte("too short")}, time.Now())
+	_, err = NewFileKeyProvider(path)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	writeKeys(t, path, "missing", map[string][]byte{"2024-09": september}, time.Now())
+	_, err = NewFileKeyProvider(path)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestStore(t *testing.T) {
+	keys, _ := newKeys(t)
+	broker := mem.NewBroker()
+	newStore := func(name string, options ...Option) (*Store, *axontest.Recorder) {
+		store, err := mem.Init(axon.Options{ServiceName: name}, mem.WithBroker(broker))
+		require.Nil(t, err)
+		t.Cleanup(func() { store.Close() })
+		recorder := axontest.Record(store)
+		return Wrap(recorder, keys, options...), recorder
+	}
+	users, sent := newStore("users")
+	mailer, _ := newStore("mailer")
+	lenient, _ := newStore("lenient", AllowPlaintext())
+
+	t.Run("PublishSubscribe", func(t *testing.T) {
+		data := make(chan []byte, 2)
+		go mailer.Subscribe("user.created", func(e axon.Event) {
+			e.Ack()
+			data <- e.Data()
+		})
+		time.Sleep(50 * time.Millisecond)
+		require.Nil(t, users.Publish("user.created", []byte(`{"email":"jane@example.com"}`)))
+		// Plaintext is dropped, unless the store allows it.
+		require.Nil(t, sent.EventStore.Publish("user.created", []byte(`{"email":"john@example.com"}`)))
+
+		select {
+		case d := <-data:
+			assert.Equal(t, `{"email":"jane@example.com"}`, string(d))
+		case <-time.After(time.Second):
+			t.Fatal("handler did not run")
+		}
+		select {
+		case d := <-data:
+			t.Fatalf("plaintext %s was delivered", d)
+		case <-time.After(100 * time.Millisecond):
+		}
+		published := sent.Published("user.created")
+		require.Len(t, published, 1)
+		assert.NotContains(t, string(published[0].Data), "jane")
+	})
+
+	t.Run("AllowPlaintext", func(t *testing.T) {
+		data := make(chan []byte, 2)
+		go lenient.Subscribe("user.deleted", func(e axon.Event) {
+			e.Ack()
+			data <- e.Data()
+		})
+		time.Sleep(50 * time.Millisecond)
+		require.Nil(t, sent.EventStore.Publish("user.deleted", []byte(`{"id":1}`)))
+		select {
+		case d := <-data:
+			assert.Equal(t, `{"id":1}`, string(d))
+		case <-time.After(time.Second):
+			t.Fatal("handler did not run")
+		}
+	})
+
+	t.Run("RequestReply", func(t *testing.T) {
+		go mailer.ReplyContext("user.lookup", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			assert.Equal(t, `{"id":42}`, string(req.Payload))
+			assert.Equal(t, "2024-09", req.Headers[KeyHeader])
+			return axon.Response{Payload: []byte(`{"email":"jane@example.com"}`)}, nil
+		})
+		time.Sleep(50 * time.Millisecond)
+
+		var reply struct{ Email string }
+		var headers map[string]string
+		require.Nil(t, users.Request("user.lookup", []byte(`{"id":42}`), &reply, axon.WithReplyHeaders(&headers)))
+		assert.Equal(t, "jane@example.com", reply.Email)
+		assert.Equal(t, "2024-09", headers[KeyHeader])
+		requested := sent.Requested("user.lookup")
+		require.Len(t, requested, 1)
+		assert.True(t, json.Valid(requested[0].Data))
+		assert.NotContains(t, string(requested[0].Data), "42")
+	})
+
+	t.Run("UnmarkedPayloads", func(t *testing.T) {
+		// A reply without the KeyHeader header is plaintext, whatever it looks like.
+		go mailer.EventStore.Reply("user.lookalike", func([]byte) ([]byte, error) {
+			return []byte(`{"axon_encryption":"aes-gcm","key_id":"2024-09","data":"AAAA"}`), nil
+		})
+		time.Sleep(50 * time.Millisecond)
+
+		var reply json.RawMessage
+		err := users.Request("user.lookalike", []byte(`{}`), &reply)
+		assert.Equal(t, ErrNotEncrypted, err)
+	})
+
+	t.Run("Compressed", func(t *testing.T) {
+		compressedUsers, err := compress.Wrap(users, compress.Zstd, compress.Threshold(10))
+		require.Nil(t, err)
+		compressedMailer, err := compress.Wrap(mailer, compress.Zstd, compress.Threshold(10))
+		require.Nil(t, err)
+		document := `{"emails":["` + strings.Repeat("jane@example.com", 50) + `"]}`
+
+		data := make(chan []byte, 1)
+		go compressedMailer.Subscribe("user.imported", func(e axon.Event) {
+			e.Ack()
+			data <- e.Data()
+		})
+		time.Sleep(50 * time.Millisecond)
+		require.Nil(t, compressedUsers.Publish("user.imported", []byte(document)))
+		select {
+		case d := <-data:
+			assert.Equal(t, document, string(d))
+		case <-time.After(time.Second):
+			t.Fatal("handler did not run")
+		}
+		published := sent.Published("user.imported")
+		require.Len(t, published, 1)
+		assert.Less(t, len(published[0].Data), len(document))
+	})
+}
+
+// replyStore keeps the handler passed to ReplyContext, to call it without a broker.
+type replyStore struct {
+	axon.EventStore
+	handler axon.ContextReplyHandler
+}
+
+func (s *replyStore) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	s.handler = handler
+	return nil
+}
+
+func TestStore_FailedReply(t *testing.T) {
+	keys, _ := newKeys(t)
+	store := &replyStore{}
+	require.Nil(t, Wrap(store, keys).ReplyContext("user.delete", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+		return axon.Response{Payload: []byte(`{"email":"jane@example.com"}`)}, errors.New("user is locked")
+	}))
+
+	// The payload returned along with the error is not sent, as it would travel unencrypted.
+	id, data, err := encodeJSON(keys, []byte(`{"id":42}`))
+	require.Nil(t, err)
+	res, err := store.handler(context.Background(), axon.Request{Headers: map[string]string{KeyHeader: id}, Payload: data})
+	assert.EqualError(t, err, "user is locked")
+	assert.Equal(t, axon.Response{}, res)
+}
+
+func TestStore_RotationReachesPublisherFirst(t *testing.T) {
+	dir := t.TempDir()
+	publisherPath, subscriberPath := filepath.Join(dir, "publisher.yaml"), filepath.Join(dir, "subscriber.yaml")
+	past := time.Now().Add(-time.Hour)
+	writeKeys(t, publisherPath, "2024-10", map[string][]byte{"2024-09": september, "2024-10": october}, past)
+	writeKeys(t, subscriberPath, "2024-09", map[string][]byte{"2024-09": september}, past)
+	publisherKeys, err := NewFileKeyProvider(publisherPath)
+	require.Nil(t, err)
+	subscriberKeys, err := NewFileKeyProvider(subscriberPath)
+	require.Nil(t, err)
+
+	broker := mem.NewBroker()
+	publisher, err := mem.Init(axon.Options{ServiceName: "users"}, mem.WithBroker(broker))
+	require.Nil(t, err)
+	defer publisher.Close()
+	subscriber, err := mem.Init(axon.Options{ServiceName: "mailer"}, mem.WithBroker(broker), mem.AckWait(50*time.Millisecond))
+	require.Nil(t, err)
+	defer subscriber.Close()
+
+	data := make(chan []byte, 1)
+	go Wrap(subscriber, subscriberKeys).Subscribe("user.created", func(e axon.Event) {
+		e.Ack()
+		data <- e.Data()
+	})
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, Wrap(publisher, publisherKeys).Publish("user.created", []byte(`{"email":"jane@example.com"}`)))
+
+	// The subscriber does not know the new key yet, so the message waits for it instead of being dropped.
+	select {
+	case d := <-data:
+		t.Fatalf("%s was delivered without its key", d)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	writeKeys(t, subscriberPath, "2024-10", map[string][]byte{"2024-09": september, "2024-10": october}, time.Now())
+	select {
+	case d := <-data:
+		assert.Equal(t, `{"email":"jane@example.com"}`, string(d))
+	case <-time.After(2 * time.Second):
+		t.Fatal("the message was not delivered again once the key was rotated in")
+	}
+}
@@ -0,0 +1,113 @@
+package encrypt
+
+import (
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeyProvider supplies the keys of a Store, such as a key management service or a file. Keys are 16, 24 or 32
+// bytes long, for AES-128, AES-192 or AES-256.
+type KeyProvider interface {
+	// CurrentKey returns the key payloads are encrypted with, and its id.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key id to decrypt payloads with, current or retired, or ErrUnknownKey.
+	Key(id string) ([]byte, error)
+}
+
+// keyFile is the content of the file of a file key provider.
+type keyFile struct {
+	Current string            `yaml:"current"`
+	Keys    map[string]string `yaml:"keys"` // Base64 encoded keys by id.
+}
+
+type fileKeyProvider struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	current string
+	keys    map[string][]byte
+}
+
+// NewFileKeyProvider returns a KeyProvider reading its keys from a YAML or JSON file, such as:
+//
+//	current: 2024-10
+//	keys:
+//	  2024-09: 3q2+7wAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+//	  2024-10: q83vASNFZ4mrze8BI0VniavN7wEjRWeJq83vASNFZ4k=
+//
+// Keys are base64 encoded. The file is read again whenever it changes, so a key is rotated by adding the new key
+// and making it current, keeping the old ones for the payloads they sealed. Meant for tests and small setups.
+func NewFileKeyProvider(path string) (KeyProvider, error) {
+	p := &fileKeyProvider{path: path}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// reload reads the file again when it changed since it was read. The caller holds p.mu, or owns p.
+func (p *fileKeyProvider) reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return errors.Wrap(err, "unable to read the key file")
+	}
+	if info.ModTime().Equal(p.modTime) && p.keys != nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return errors.Wrap(err, "unable to read the key file")
+	}
+	var f keyFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return errors.Wrap(err, "the key file is invalid")
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return errors.Wrapf(err, "key %s is not base64 encoded", id)
+		}
+		if _, err := newGCM(key); err != nil {
+			return errors.Wrapf(err, "key %s", id)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[f.Current]; !ok {
+		return errors.Wrapf(ErrUnknownKey, "the current key %q of the key file", f.Current)
+	}
+
+	p.modTime = info.ModTime()
+	p.current = f.Current
+	p.keys = keys
+	return nil
+}
+
+func (p *fileKeyProvider) CurrentKey() (string, []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.reload(); err != nil {
+		return "", nil, err
+	}
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *fileKeyProvider) Key(id string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownKey, id)
+	}
+	return key, nil
+}
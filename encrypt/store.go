@@ -0,0 +1,140 @@
+package encrypt
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/internal/payload"
+	"github.com/pkg/errors"
+)
+
+// Store is an axon.EventStore decorating another store to encrypt the payloads it sends and decrypt the payloads
+// it receives.
+type Store struct {
+	axon.EventStore
+	keys      KeyProvider
+	plaintext bool
+}
+
+type Option func(*Store)
+
+// AllowPlaintext passes the payloads received unencrypted on as they are, instead of dropping them, while the
+// services publishing them are moved to encryption.
+func AllowPlaintext() Option {
+	return func(s *Store) {
+		s.plaintext = true
+	}
+}
+
+// Wrap returns a Store encrypting the payloads sent through store with the current key of keys.
+func Wrap(store axon.EventStore, keys KeyProvider, options ...Option) *Store {
+	s := &Store{EventStore: store, keys: keys}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func (s *Store) decode(data []byte) ([]byte, error) {
+	out, err := decode(s.keys, data)
+	if err == ErrNotEncrypted && s.plaintext {
+		return data, nil
+	}
+	return out, err
+}
+
+func (s *Store) decodeJSON(id string, data []byte) ([]byte, error) {
+	out, err := decodeJSON(s.keys, id, data)
+	if err == ErrNotEncrypted && s.plaintext {
+		return data, nil
+	}
+	return out, err
+}
+
+// handler decrypts the events passed to handler. Corrupt and plaintext payloads are dropped, while events whose
+// key is missing, or which the key provider failed to supply, are delivered again.
+func (s *Store) handler(topic string, handler axon.SubscriptionHandler) axon.SubscriptionHandler {
+	return payload.Handler(topic, s.decode, permanent, handler)
+}
+
+// permanent tells whether err means the payload never will decrypt.
+func permanent(err error) bool {
+	return errors.Is(err, ErrCorrupt) || errors.Is(err, ErrNotEncrypted)
+}
+
+func (s *Store) Publish(topic string, message []byte) error {
+	data, err := encode(s.keys, message)
+	if err != nil {
+		return err
+	}
+	return s.EventStore.Publish(topic, data)
+}
+
+func (s *Store) Subscribe(topic string, handler axon.SubscriptionHandler) error {
+	return s.EventStore.Subscribe(topic, s.handler(topic, handler))
+}
+
+// Request encrypts the payload and decrypts the reply before decoding it into v. The id of the key travels in the
+// KeyHeader header of the request, and of the reply.
+func (s *Store) Request(topic string, message []byte, v interface{}, opts ...axon.RequestOption) error {
+	id, data, err := encodeJSON(s.keys, message)
+	if err != nil {
+		return err
+	}
+	var headers map[string]string
+	opts = append(opts[:len(opts):len(opts)], axon.WithHeader(KeyHeader, id), axon.WithReplyHeaders(&headers))
+	var reply json.RawMessage
+	if err := s.EventStore.Request(topic, data, &reply, opts...); err != nil {
+		return err
+	}
+	out, err := s.decodeJSON(headers[KeyHeader], reply)
+	if err != nil {
+		return err
+	}
+	if v == nil || len(out) == 0 {
+		return nil
+	}
+	return json.Unmarshal(out, v)
+}
+
+func (s *Store) Reply(topic string, handler axon.ReplyHandler) error {
+	return s.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+// ReplyContext decrypts the requests passed to handler and encrypts its replies. Requests which fail to decrypt
+// are answered with the error, and so are the requests handler fails, without the payload it returned. Headers
+// and the error messages of failed replies travel in clear, so they must not carry confidential data.
+func (s *Store) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	return s.EventStore.ReplyContext(topic, func(ctx context.Context, req axon.Request) (axon.Response, error) {
+		data, err := s.decodeJSON(req.Headers[KeyHeader], req.Payload)
+		if err != nil {
+			return axon.Response{}, err
+		}
+		req.Payload = data
+		res, err := handler(ctx, req)
+		if err != nil {
+			return axon.Response{}, err
+		}
+		id, data, err := encodeJSON(s.keys, res.Payload)
+		if err != nil {
+			return axon.Response{}, err
+		}
+		res.Payload = data
+		res.Headers = payload.WithHeader(res.Headers, KeyHeader, id)
+		return res, nil
+	})
+}
+
+// Replay replays topic with the decorated store, decrypting the events, or fails with axon.ErrReplayUnsupported
+// when it is not an axon.Replayer.
+func (s *Store) Replay(topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	r, ok := s.EventStore.(axon.Replayer)
+	if !ok {
+		return axon.ErrReplayUnsupported
+	}
+	return r.Replay(topic, from, s.handler(topic, handler))
+}
+
+func (s *Store) ReplayContext(ctx context.Context, topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	return axon.Replay(ctx, s.EventStore, topic, from, s.handler(topic, handler))
+}
@@ -0,0 +1,214 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	checkpointExt = ".checkpoints.jsonl"
+	// compactSlack is how many lines a checkpoint file holds beyond twice its streams before it is compacted.
+	compactSlack = 1024
+)
+
+// CheckpointStore keeps, for each projection, the version of the last event applied from each stream. Read
+// models kept in a database may implement it themselves, to save checkpoints along with their changes.
+type CheckpointStore interface {
+	// LoadCheckpoint returns the version of the last event of stream applied by projection, 0 when none.
+	LoadCheckpoint(ctx context.Context, projection, stream string) (uint64, error)
+	SaveCheckpoint(ctx context.Context, projection, stream string, version uint64) error
+	// ResetCheckpoints forgets the checkpoints of projection, to rebuild it.
+	ResetCheckpoints(ctx context.Context, projection string) error
+}
+
+type memoryCheckpointStore struct {
+	mu          sync.RWMutex
+	checkpoints map[string]map[string]uint64
+}
+
+// NewMemoryCheckpointStore returns a CheckpointStore keeping the checkpoints in memory, for tests and
+// prototypes.
+func NewMemoryCheckpointStore() CheckpointStore {
+	return &memoryCheckpointStore{checkpoints: make(map[string]map[string]uint64)}
+}
+
+func (s *memoryCheckpointStore) LoadCheckpoint(ctx context.Context, projection, stream string) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkpoints[projection][stream], nil
+}
+
+func (s *memoryCheckpointStore) SaveCheckpoint(ctx context.Context, projection, stream string, version uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoints[projection] == nil {
+		s.checkpoints[projection] = make(map[string]uint64)
+	}
+	s.checkpoints[projection][stream] = version
+	return nil
+}
+
+func (s *memoryCheckpointStore) ResetCheckpoints(ctx context.Context, projection string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, projection)
+	return nil
+}
+
+type checkpoint struct {
+	Stream  string `json:"stream"`
+	Version uint64 `json:"version"`
+}
+
+type fileCheckpointStore struct {
+	dir         string
+	mu          sync.Mutex
+	checkpoints map[string]map[string]uint64 // Projections read so far.
+	lines       map[string]int               // Lines in the file of each projection read so far.
+}
+
+// NewFileCheckpointStore returns a CheckpointStore appending the checkpoints of each projection to a file of
+// dir, one JSON line per checkpoint. The file is rewritten with the last checkpoint of each stream once it
+// holds about twice as many lines as streams, so it grows with the streams rather than the events. The
+// directory is created when missing.
+func NewFileCheckpointStore(dir string) (CheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "unable to create the checkpoint directory")
+	}
+	return &fileCheckpointStore{
+		dir:         dir,
+		checkpoints: make(map[string]map[string]uint64),
+		lines:       make(map[string]int),
+	}, nil
+}
+
+func (s *fileCheckpointStore) path(projection string) string {
+	return filepath.Join(s.dir, url.PathEscape(projection)+checkpointExt)
+}
+
+// read returns the checkpoints of projection, reading them from its file the first time. The caller holds s.mu.
+func (s *fileCheckpointStore) read(projection string) (map[string]uint64, error) {
+	if checkpoints, ok := s.checkpoints[projection]; ok {
+		return checkpoints, nil
+	}
+
+	checkpoints := make(map[string]uint64)
+	lines := 0
+	f, err := os.Open(s.path(projection))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer f.Close()
+		err = readLines(f, func(line []byte) error {
+			var c checkpoint
+			if err := json.Unmarshal(line, &c); err != nil {
+				return errors.Wrapf(err, "the checkpoints of %s are corrupt", projection)
+			}
+			checkpoints[c.Stream] = c.Version
+			lines++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.checkpoints[projection] = checkpoints
+	s.lines[projection] = lines
+	return checkpoints, nil
+}
+
+func (s *fileCheckpointStore) LoadCheckpoint(ctx context.Context, projection, stream string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoints, err := s.read(projection)
+	if err != nil {
+		return 0, err
+	}
+	return checkpoints[stream], nil
+}
+
+func (s *fileCheckpointStore) SaveCheckpoint(ctx context.Context, projection, stream string, version uint64) error {
+	line, err := json.Marshal(checkpoint{Stream: stream, Version: version})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoints, err := s.read(projection)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(projection), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	checkpoints[stream] = version
+	if s.lines[projection]++; s.lines[projection] > 2*len(checkpoints)+compactSlack {
+		return s.compact(projection, checkpoints)
+	}
+	return nil
+}
+
+// compact replaces the file of projection with one line per stream. The caller holds s.mu.
+func (s *fileCheckpointStore) compact(projection string, checkpoints map[string]uint64) error {
+	streams := make([]string, 0, len(checkpoints))
+	for stream := range checkpoints {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	var data []byte
+	for _, stream := range streams {
+		line, err := json.Marshal(checkpoint{Stream: stream, Version: checkpoints[stream]})
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	// The file is replaced at once, so a crash never leaves half of it.
+	tmp, err := ioutil.TempFile(s.dir, ".checkpoints-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path(projection)); err != nil {
+		return err
+	}
+	s.lines[projection] = len(streams)
+	return nil
+}
+
+func (s *fileCheckpointStore) ResetCheckpoints(ctx context.Context, projection string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, projection)
+	delete(s.lines, projection)
+	if err := os.Remove(s.path(projection)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
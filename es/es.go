@@ -0,0 +1,117 @@
+// Package es stores event-sourced aggregates on top of axon.
+//
+// An aggregate is rebuilt by applying the domain events of its stream in order, and changed by recording new
+// events. A Repository appends the recorded events to the stream with optimistic concurrency, then publishes
+// them through an axon.EventStore for the other services to react to.
+//
+//	type Account struct {
+//		es.Root
+//		Balance int
+//	}
+//
+//	func (a *Account) Apply(e es.Event) error {
+//		var d Deposited
+//		if err := e.Decode(&d); err != nil {
+//			return err
+//		}
+//		a.Balance += d.Amount
+//		return nil
+//	}
+package es
+
+import (
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"time"
+)
+
+var (
+	ErrAggregateNotFound   = errors.New("Sorry, no events were recorded for this aggregate")
+	ErrConcurrencyConflict = errors.New("Sorry, the stream was changed since the aggregate was loaded")
+	ErrMissingAggregateID  = errors.New("Sorry, the aggregate needs an id to record events")
+)
+
+// Event is a domain event in the stream of an aggregate.
+type Event struct {
+	ID            string            `json:"id"`
+	AggregateID   string            `json:"aggregate_id"`
+	AggregateType string            `json:"aggregate_type"`
+	Version       uint64            `json:"version"` // Position in the stream of the aggregate, starting at 1.
+	Type          string            `json:"type"`
+	Data          json.RawMessage   `json:"data"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Time          time.Time         `json:"time"`
+}
+
+// Decode unmarshals the data of the event into v.
+func (e Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Aggregate is a type rebuilt from its events. Implementations embed Root and apply events in Apply.
+type Aggregate interface {
+	// Apply changes the state of the aggregate with an event, when loading it and when recording events.
+	Apply(event Event) error
+	ID() string
+	Version() uint64
+	root() *Root
+}
+
+// Root tracks the id, the version and the recorded events of an aggregate. Embed it in aggregates.
+type Root struct {
+	id      string
+	version uint64
+	changes []Event
+}
+
+func (r *Root) root() *Root {
+	return r
+}
+
+func (r *Root) ID() string {
+	return r.id
+}
+
+// SetID names a new aggregate. Loaded aggregates get their id from the repository.
+func (r *Root) SetID(id string) {
+	r.id = id
+}
+
+// Version returns the version of the last event applied, recorded events included.
+func (r *Root) Version() uint64 {
+	return r.version
+}
+
+// Changes returns the events recorded since the aggregate was loaded or saved.
+func (r *Root) Changes() []Event {
+	return r.changes
+}
+
+// Record applies a new event of type eventType with data, encoded as JSON, to agg and keeps it for the
+// repository to save.
+func Record(agg Aggregate, eventType string, data interface{}) error {
+	r := agg.root()
+	if r.id == "" {
+		return ErrMissingAggregateID
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encode the %s event", eventType)
+	}
+	e := Event{
+		ID:          axon.GenerateRandomString(),
+		AggregateID: r.id,
+		Version:     r.version + 1,
+		Type:        eventType,
+		Data:        raw,
+		Time:        time.Now().UTC(),
+	}
+	if err := agg.Apply(e); err != nil {
+		return err
+	}
+	r.version = e.Version
+	r.changes = append(r.changes, e)
+	return nil
+}
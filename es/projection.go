@@ -0,0 +1,297 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrProjectionGap fails a projection when an event of an aggregate never arrives, usually because the projection
+// does not subscribe to every topic the aggregate publishes on.
+var ErrProjectionGap = errors.New("Sorry, an event of the aggregate never reached the projection")
+
+// DefaultGapTimeout is how long a projection waits for a missing event of an aggregate before failing.
+const DefaultGapTimeout = time.Minute
+
+// ReadModel is the state a Projection keeps up to date with the events published by repositories.
+type ReadModel interface {
+	// Apply changes the read model with an event. Events of an aggregate are applied once each and in order.
+	Apply(ctx context.Context, event Event) error
+	// Reset clears the read model before it is rebuilt.
+	Reset(ctx context.Context) error
+}
+
+// ProjectionStatus tells how far a projection got.
+type ProjectionStatus struct {
+	Name        string
+	Rebuilding  bool
+	Applied     uint64    // Events applied since the projection was created.
+	Skipped     uint64    // Events delivered again after being applied, and acknowledged only.
+	Pending     int       // Events waiting for an earlier event of their aggregate to be delivered.
+	LastEvent   time.Time // When the last event applied was recorded.
+	LastApplied time.Time // When the last event was applied.
+	// Lag is how long after being recorded the last event was applied. It is only updated when an event is
+	// applied, so it keeps its value while no event arrives; it tells how late the projection was when it last
+	// made progress, not how far behind the streams it is now, which LastApplied helps to judge.
+	Lag time.Duration
+}
+
+// pendingEvent is an event received ahead of its turn, with its deliveries to acknowledge once applied.
+type pendingEvent struct {
+	event      Event
+	deliveries []axon.Event
+	since      time.Time // When the event was first held.
+}
+
+// Projection applies the events published on topics to a read model, keeping a checkpoint per aggregate so
+// events delivered again, after a restart or a redelivery, are not applied twice.
+//
+// Versions count every event of an aggregate, so a projection must subscribe to every topic its aggregates
+// publish on: an event that never arrives holds the later events of its aggregate back, and fails the projection
+// with ErrProjectionGap after the gap timeout.
+type Projection struct {
+	name        string
+	store       axon.EventStore
+	model       ReadModel
+	checkpoints CheckpointStore
+	topics      []string
+	gapTimeout  time.Duration
+
+	mu       sync.Mutex // Serializes the events applied to the read model.
+	versions map[string]uint64
+	pending  map[string]map[uint64]*pendingEvent
+	status   ProjectionStatus
+}
+
+// NewProjection returns the projection name of the events published on topics through store into model. The
+// name identifies its checkpoints, and should not change between restarts.
+func NewProjection(name string, store axon.EventStore, model ReadModel, checkpoints CheckpointStore, topics ...string) *Projection {
+	return &Projection{
+		name:        name,
+		store:       store,
+		model:       model,
+		checkpoints: checkpoints,
+		topics:      topics,
+		gapTimeout:  DefaultGapTimeout,
+		versions:    make(map[string]uint64),
+		pending:     make(map[string]map[uint64]*pendingEvent),
+		status:      ProjectionStatus{Name: name},
+	}
+}
+
+// WithGapTimeout sets how long the projection waits for a missing event of an aggregate, and returns it.
+func (p *Projection) WithGapTimeout(timeout time.Duration) *Projection {
+	p.gapTimeout = timeout
+	return p
+}
+
+// Run subscribes to the topics and applies the events delivered, until ctx is done or a subscription fails.
+// An event is acknowledged once applied and checkpointed, so an event whose Apply fails is delivered again.
+// Subscriptions last until the store is closed, leaving the events delivered after ctx is done unacknowledged.
+// Run fails with ErrProjectionGap when an event held for an earlier one waits longer than the gap timeout.
+func (p *Projection) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(p.topics))
+	for _, topic := range p.topics {
+		go func(topic string) {
+			errs <- p.store.Subscribe(topic, func(e axon.Event) {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := p.handle(ctx, e); err != nil {
+					log.Printf("projection %s failed to handle a message of %s with the following error: %v", p.name, e.Topic(), err)
+				}
+			})
+		}(topic)
+	}
+	return p.wait(ctx, errs)
+}
+
+// wait returns the first error of errs, or fails when ctx is done or the projection has a gap.
+func (p *Projection) wait(ctx context.Context, errs <-chan error) error {
+	ticker := time.NewTicker(p.gapTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case <-ticker.C:
+			if err := p.gap(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// gap fails with ErrProjectionGap when an event was held longer than the gap timeout.
+func (p *Projection) gap() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for stream, held := range p.pending {
+		for _, h := range held {
+			if time.Since(h.since) > p.gapTimeout {
+				return errors.Wrapf(ErrProjectionGap, "%s waited %s for the version before %d", stream, p.gapTimeout, h.event.Version)
+			}
+		}
+	}
+	return nil
+}
+
+// Rebuild resets the read model and the checkpoints, then replays the topics from their earliest message and
+// follows the events published afterwards, until ctx is done, an event fails to apply or the projection has a
+// gap. It fails with
+// axon.ErrReplayUnsupported when the store cannot replay. Run and Rebuild should not be used at the same time.
+func (p *Projection) Rebuild(ctx context.Context) error {
+	p.mu.Lock()
+	p.status.Rebuilding = true
+	p.status.Pending = 0
+	p.versions = make(map[string]uint64)
+	p.pending = make(map[string]map[uint64]*pendingEvent)
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.status.Rebuilding = false
+		p.mu.Unlock()
+	}()
+
+	if _, ok := p.store.(axon.Replayer); !ok {
+		return axon.ErrReplayUnsupported
+	}
+	if err := p.model.Reset(ctx); err != nil {
+		return errors.Wrapf(err, "unable to reset the read model of %s", p.name)
+	}
+	if err := p.checkpoints.ResetCheckpoints(ctx, p.name); err != nil {
+		return errors.Wrapf(err, "unable to reset the checkpoints of %s", p.name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failure error
+	var once sync.Once
+	errs := make(chan error, len(p.topics))
+	for _, topic := range p.topics {
+		go func(topic string) {
+			errs <- axon.Replay(ctx, p.store, topic, axon.Earliest(), func(e axon.Event) {
+				if err := p.handle(ctx, e); err != nil {
+					once.Do(func() {
+						failure = err
+						cancel()
+					})
+				}
+			})
+		}(topic)
+	}
+
+	err := p.wait(ctx, errs)
+	if failure != nil {
+		return failure
+	}
+	return err
+}
+
+// Status returns how far the projection got.
+func (p *Projection) Status() ProjectionStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// handle applies the event delivered by e when it is the next one of its aggregate, followed by the events of
+// the aggregate received ahead of it, and keeps it for later when an earlier event is still missing.
+func (p *Projection) handle(ctx context.Context, e axon.Event) error {
+	var event Event
+	if err := json.Unmarshal(e.Data(), &event); err != nil || event.AggregateID == "" || event.Version == 0 {
+		log.Printf("projection %s ignored a message of %s which is not an event of an aggregate", p.name, e.Topic())
+		e.Ack()
+		return nil
+	}
+	stream := event.AggregateType + "-" + event.AggregateID
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	applied, err := p.checkpoint(ctx, stream)
+	if err != nil {
+		return err
+	}
+	if event.Version <= applied {
+		p.status.Skipped++
+		e.Ack()
+		return nil
+	}
+	if event.Version > applied+1 {
+		p.hold(stream, event, e)
+		return nil
+	}
+
+	deliveries := []axon.Event{e}
+	for {
+		if err := p.apply(ctx, stream, event); err != nil {
+			return err
+		}
+		for _, d := range deliveries {
+			d.Ack()
+		}
+
+		next, ok := p.pending[stream][event.Version+1]
+		if !ok {
+			return nil
+		}
+		delete(p.pending[stream], event.Version+1)
+		if len(p.pending[stream]) == 0 {
+			delete(p.pending, stream)
+		}
+		p.status.Pending--
+		event, deliveries = next.event, next.deliveries
+	}
+}
+
+// checkpoint returns the version of the last event of stream applied. The caller holds p.mu.
+func (p *Projection) checkpoint(ctx context.Context, stream string) (uint64, error) {
+	if version, ok := p.versions[stream]; ok {
+		return version, nil
+	}
+	version, err := p.checkpoints.LoadCheckpoint(ctx, p.name, stream)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to load the checkpoint of %s", stream)
+	}
+	p.versions[stream] = version
+	return version, nil
+}
+
+// hold keeps an event delivered ahead of its turn. The caller holds p.mu.
+func (p *Projection) hold(stream string, event Event, e axon.Event) {
+	if p.pending[stream] == nil {
+		p.pending[stream] = make(map[uint64]*pendingEvent)
+	}
+	if held, ok := p.pending[stream][event.Version]; ok {
+		held.deliveries = append(held.deliveries, e)
+		return
+	}
+	p.pending[stream][event.Version] = &pendingEvent{event: event, deliveries: []axon.Event{e}, since: time.Now()}
+	p.status.Pending++
+}
+
+// apply applies event to the read model and saves its checkpoint. The caller holds p.mu.
+func (p *Projection) apply(ctx context.Context, stream string, event Event) error {
+	if err := p.model.Apply(ctx, event); err != nil {
+		return errors.Wrapf(err, "unable to apply event %d (%s) of %s", event.Version, event.Type, stream)
+	}
+	if err := p.checkpoints.SaveCheckpoint(ctx, p.name, stream, event.Version); err != nil {
+		return errors.Wrapf(err, "unable to save the checkpoint of %s at version %d", stream, event.Version)
+	}
+	p.versions[stream] = event.Version
+
+	now := time.Now().UTC()
+	p.status.Applied++
+	p.status.LastEvent = event.Time
+	p.status.LastApplied = now
+	p.status.Lag = now.Sub(event.Time)
+	return nil
+}
@@ -0,0 +1,284 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/filelog"
+	"github.com/Just4Ease/axon/mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// balances is a read model of the balance of each account.
+type balances struct {
+	mu       sync.Mutex
+	balances map[string]int
+	applied  []uint64
+	resets   int
+}
+
+func newBalances() *balances {
+	return &balances{balances: make(map[string]int)}
+}
+
+func (b *balances) Apply(ctx context.Context, e Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	acc := &account{Balance: b.balances[e.AggregateID]}
+	if err := acc.Apply(e); err != nil {
+		return err
+	}
+	b.balances[e.AggregateID] = acc.Balance
+	b.applied = append(b.applied, e.Version)
+	return nil
+}
+
+func (b *balances) Reset(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.balances = make(map[string]int)
+	b.applied = nil
+	b.resets++
+	return nil
+}
+
+func (b *balances) balance(id string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.balances[id]
+}
+
+// testEvent is an axon.Event handed to a projection directly.
+type testEvent struct {
+	topic string
+	data  []byte
+	acks  int
+}
+
+func (e *testEvent) Ack()          { e.acks++ }
+func (e *testEvent) Data() []byte  { return e.data }
+func (e *testEvent) Topic() string { return e.topic }
+
+func publishEvent(t *testing.T, store axon.EventStore, e Event) {
+	data, err := json.Marshal(e)
+	require.Nil(t, err)
+	require.Nil(t, store.Publish("account."+e.Type, data))
+}
+
+func TestProjection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := mem.Init(axon.Options{ServiceName: "reports"}, mem.WithBroker(mem.NewBroker()))
+	require.Nil(t, err)
+	defer store.Close()
+	checkpoints := NewMemoryCheckpointStore()
+	model := newBalances()
+	p := NewProjection("balances", store, model, checkpoints, "account.deposited", "account.withdrawn")
+	go p.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	repo := NewRepository("account", NewMemoryStreamStore(), WithPublisher(store))
+	acc := &account{}
+	acc.SetID("42")
+	require.Nil(t, Record(acc, "deposited", deposited{Amount: 100}))
+	require.Nil(t, Record(acc, "withdrawn", withdrawn{Amount: 30}))
+	require.Nil(t, repo.Save(ctx, acc))
+
+	require.Eventually(t, func() bool { return p.Status().Applied == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 70, model.balance("42"))
+	assert.Equal(t, []uint64{1, 2}, model.applied)
+	version, err := checkpoints.LoadCheckpoint(ctx, "balances", "account-42")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), version)
+
+	status := p.Status()
+	assert.Equal(t, "balances", status.Name)
+	assert.False(t, status.Rebuilding)
+	assert.Zero(t, status.Pending)
+	assert.True(t, status.Lag >= 0)
+	assert.False(t, status.LastEvent.IsZero())
+}
+
+func TestProjection_ResumesFromCheckpoints(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := mem.Init(axon.Options{ServiceName: "reports"}, mem.WithBroker(mem.NewBroker()))
+	require.Nil(t, err)
+	defer store.Close()
+	checkpoints := NewMemoryCheckpointStore()
+	require.Nil(t, checkpoints.SaveCheckpoint(ctx, "balances", "account-42", 1))
+	model := newBalances()
+	p := NewProjection("balances", store, model, checkpoints, "account.deposited")
+	go p.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	publishEvent(t, store, Event{AggregateID: "42", AggregateType: "account", Version: 1, Type: "deposited", Data: json.RawMessage(`{"Amount":100}`)})
+	publishEvent(t, store, Event{AggregateID: "42", AggregateType: "account", Version: 2, Type: "deposited", Data: json.RawMessage(`{"Amount":5}`)})
+	publishEvent(t, store, Event{AggregateID: "42", AggregateType: "account", Version: 2, Type: "deposited", Data: json.RawMessage(`{"Amount":5}`)})
+
+	require.Eventually(t, func() bool {
+		s := p.Status()
+		return s.Applied == 1 && s.Skipped == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 5, model.balance("42"))
+}
+
+func TestProjection_SubsetOfTopics(t *testing.T) {
+	store, err := mem.Init(axon.Options{ServiceName: "reports"}, mem.WithBroker(mem.NewBroker()))
+	require.Nil(t, err)
+	defer store.Close()
+	model := newBalances()
+	// Withdrawals are published on account.withdrawn, which the projection does not subscribe to.
+	p := NewProjection("deposits", store, model, NewMemoryCheckpointStore(), "account.deposited").WithGapTimeout(100 * time.Millisecond)
+	errs := make(chan error, 1)
+	go func() { errs <- p.Run(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+
+	repo := NewRepository("account", NewMemoryStreamStore(), WithPublisher(store))
+	acc := &account{}
+	acc.SetID("42")
+	require.Nil(t, Record(acc, "deposited", deposited{Amount: 100}))
+	require.Nil(t, Record(acc, "withdrawn", withdrawn{Amount: 30}))
+	require.Nil(t, Record(acc, "deposited", deposited{Amount: 5}))
+	require.Nil(t, repo.Save(context.Background(), acc))
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrProjectionGap)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the projection waited for the withdrawal forever")
+	}
+	assert.Equal(t, []uint64{1}, model.applied)
+	assert.Equal(t, 1, p.Status().Pending)
+}
+
+func TestProjection_OutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	model := newBalances()
+	p := NewProjection("balances", nil, model, NewMemoryCheckpointStore())
+	event := func(version uint64, amount string) *testEvent {
+		data, err := json.Marshal(Event{AggregateID: "42", AggregateType: "account", Version: version, Type: "deposited", Data: json.RawMessage(`{"Amount":` + amount + `}`)})
+		require.Nil(t, err)
+		return &testEvent{topic: "account.deposited", data: data}
+	}
+
+	third, second, first := event(3, "1"), event(2, "10"), event(1, "100")
+	require.Nil(t, p.handle(ctx, third))
+	require.Nil(t, p.handle(ctx, second))
+	assert.Equal(t, 2, p.Status().Pending)
+	assert.Zero(t, model.balance("42"))
+	assert.Zero(t, third.acks)
+
+	require.Nil(t, p.handle(ctx, first))
+	assert.Equal(t, []uint64{1, 2, 3}, model.applied)
+	assert.Equal(t, 111, model.balance("42"))
+	assert.Zero(t, p.Status().Pending)
+	assert.Equal(t, 1, third.acks)
+	assert.Equal(t, 1, second.acks)
+}
+
+func TestProjection_Rebuild(t *testing.T) {
+	ctx := context.Background()
+	store, err := filelog.Init(axon.Options{ServiceName: "reports", Address: "file://" + t.TempDir()}, filelog.PollInterval(5*time.Millisecond))
+	require.Nil(t, err)
+	defer store.Close()
+	repo := NewRepository("account", NewMemoryStreamStore(), WithPublisher(store))
+	for _, id := range []string{"1", "2"} {
+		acc := &account{}
+		acc.SetID(id)
+		require.Nil(t, Record(acc, "deposited", deposited{Amount: 100}))
+		require.Nil(t, Record(acc, "withdrawn", withdrawn{Amount: 40}))
+		require.Nil(t, repo.Save(ctx, acc))
+	}
+
+	checkpoints, err := NewFileCheckpointStore(t.TempDir())
+	require.Nil(t, err)
+	require.Nil(t, checkpoints.SaveCheckpoint(ctx, "balances", "account-1", 2))
+	model := newBalances()
+	model.balances["stale"] = 1
+	p := NewProjection("balances", store, model, checkpoints, "account.deposited", "account.withdrawn")
+
+	rebuildCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Rebuild(rebuildCtx)
+	}()
+	require.Eventually(t, func() bool { return p.Status().Applied == 4 }, 2*time.Second, 5*time.Millisecond)
+	assert.True(t, p.Status().Rebuilding)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	assert.Equal(t, 1, model.resets)
+	assert.Equal(t, map[string]int{"1": 60, "2": 60}, model.balances)
+	assert.False(t, p.Status().Rebuilding)
+	version, err := checkpoints.LoadCheckpoint(ctx, "balances", "account-2")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), version)
+}
+
+func TestProjection_RebuildUnsupported(t *testing.T) {
+	store, err := mem.Init(axon.Options{ServiceName: "reports"}, mem.WithBroker(mem.NewBroker()))
+	require.Nil(t, err)
+	defer store.Close()
+	model := newBalances()
+	p := NewProjection("balances", store, model, NewMemoryCheckpointStore(), "account.deposited")
+	assert.Equal(t, axon.ErrReplayUnsupported, p.Rebuild(context.Background()))
+	assert.Zero(t, model.resets)
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	checkpoints, err := NewFileCheckpointStore(dir)
+	require.Nil(t, err)
+	require.Nil(t, checkpoints.SaveCheckpoint(ctx, "balances", "account-1", 1))
+	require.Nil(t, checkpoints.SaveCheckpoint(ctx, "balances", "account-1", 2))
+	require.Nil(t, checkpoints.SaveCheckpoint(ctx, "balances/eu", "account-1", 7))
+
+	reopened, err := NewFileCheckpointStore(dir)
+	require.Nil(t, err)
+	version, err := reopened.LoadCheckpoint(ctx, "balances", "account-1")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), version)
+	version, err = reopened.LoadCheckpoint(ctx, "balances/eu", "account-1")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(7), version)
+
+	require.Nil(t, reopened.ResetCheckpoints(ctx, "balances"))
+	version, err = reopened.LoadCheckpoint(ctx, "balances", "account-1")
+	require.Nil(t, err)
+	assert.Zero(t, version)
+	version, err = reopened.LoadCheckpoint(ctx, "balances/eu", "account-1")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(7), version)
+}
+
+func TestFileCheckpointStore_Compacts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	checkpoints, err := NewFileCheckpointStore(dir)
+	require.Nil(t, err)
+	for version := uint64(1); version <= 3000; version++ {
+		require.Nil(t, checkpoints.SaveCheckpoint(ctx, "balances", "account-1", version))
+		require.Nil(t, checkpoints.SaveCheckpoint(ctx, "balances", "account-2", 2*version))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "balances"+checkpointExt))
+	require.Nil(t, err)
+	assert.LessOrEqual(t, bytes.Count(data, []byte("\n")), 2*2+compactSlack+1, "the file grows with the streams, not the events")
+	reopened, err := NewFileCheckpointStore(dir)
+	require.Nil(t, err)
+	version, err := reopened.LoadCheckpoint(ctx, "balances", "account-1")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(3000), version)
+	version, err = reopened.LoadCheckpoint(ctx, "balances", "account-2")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(6000), version)
+}
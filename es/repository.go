@@ -0,0 +1,196 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"log"
+	"time"
+)
+
+// StreamStore keeps the streams of events of aggregates.
+type StreamStore interface {
+	// Append adds events to the end of stream if its version is still expected, or fails with
+	// ErrConcurrencyConflict. The version of a stream is the version of its last event, 0 when empty.
+	Append(ctx context.Context, stream string, expected uint64, events []Event) error
+
+	// Load returns the events of stream whose version is above after, in order.
+	Load(ctx context.Context, stream string, after uint64) ([]Event, error)
+}
+
+// Repository loads and saves the aggregates of one type.
+type Repository struct {
+	aggregateType  string
+	streams        StreamStore
+	publisher      axon.EventStore
+	topic          func(Event) string
+	snapshots      SnapshotStore
+	snapshotPolicy SnapshotPolicy
+}
+
+type Option func(*Repository)
+
+// WithPublisher publishes the saved events through store, encoded as JSON Events.
+func WithPublisher(store axon.EventStore) Option {
+	return func(r *Repository) {
+		r.publisher = store
+	}
+}
+
+// Topic names the topic an event is published on. Defaults to `<aggregate type>.<event type>`, such as
+// `account.deposited`.
+func Topic(topic func(Event) string) Option {
+	return func(r *Repository) {
+		r.topic = topic
+	}
+}
+
+// NewRepository returns the repository of the aggregates of aggregateType, whose streams are kept by streams.
+func NewRepository(aggregateType string, streams StreamStore, options ...Option) *Repository {
+	r := &Repository{
+		aggregateType: aggregateType,
+		streams:       streams,
+		topic: func(e Event) string {
+			return e.AggregateType + "." + e.Type
+		},
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// StreamID returns the stream of the aggregate id, `<aggregate type>-<id>`.
+func (r *Repository) StreamID(id string) string {
+	return r.aggregateType + "-" + id
+}
+
+// Load rebuilds agg, normally new, by applying the events of the aggregate id after its version in order,
+// starting from its latest snapshot when there is one. agg is named id even when no events were recorded for
+// it, in which case Load returns ErrAggregateNotFound.
+func (r *Repository) Load(ctx context.Context, id string, agg Aggregate) error {
+	root := agg.root()
+	root.id = id
+	if err := r.restore(ctx, agg); err != nil {
+		return err
+	}
+	events, err := r.streams.Load(ctx, r.StreamID(id), root.version)
+	if err != nil {
+		return err
+	}
+	if err := apply(agg, events); err != nil {
+		return err
+	}
+	if root.version == 0 {
+		return ErrAggregateNotFound
+	}
+	return nil
+}
+
+// apply applies events, which follow the current version of agg, to agg.
+func apply(agg Aggregate, events []Event) error {
+	root := agg.root()
+	for _, e := range events {
+		if e.Version != root.version+1 {
+			return errors.Errorf("event %d of %s follows version %d", e.Version, root.id, root.version)
+		}
+		if err := agg.Apply(e); err != nil {
+			return errors.Wrapf(err, "unable to apply event %d (%s) of %s", e.Version, e.Type, root.id)
+		}
+		root.version = e.Version
+	}
+	return nil
+}
+
+// Save appends the events recorded on agg to its stream, failing with ErrConcurrencyConflict when the stream
+// was changed since agg was loaded, then publishes them. Events are kept in the stream even when publishing
+// fails, in which case Save returns the error of the publisher.
+func (r *Repository) Save(ctx context.Context, agg Aggregate) error {
+	root := agg.root()
+	if len(root.changes) == 0 {
+		return nil
+	}
+	if root.id == "" {
+		return ErrMissingAggregateID
+	}
+
+	events := make([]Event, len(root.changes))
+	for i, e := range root.changes {
+		e.AggregateType = r.aggregateType
+		events[i] = e
+	}
+	expected := root.version - uint64(len(events))
+	if err := r.streams.Append(ctx, r.StreamID(root.id), expected, events); err != nil {
+		return err
+	}
+	root.changes = nil
+	if r.snapshotPolicy != nil && r.snapshotPolicy(agg, events) {
+		if err := r.snapshot(ctx, agg); err != nil {
+			log.Printf("failed to snapshot %s at version %d with the following error: %v", r.StreamID(root.id), root.version, err)
+		}
+	}
+
+	if r.publisher == nil {
+		return nil
+	}
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := r.publisher.Publish(r.topic(e), data); err != nil {
+			return errors.Wrapf(err, "event %d of %s was saved but not published", e.Version, root.id)
+		}
+	}
+	return nil
+}
+
+// restore sets a new agg to the latest snapshot of its stream, if any and in a format it supports.
+func (r *Repository) restore(ctx context.Context, agg Aggregate) error {
+	s, ok := agg.(Snapshotter)
+	if !ok || r.snapshots == nil || agg.Version() > 0 {
+		return nil
+	}
+
+	snapshot, err := r.snapshots.LoadSnapshot(ctx, r.StreamID(agg.ID()))
+	if err == ErrSnapshotNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Restore(snapshot); err != nil {
+		if errors.Is(err, ErrUnsupportedSnapshot) {
+			return nil // Rebuilt from all the events instead.
+		}
+		return errors.Wrapf(err, "unable to restore the snapshot of %s at version %d", agg.ID(), snapshot.Version)
+	}
+	agg.root().version = snapshot.Version
+	return nil
+}
+
+// snapshot saves the current state of agg, when it is a Snapshotter.
+func (r *Repository) snapshot(ctx context.Context, agg Aggregate) error {
+	s, ok := agg.(Snapshotter)
+	if !ok || r.snapshots == nil {
+		return nil
+	}
+
+	format, state, err := s.Snapshot()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return r.snapshots.SaveSnapshot(ctx, r.StreamID(agg.ID()), Snapshot{
+		AggregateID:   agg.ID(),
+		AggregateType: r.aggregateType,
+		Version:       agg.Version(),
+		Format:        format,
+		State:         data,
+		Time:          time.Now().UTC(),
+	})
+}
@@ -0,0 +1,112 @@
+package es
+
+import (
+	"context"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/Just4Ease/axon/mem"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var errInsufficientFunds = errors.New("insufficient funds")
+
+type deposited struct {
+	Amount int
+}
+
+type withdrawn struct {
+	Amount int
+}
+
+type account struct {
+	Root
+	Balance int
+}
+
+func (a *account) Apply(e Event) error {
+	switch e.Type {
+	case "deposited":
+		var d deposited
+		if err := e.Decode(&d); err != nil {
+			return err
+		}
+		a.Balance += d.Amount
+	case "withdrawn":
+		var w withdrawn
+		if err := e.Decode(&w); err != nil {
+			return err
+		}
+		if w.Amount > a.Balance {
+			return errInsufficientFunds
+		}
+		a.Balance -= w.Amount
+	}
+	return nil
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	store, err := mem.Init(axon.Options{ServiceName: "accounts"}, mem.WithBroker(mem.NewBroker()))
+	require.Nil(t, err)
+	defer store.Close()
+	publisher := axontest.Record(store)
+	repo := NewRepository("account", NewMemoryStreamStore(), WithPublisher(publisher))
+
+	acc := &account{}
+	err = repo.Load(ctx, "42", acc)
+	assert.Equal(t, ErrAggregateNotFound, err)
+	assert.Equal(t, "42", acc.ID())
+	require.Nil(t, Record(acc, "deposited", deposited{Amount: 100}))
+	require.Nil(t, Record(acc, "withdrawn", withdrawn{Amount: 30}))
+	assert.Equal(t, errInsufficientFunds, Record(acc, "withdrawn", withdrawn{Amount: 500}))
+	assert.Equal(t, uint64(2), acc.Version())
+	require.Nil(t, repo.Save(ctx, acc))
+	assert.Empty(t, acc.Changes())
+
+	loaded := &account{}
+	require.Nil(t, repo.Load(ctx, "42", loaded))
+	assert.Equal(t, 70, loaded.Balance)
+	assert.Equal(t, uint64(2), loaded.Version())
+
+	published, err := publisher.WaitPublished("account.withdrawn", 1, time.Second)
+	require.Nil(t, err)
+	var e Event
+	require.Nil(t, published[0].Decode(&e))
+	assert.Equal(t, "account", e.AggregateType)
+	assert.Equal(t, "42", e.AggregateID)
+	assert.Equal(t, uint64(2), e.Version)
+	assert.JSONEq(t, `{"Amount":30}`, string(e.Data))
+
+	// The first of two concurrent changes wins.
+	first, second := &account{}, &account{}
+	require.Nil(t, repo.Load(ctx, "42", first))
+	require.Nil(t, repo.Load(ctx, "42", second))
+	require.Nil(t, Record(first, "deposited", deposited{Amount: 1}))
+	require.Nil(t, Record(second, "deposited", deposited{Amount: 2}))
+	require.Nil(t, repo.Save(ctx, first))
+	assert.ErrorIs(t, repo.Save(ctx, second), ErrConcurrencyConflict)
+
+	assert.Equal(t, ErrMissingAggregateID, Record(&account{}, "deposited", deposited{Amount: 1}))
+}
+
+func TestRepository_Topic(t *testing.T) {
+	ctx := context.Background()
+	store, err := mem.Init(axon.Options{ServiceName: "accounts"}, mem.WithBroker(mem.NewBroker()))
+	require.Nil(t, err)
+	defer store.Close()
+	publisher := axontest.Record(store)
+	repo := NewRepository("account", NewMemoryStreamStore(), WithPublisher(publisher), Topic(func(e Event) string {
+		return "bank.events"
+	}))
+
+	acc := &account{}
+	acc.SetID("7")
+	require.Nil(t, Record(acc, "deposited", deposited{Amount: 5}))
+	require.Nil(t, repo.Save(ctx, acc))
+	assert.Len(t, publisher.Published("bank.events"), 1)
+	assert.Equal(t, "account-7", repo.StreamID("7"))
+}
@@ -0,0 +1,101 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSnapshotNotFound    = errors.New("Sorry, no snapshot was taken of this aggregate")
+	ErrUnsupportedSnapshot = errors.New("Sorry, the aggregate cannot restore snapshots of this format")
+)
+
+// Snapshot is the state of an aggregate at a version, to load it without replaying the events before.
+type Snapshot struct {
+	AggregateID   string          `json:"aggregate_id"`
+	AggregateType string          `json:"aggregate_type"`
+	Version       uint64          `json:"version"` // Version of the last event included.
+	Format        int             `json:"format"`  // Version of the encoding of State, chosen by the aggregate.
+	State         json.RawMessage `json:"state"`
+	Time          time.Time       `json:"time"`
+}
+
+// Decode unmarshals the state of the snapshot into v.
+func (s Snapshot) Decode(v interface{}) error {
+	return json.Unmarshal(s.State, v)
+}
+
+// Snapshotter is implemented by the aggregates a repository may snapshot.
+type Snapshotter interface {
+	Aggregate
+
+	// Snapshot returns the state of the aggregate, encoded as JSON, and the version of its format. Bump the format
+	// when the state changes shape.
+	Snapshot() (format int, state interface{}, err error)
+
+	// Restore sets the state of the aggregate from a snapshot. Formats it cannot read are rejected with
+	// ErrUnsupportedSnapshot before changing the aggregate, which is then rebuilt from all its events.
+	Restore(snapshot Snapshot) error
+}
+
+// SnapshotStore keeps the latest snapshot of each stream.
+type SnapshotStore interface {
+	// SaveSnapshot keeps snapshot unless the stream has a snapshot of a later version.
+	SaveSnapshot(ctx context.Context, stream string, snapshot Snapshot) error
+
+	// LoadSnapshot returns the latest snapshot of stream, or ErrSnapshotNotFound.
+	LoadSnapshot(ctx context.Context, stream string) (Snapshot, error)
+}
+
+// SnapshotPolicy tells whether to snapshot agg once the events just saved were appended to its stream.
+type SnapshotPolicy func(agg Aggregate, saved []Event) bool
+
+// EveryNEvents snapshots aggregates each time their version reaches a multiple of n.
+func EveryNEvents(n uint64) SnapshotPolicy {
+	return func(agg Aggregate, saved []Event) bool {
+		version := agg.Version()
+		return n > 0 && (version-uint64(len(saved)))/n != version/n
+	}
+}
+
+// WithSnapshots snapshots the aggregates implementing Snapshotter into store when policy says so, and loads
+// them from their latest snapshot.
+func WithSnapshots(store SnapshotStore, policy SnapshotPolicy) Option {
+	return func(r *Repository) {
+		r.snapshots = store
+		r.snapshotPolicy = policy
+	}
+}
+
+type memorySnapshotStore struct {
+	mu        sync.RWMutex
+	snapshots map[string]Snapshot
+}
+
+// NewMemorySnapshotStore returns a SnapshotStore keeping the snapshots in memory, for tests and prototypes.
+func NewMemorySnapshotStore() SnapshotStore {
+	return &memorySnapshotStore{snapshots: make(map[string]Snapshot)}
+}
+
+func (s *memorySnapshotStore) SaveSnapshot(ctx context.Context, stream string, snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.snapshots[stream]; ok && current.Version > snapshot.Version {
+		return nil
+	}
+	s.snapshots[stream] = snapshot
+	return nil
+}
+
+func (s *memorySnapshotStore) LoadSnapshot(ctx context.Context, stream string) (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot, ok := s.snapshots[stream]
+	if !ok {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,81 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const snapshotExt = ".snapshot.json"
+
+type fileSnapshotStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileSnapshotStore returns a SnapshotStore keeping the latest snapshot of each stream in a JSON file of dir.
+// The directory is created when missing.
+func NewFileSnapshotStore(dir string) (SnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "unable to create the snapshot directory")
+	}
+	return &fileSnapshotStore{dir: dir}, nil
+}
+
+func (s *fileSnapshotStore) path(stream string) string {
+	return filepath.Join(s.dir, url.PathEscape(stream)+snapshotExt)
+}
+
+func (s *fileSnapshotStore) SaveSnapshot(ctx context.Context, stream string, snapshot Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, err := s.LoadSnapshot(ctx, stream)
+	if err == nil && current.Version > snapshot.Version {
+		return nil
+	}
+
+	// The snapshot replaces the previous one at once, so a crash never leaves half of it.
+	tmp, err := ioutil.TempFile(s.dir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(stream))
+}
+
+func (s *fileSnapshotStore) LoadSnapshot(ctx context.Context, stream string) (Snapshot, error) {
+	data, err := ioutil.ReadFile(s.path(stream))
+	if os.IsNotExist(err) {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return Snapshot{}, errors.Wrapf(err, "the snapshot of %s is corrupt", stream)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,122 @@
+package es
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// snapshotAccount is an account counting the events it applied, whose snapshots are in format 2. Format 1
+// snapshots, from before the balance was renamed, are still restored.
+type snapshotAccount struct {
+	account
+	applied int
+}
+
+func (a *snapshotAccount) Apply(e Event) error {
+	a.applied++
+	return a.account.Apply(e)
+}
+
+func (a *snapshotAccount) Snapshot() (int, interface{}, error) {
+	return 2, map[string]int{"balance": a.Balance}, nil
+}
+
+func (a *snapshotAccount) Restore(s Snapshot) error {
+	var state map[string]int
+	switch s.Format {
+	case 1:
+		if err := s.Decode(&state); err != nil {
+			return err
+		}
+		a.Balance = state["amount"]
+	case 2:
+		if err := s.Decode(&state); err != nil {
+			return err
+		}
+		a.Balance = state["balance"]
+	default:
+		return ErrUnsupportedSnapshot
+	}
+	return nil
+}
+
+func testSnapshotStore(t *testing.T, s SnapshotStore) {
+	ctx := context.Background()
+	_, err := s.LoadSnapshot(ctx, "account-1")
+	assert.Equal(t, ErrSnapshotNotFound, err)
+
+	require.Nil(t, s.SaveSnapshot(ctx, "account-1", Snapshot{Version: 10, State: []byte(`{"balance":10}`)}))
+	require.Nil(t, s.SaveSnapshot(ctx, "account-1", Snapshot{Version: 5, State: []byte(`{"balance":5}`)}))
+	snapshot, err := s.LoadSnapshot(ctx, "account-1")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(10), snapshot.Version, "older snapshots do not replace newer ones")
+	assert.JSONEq(t, `{"balance":10}`, string(snapshot.State))
+}
+
+func TestMemorySnapshotStore(t *testing.T) {
+	testSnapshotStore(t, NewMemorySnapshotStore())
+}
+
+func TestFileSnapshotStore(t *testing.T) {
+	s, err := NewFileSnapshotStore(t.TempDir())
+	require.Nil(t, err)
+	testSnapshotStore(t, s)
+}
+
+func TestEveryNEvents(t *testing.T) {
+	policy := EveryNEvents(3)
+	acc := &account{}
+	acc.version = 4
+	assert.True(t, policy(acc, events(3, 4)), "version 3 was crossed")
+	assert.False(t, policy(acc, events(4, 4)))
+	acc.version = 6
+	assert.True(t, policy(acc, events(5, 6)))
+	assert.False(t, EveryNEvents(0)(acc, events(5, 6)))
+}
+
+func TestRepository_Snapshots(t *testing.T) {
+	ctx := context.Background()
+	streams, snapshots := NewMemoryStreamStore(), NewMemorySnapshotStore()
+	repo := NewRepository("account", streams, WithSnapshots(snapshots, EveryNEvents(10)))
+
+	acc := &snapshotAccount{}
+	acc.SetID("42")
+	for i := 0; i < 25; i++ {
+		require.Nil(t, Record(acc, "deposited", deposited{Amount: 1}))
+		if i%4 == 3 {
+			require.Nil(t, repo.Save(ctx, acc))
+		}
+	}
+	require.Nil(t, repo.Save(ctx, acc))
+
+	snapshot, err := snapshots.LoadSnapshot(ctx, "account-42")
+	require.Nil(t, err)
+	assert.Equal(t, uint64(20), snapshot.Version, "taken by the save reaching version 20")
+	assert.Equal(t, 2, snapshot.Format)
+
+	loaded := &snapshotAccount{}
+	require.Nil(t, repo.Load(ctx, "42", loaded))
+	assert.Equal(t, 25, loaded.Balance)
+	assert.Equal(t, uint64(25), loaded.Version())
+	assert.Equal(t, 5, loaded.applied, "only the events after the snapshot are replayed")
+
+	// Snapshots of an older format are upgraded, unknown formats fall back to replaying every event.
+	require.Nil(t, snapshots.SaveSnapshot(ctx, "account-42", Snapshot{Version: 25, Format: 1, State: []byte(`{"amount":25}`)}))
+	loaded = &snapshotAccount{}
+	require.Nil(t, repo.Load(ctx, "42", loaded))
+	assert.Equal(t, 25, loaded.Balance)
+	assert.Equal(t, 0, loaded.applied)
+
+	require.Nil(t, snapshots.SaveSnapshot(ctx, "account-42", Snapshot{Version: 25, Format: 3, State: []byte(`{}`)}))
+	loaded = &snapshotAccount{}
+	require.Nil(t, repo.Load(ctx, "42", loaded))
+	assert.Equal(t, 25, loaded.Balance)
+	assert.Equal(t, 25, loaded.applied)
+
+	// Aggregates which are not Snapshotters are loaded from their events.
+	plain := &account{}
+	require.Nil(t, repo.Load(ctx, "42", plain))
+	assert.Equal(t, 25, plain.Balance)
+}
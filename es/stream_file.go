@@ -0,0 +1,150 @@
+package es
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const streamExt = ".jsonl"
+
+// fileStream is what the store knows of a stream file, valid while the file keeps its size.
+type fileStream struct {
+	size    int64
+	version uint64
+}
+
+type fileStreamStore struct {
+	dir     string
+	mu      sync.Mutex
+	streams map[string]fileStream
+}
+
+// NewFileStreamStore returns a StreamStore keeping each stream in a file of dir, one JSON event per line. The
+// directory is created when missing. Streams should be written by a single process at a time.
+func NewFileStreamStore(dir string) (StreamStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "unable to create the stream directory")
+	}
+	return &fileStreamStore{dir: dir, streams: make(map[string]fileStream)}, nil
+}
+
+func (s *fileStreamStore) path(stream string) string {
+	return filepath.Join(s.dir, url.PathEscape(stream)+streamExt)
+}
+
+func (s *fileStreamStore) Append(ctx context.Context, stream string, expected uint64, events []Event) error {
+	var buf bytes.Buffer
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path(stream), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	current, err := s.stat(stream, f)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(stream, current.version, expected, events); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf.Bytes(), current.size); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	s.streams[stream] = fileStream{size: current.size + int64(buf.Len()), version: expected + uint64(len(events))}
+	return nil
+}
+
+// stat returns the size of the complete events of the stream file and its version, truncating an event left
+// incomplete by a crash. The caller holds s.mu.
+func (s *fileStreamStore) stat(stream string, f *os.File) (fileStream, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return fileStream{}, err
+	}
+	if cached, ok := s.streams[stream]; ok && cached.size == info.Size() {
+		return cached, nil
+	}
+
+	var current fileStream
+	err = readLines(f, func(line []byte) error {
+		current.size += int64(len(line))
+		current.version++
+		return nil
+	})
+	if err != nil {
+		return fileStream{}, err
+	}
+	if current.size < info.Size() {
+		if err := f.Truncate(current.size); err != nil {
+			return fileStream{}, err
+		}
+	}
+	s.streams[stream] = current
+	return current, nil
+}
+
+func (s *fileStreamStore) Load(ctx context.Context, stream string, after uint64) ([]Event, error) {
+	f, err := os.Open(s.path(stream))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []Event
+	var version uint64
+	err = readLines(f, func(line []byte) error {
+		if version++; version <= after {
+			return nil
+		}
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return errors.Wrapf(err, "event %d of %s is corrupt", version, stream)
+		}
+		events = append(events, e)
+		return nil
+	})
+	return events, err
+}
+
+// readLines calls fn with each complete line of r, its newline included. A last line without newline is an
+// event whose write was interrupted, and is ignored.
+func readLines(r io.Reader, fn func(line []byte) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
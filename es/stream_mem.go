@@ -0,0 +1,50 @@
+package es
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync"
+)
+
+type memoryStreamStore struct {
+	mu      sync.RWMutex
+	streams map[string][]Event
+}
+
+// NewMemoryStreamStore returns a StreamStore keeping the streams in memory, for tests and prototypes.
+func NewMemoryStreamStore() StreamStore {
+	return &memoryStreamStore{streams: make(map[string][]Event)}
+}
+
+func (s *memoryStreamStore) Append(ctx context.Context, stream string, expected uint64, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := checkVersion(stream, uint64(len(s.streams[stream])), expected, events); err != nil {
+		return err
+	}
+	s.streams[stream] = append(s.streams[stream], events...)
+	return nil
+}
+
+func (s *memoryStreamStore) Load(ctx context.Context, stream string, after uint64) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := s.streams[stream]
+	if after >= uint64(len(events)) {
+		return nil, nil
+	}
+	return append([]Event(nil), events[after:]...), nil
+}
+
+// checkVersion checks that a stream at version current is at expected, and that events follow it.
+func checkVersion(stream string, current, expected uint64, events []Event) error {
+	if current != expected {
+		return errors.Wrapf(ErrConcurrencyConflict, "%s is at version %d, not %d", stream, current, expected)
+	}
+	for i, e := range events {
+		if e.Version != expected+uint64(i)+1 {
+			return errors.Errorf("event %d appended to %s at version %d", e.Version, stream, expected+uint64(i))
+		}
+	}
+	return nil
+}
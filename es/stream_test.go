@@ -0,0 +1,86 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func events(from, to uint64) []Event {
+	var out []Event
+	for v := from; v <= to; v++ {
+		out = append(out, Event{ID: fmt.Sprint(v), Version: v, Type: "deposited", Data: []byte(`{}`)})
+	}
+	return out
+}
+
+func versions(events []Event) []uint64 {
+	var out []uint64
+	for _, e := range events {
+		out = append(out, e.Version)
+	}
+	return out
+}
+
+func testStreamStore(t *testing.T, s StreamStore) {
+	ctx := context.Background()
+	loaded, err := s.Load(ctx, "account-1", 0)
+	require.Nil(t, err)
+	assert.Empty(t, loaded)
+
+	require.Nil(t, s.Append(ctx, "account-1", 0, events(1, 2)))
+	require.Nil(t, s.Append(ctx, "account-1", 2, events(3, 3)))
+	require.Nil(t, s.Append(ctx, "account-2", 0, events(1, 1)))
+
+	err = s.Append(ctx, "account-1", 2, events(3, 3))
+	assert.ErrorIs(t, err, ErrConcurrencyConflict)
+	assert.NotNil(t, s.Append(ctx, "account-1", 3, events(5, 5)), "versions follow the stream")
+
+	loaded, err = s.Load(ctx, "account-1", 0)
+	require.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, versions(loaded))
+	loaded, err = s.Load(ctx, "account-1", 2)
+	require.Nil(t, err)
+	assert.Equal(t, []uint64{3}, versions(loaded))
+	loaded, err = s.Load(ctx, "account-1", 3)
+	require.Nil(t, err)
+	assert.Empty(t, loaded)
+}
+
+func TestMemoryStreamStore(t *testing.T) {
+	testStreamStore(t, NewMemoryStreamStore())
+}
+
+func TestFileStreamStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStreamStore(dir)
+	require.Nil(t, err)
+	testStreamStore(t, s)
+
+	// The streams are read back by another store, and an event torn by a crash is dropped.
+	path := filepath.Join(dir, "account%2F3"+streamExt)
+	require.Nil(t, s.Append(context.Background(), "account/3", 0, events(1, 1)))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.Nil(t, err)
+	_, err = f.WriteString(`{"id":"2","vers`)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	reopened, err := NewFileStreamStore(dir)
+	require.Nil(t, err)
+	loaded, err := reopened.Load(context.Background(), "account-1", 0)
+	require.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, versions(loaded))
+	loaded, err = reopened.Load(context.Background(), "account/3", 0)
+	require.Nil(t, err)
+	assert.Equal(t, []uint64{1}, versions(loaded))
+
+	require.Nil(t, reopened.Append(context.Background(), "account/3", 1, events(2, 2)))
+	loaded, err = reopened.Load(context.Background(), "account/3", 0)
+	require.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2}, versions(loaded))
+}
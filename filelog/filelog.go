@@ -0,0 +1,394 @@
+// Package filelog implements axon.EventStore on append-only log files in a local directory, to run a system on a
+// laptop or an air-gapped machine without a broker.
+//
+// Each topic is a log of segment files under `topics/`. Every service reads a topic from an offset persisted under
+// `offsets/<service>/`, so it resumes where it stopped after a restart: each message is delivered to one
+// subscriber of the service within a process, and delivered again when it is not acknowledged within the ack
+// wait. Processes sharing the directory see each other's messages by polling the logs, but a service should be
+// consumed by one process at a time. Requests go through logs of their own under `requests/` and replies are
+// written to an inbox file of the caller.
+package filelog
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAckWait        = 30 * time.Second
+	defaultPollInterval   = 50 * time.Millisecond
+	defaultRequestTimeout = 5 * time.Second
+
+	topicsDir   = "topics"
+	requestsDir = "requests"
+	inboxDir    = "inbox"
+)
+
+type Option func(*fileStore)
+
+// AckWait sets how long a delivered message may stay unacknowledged before it is delivered again.
+func AckWait(d time.Duration) Option {
+	return func(s *fileStore) {
+		s.ackWait = d
+	}
+}
+
+// PollInterval sets how often subscribers and requests check the files for new messages and replies.
+func PollInterval(d time.Duration) Option {
+	return func(s *fileStore) {
+		s.pollInterval = d
+	}
+}
+
+// SegmentSize sets the size in bytes past which a topic log starts a new segment file. Defaults to 64MB.
+func SegmentSize(n int64) Option {
+	return func(s *fileStore) {
+		s.segmentSize = n
+	}
+}
+
+// Retention removes the log segments older than d, whether or not every service read them, whenever a
+// new segment is started. Topic logs are kept whole by default, so they can be replayed from the start.
+func Retention(d time.Duration) Option {
+	return func(s *fileStore) {
+		s.retention = d
+	}
+}
+
+// SyncWrites flushes every message to disk before Publish returns, so messages survive a power loss and not only
+// a restart of the process.
+func SyncWrites() Option {
+	return func(s *fileStore) {
+		s.syncWrites = true
+	}
+}
+
+type fileStore struct {
+	*axon.StateTracker
+	dir          *directory
+	serviceName  string
+	opts         axon.Options
+	ackWait      time.Duration
+	pollInterval time.Duration
+	segmentSize  int64
+	syncWrites   bool
+	retention    time.Duration
+	running      sync.WaitGroup // Subscriptions and replies in flight, which Close waits for.
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+// Init opens the store on the directory of opts.Address, such as `file:///var/lib/axon` or `file://./data` for a
+// path relative to the working directory. The directory is created when missing.
+func Init(opts axon.Options, options ...Option) (axon.EventStore, error) {
+	name := strings.TrimSpace(opts.ServiceName)
+	if name == "" {
+		return nil, axon.ErrEmptyStoreName
+	}
+
+	path, err := directoryPath(strings.TrimSpace(opts.Address))
+	if err != nil {
+		return nil, err
+	}
+	dir, err := openDirectory(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileStore{
+		StateTracker: axon.NewStateTracker(axon.StateConnected),
+		dir:          dir,
+		serviceName:  name,
+		opts:         opts,
+		ackWait:      defaultAckWait,
+		pollInterval: defaultPollInterval,
+		segmentSize:  defaultSegmentSize,
+		closed:       make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.ackWait <= 0 {
+		s.ackWait = defaultAckWait
+	}
+	if s.pollInterval <= 0 {
+		s.pollInterval = defaultPollInterval
+	}
+	if s.segmentSize <= 0 {
+		s.segmentSize = defaultSegmentSize
+	}
+	return s, nil
+}
+
+func directoryPath(addr string) (string, error) {
+	if addr == "" {
+		return "", axon.ErrInvalidURL
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", axon.ErrInvalidURL
+	}
+	switch u.Scheme {
+	case "":
+		return addr, nil
+	case "file":
+		if path := u.Host + u.Path; path != "" {
+			return filepath.FromSlash(path), nil
+		}
+	}
+	return "", axon.ErrInvalidURL
+}
+
+func (s *fileStore) GetServiceName() string {
+	return s.serviceName
+}
+
+func (s *fileStore) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *fileStore) log(kind, topic string) (*topicLog, error) {
+	return s.dir.log(kind, topic, s.segmentSize, s.syncWrites, s.retention)
+}
+
+func (s *fileStore) Publish(topic string, message []byte) error {
+	if s.isClosed() {
+		return axon.ErrCloseConn
+	}
+
+	l, err := s.log(topicsDir, topic)
+	if err != nil {
+		return err
+	}
+	_, err = l.Append(message)
+	return err
+}
+
+// Subscribe delivers the messages of topic to one subscriber of the service, blocking until the store is closed.
+func (s *fileStore) Subscribe(topic string, handler axon.SubscriptionHandler) error {
+	topicOpts := s.opts.Topic(topic)
+	limiter := axon.NewLimiter(topicOpts.Concurrency)
+	ackWait := s.ackWait
+	if topicOpts.AckWait > 0 {
+		ackWait = topicOpts.AckWait
+	}
+
+	return s.consume(topicsDir, topic, &member{ackWait: ackWait, deliver: func(e *event) {
+		limiter.Go(handler, e)
+	}})
+}
+
+// consume runs m in the group of the service on the kind log of topic until the store is closed.
+func (s *fileStore) consume(kind, topic string, m *member) error {
+	if s.isClosed() {
+		return axon.ErrCloseConn
+	}
+
+	l, err := s.log(kind, topic)
+	if err != nil {
+		return err
+	}
+	g := s.dir.group(s.serviceName, topic, l, s.pollInterval)
+	s.running.Add(1)
+	defer s.running.Done()
+	if err := g.join(m); err != nil {
+		return err
+	}
+	<-s.closed
+	g.leave(m)
+	return axon.ErrCloseConn
+}
+
+// Replay reads topic again from an offset, given as axon.AtSequence, or from axon.Earliest or axon.Latest, and
+// follows the messages published afterwards until the store is closed. The offset of the service is left untouched.
+func (s *fileStore) Replay(topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	return s.ReplayContext(context.Background(), topic, from, handler)
+}
+
+func (s *fileStore) ReplayContext(ctx context.Context, topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	if s.isClosed() {
+		return axon.ErrCloseConn
+	}
+
+	var offset uint64
+	switch from.Kind {
+	case axon.PositionEarliest, axon.PositionLatest:
+	case axon.PositionSequence:
+		offset = from.Sequence
+	default:
+		return from.Unsupported() // Records carry no publish time nor other identifier.
+	}
+
+	l, err := s.log(topicsDir, topic)
+	if err != nil {
+		return err
+	}
+	if from.Kind == axon.PositionLatest {
+		if offset, err = l.End(); err != nil {
+			return err
+		}
+	}
+	r := l.newReader(offset)
+	defer r.Close()
+	for {
+		seq, data, err := r.Next()
+		if err == nil {
+			handler(&event{topic: topic, offset: seq, data: data})
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+
+		select {
+		case <-s.closed:
+			return axon.ErrCloseConn
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+func (s *fileStore) Request(topic string, payload []byte, v interface{}, opts ...axon.RequestOption) error {
+	if s.isClosed() {
+		return axon.ErrCloseConn
+	}
+
+	if timeout := s.opts.Topic(topic).RequestTimeout; timeout > 0 {
+		opts = append([]axon.RequestOption{axon.WithTimeout(timeout)}, opts...)
+	}
+	req := axon.NewRequestPayload(topic, payload, opts...)
+	req.ServiceName = s.serviceName
+	req.ReplyPipe = axon.GenerateRandomString()
+	data, err := req.Compact()
+	if err != nil {
+		return err
+	}
+
+	l, err := s.log(requestsDir, topic)
+	if err != nil {
+		return err
+	}
+	inbox := filepath.Join(s.dir.path, inboxDir, req.ReplyPipe)
+	defer os.Remove(inbox)
+	if _, err := l.Append(data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout(defaultRequestTimeout))
+	defer cancel()
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		out, err := os.ReadFile(inbox)
+		if err == nil {
+			var reply axon.ReplyPayload
+			if err := json.Unmarshal(out, &reply); err != nil {
+				log.Print("failed to unmarshal reply event into reply struct with the following errors: ", err)
+				return err
+			}
+			req.SetReplyHeaders(reply.Headers)
+			if replyErr := reply.GetError(); replyErr != nil {
+				return replyErr
+			}
+			return json.Unmarshal(reply.GetPayload(), v)
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.closed:
+			return axon.ErrCloseConn
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *fileStore) Reply(topic string, handler axon.ReplyHandler) error {
+	return s.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+// ReplyContext answers the requests made on topic, load balanced across the repliers of the service, blocking
+// until the store is closed.
+func (s *fileStore) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	return s.consume(requestsDir, topic, &member{ackWait: s.ackWait, deliver: func(e *event) {
+		// Requests are acknowledged as they are read: by the time one would be redelivered its caller gave up.
+		e.Ack()
+
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			req, out, err := axon.ServeRequest(topic, e.Data(), handler)
+			if err != nil {
+				log.Print("failed to handle incoming request payload with the following error: ", err)
+				return
+			}
+			if err := s.writeReply(req.GetReplyAddress(), out); err != nil {
+				log.Print("failed to reply data to the incoming request with the following error: ", err)
+			}
+		}()
+	}})
+}
+
+// writeReply writes the inbox file through a rename, so the caller never reads it half written.
+func (s *fileStore) writeReply(replyPipe string, out []byte) error {
+	if replyPipe == "" || strings.ContainsAny(replyPipe, `/\`) {
+		return axon.ErrInvalidURL
+	}
+
+	dir := filepath.Join(s.dir.path, inboxDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".reply-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(out); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, replyPipe))
+}
+
+func (s *fileStore) Run(ctx context.Context, handlers ...axon.EventHandler) {
+	for _, handler := range handlers {
+		go handler.Run()
+	}
+
+	<-ctx.Done()
+}
+
+// Close stops the subscriptions of the store and waits for the replies in flight. The logs and offsets stay on disk
+// for the next run.
+func (s *fileStore) Close() error {
+	s.closeOnce.Do(func() {
+		s.SetState(axon.StateClosed)
+		close(s.closed)
+		s.running.Wait()
+	})
+	return nil
+}
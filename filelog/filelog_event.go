@@ -0,0 +1,49 @@
+package filelog
+
+import (
+	"github.com/Just4Ease/axon"
+	"sync"
+)
+
+// Event is the axon.Event delivered by the store, which also tells its position in the topic log.
+type Event interface {
+	axon.PositionedEvent
+
+	// Offset returns the sequence number of the message in its topic, to replay the topic from.
+	Offset() uint64
+}
+
+type event struct {
+	topic  string
+	offset uint64
+	data   []byte
+	once   sync.Once
+	ack    func() // Nil for replayed events, which are not acknowledged.
+	acked  chan struct{}
+}
+
+func (e *event) Ack() {
+	if e.ack == nil {
+		return
+	}
+	e.once.Do(func() {
+		e.ack()
+		close(e.acked)
+	})
+}
+
+func (e *event) Data() []byte {
+	return e.data
+}
+
+func (e *event) Topic() string {
+	return e.topic
+}
+
+func (e *event) Offset() uint64 {
+	return e.offset
+}
+
+func (e *event) Position() axon.Position {
+	return axon.AtSequence(e.offset)
+}
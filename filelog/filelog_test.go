@@ -0,0 +1,223 @@
+package filelog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newStore(t *testing.T, dir, serviceName string, options ...Option) axon.EventStore {
+	options = append([]Option{PollInterval(5 * time.Millisecond)}, options...)
+	store, err := Init(axon.Options{ServiceName: serviceName, Address: "file://" + dir}, options...)
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func receive(t *testing.T, events <-chan axon.Event) axon.Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+// subscribe runs Subscribe in the background and waits for the offset of the service to be persisted.
+func subscribe(t *testing.T, store axon.EventStore, dir, topic string, handler axon.SubscriptionHandler) {
+	go func() { _ = store.Subscribe(topic, handler) }()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "offsets", store.GetServiceName(), topicsDir, topic))
+		return err == nil
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	dir := t.TempDir()
+	publisher := newStore(t, dir, "publisher")
+	first, second := newStore(t, dir, "orders"), newStore(t, dir, "orders")
+	billing := newStore(t, dir, "billing")
+
+	var mu sync.Mutex
+	counts := map[string]int{}
+	done := make(chan struct{}, 20)
+	handler := func(name string) axon.SubscriptionHandler {
+		return func(e axon.Event) {
+			assert.Equal(t, "order.created", e.Topic())
+			mu.Lock()
+			counts[name]++
+			mu.Unlock()
+			e.Ack()
+			done <- struct{}{}
+		}
+	}
+	subscribe(t, first, dir, "order.created", handler("first"))
+	subscribe(t, second, dir, "order.created", handler("second"))
+	subscribe(t, billing, dir, "order.created", handler("billing"))
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, publisher.Publish("order.created", []byte("order")))
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 10, counts["first"]+counts["second"], "each message is delivered to one orders subscriber")
+	assert.Equal(t, 10, counts["billing"])
+}
+
+func TestRedeliveryAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	publisher := newStore(t, dir, "publisher")
+	subscriber := newStore(t, dir, "orders", AckWait(100*time.Millisecond))
+
+	events := make(chan axon.Event, 4)
+	subscribe(t, subscriber, dir, "order.created", func(e axon.Event) { events <- e })
+
+	require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+	first := receive(t, events)
+	redelivered := receive(t, events)
+	assert.Equal(t, first.Data(), redelivered.Data())
+	redelivered.Ack()
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(filepath.Join(dir, "offsets", "orders", topicsDir, "order.created"))
+		return string(data) == "1"
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// Messages published while the service is down wait in the log, and the next run resumes from the offset of
+	// the service, delivering again those the closed store read without acknowledging.
+	require.Nil(t, publisher.Publish("order.created", []byte("#2")))
+	e := receive(t, events)
+	assert.Equal(t, "#2", string(e.Data()))
+	require.Nil(t, subscriber.Close())
+	require.Nil(t, publisher.Publish("order.created", []byte("#3")))
+
+	resumed := newStore(t, dir, "orders")
+	go func() { _ = resumed.Subscribe("order.created", func(e axon.Event) { events <- e }) }()
+	received := map[uint64]string{}
+	for i := 0; i < 2; i++ {
+		e := receive(t, events)
+		received[e.(Event).Offset()] = string(e.Data())
+		e.Ack()
+	}
+	assert.Equal(t, map[uint64]string{1: "#2", 2: "#3"}, received)
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	store := newStore(t, dir, "orders", SegmentSize(32))
+	for _, msg := range []string{"#0", "#1", "#2", "#3", "#4"} {
+		require.Nil(t, store.Publish("order.created", []byte(msg)))
+	}
+	segments, err := filepath.Glob(filepath.Join(dir, topicsDir, "order.created", "*"+segmentExt))
+	require.Nil(t, err)
+	assert.Greater(t, len(segments), 1, "the log rolls over to new segments")
+
+	events := make(chan axon.Event, 8)
+	go func() {
+		_ = store.(axon.Replayer).Replay("order.created", axon.AtSequence(2), func(e axon.Event) { events <- e })
+	}()
+	for _, want := range []string{"#2", "#3", "#4"} {
+		assert.Equal(t, want, string(receive(t, events).Data()))
+	}
+
+	// Replay follows the messages published afterwards.
+	require.Nil(t, store.Publish("order.created", []byte("#5")))
+	e := receive(t, events)
+	assert.Equal(t, "#5", string(e.Data()))
+	assert.Equal(t, uint64(5), e.(Event).Offset())
+	assert.Equal(t, axon.AtSequence(5), e.(axon.PositionedEvent).Position())
+
+	// A replay from the latest position only follows the messages published once it started.
+	latest := make(chan axon.Event, 8)
+	go func() {
+		_ = store.(axon.Replayer).Replay("order.created", axon.Latest(), func(e axon.Event) { latest <- e })
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, store.Publish("order.created", []byte("#6")))
+	assert.Equal(t, "#6", string(receive(t, latest).Data()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, axon.Replay(ctx, store, "order.created", axon.AtSequence(6), func(axon.Event) {}))
+	assert.ErrorIs(t, axon.Replay(ctx, store, "order.created", axon.AtTime(time.Now()), func(axon.Event) {}), axon.ErrUnsupportedPosition)
+}
+
+func TestRequestReply(t *testing.T) {
+	dir := t.TempDir()
+	caller := newStore(t, dir, "caller")
+	replier := newStore(t, dir, "greeter")
+
+	go func() {
+		_ = replier.ReplyContext("greet", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			var in struct{ Name string }
+			if err := req.ParsePayload(&in); err != nil {
+				return axon.Response{}, err
+			}
+			if in.Name == "" {
+				return axon.Response{}, errors.New("name is required")
+			}
+			if in.Name == "sleepy" {
+				time.Sleep(500 * time.Millisecond)
+			}
+			out, _ := json.Marshal(map[string]string{"greeting": "hello " + in.Name, "from": req.ServiceName})
+			return axon.Response{Payload: out}, nil
+		})
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "offsets", "greeter", requestsDir, "greet"))
+		return err == nil
+	}, 2*time.Second, 5*time.Millisecond)
+
+	var out map[string]string
+	require.Nil(t, caller.Request("greet", []byte(`{"Name":"axon"}`), &out))
+	assert.Equal(t, map[string]string{"greeting": "hello axon", "from": "caller"}, out)
+
+	err := caller.Request("greet", []byte(`{}`), &out)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "name is required")
+
+	err = caller.Request("greet", []byte(`{"Name":"sleepy"}`), &out, axon.WithTimeout(100*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := axon.Open("file://"+dir, axon.Options{ServiceName: "orders"})
+	require.Nil(t, err)
+	assert.Nil(t, store.Health())
+	require.Nil(t, store.Close())
+	assert.Equal(t, axon.ErrCloseConn, store.Health())
+	assert.Equal(t, axon.ErrCloseConn, store.Publish("order.created", nil))
+
+	_, err = Init(axon.Options{ServiceName: "orders", Address: "redis://localhost"})
+	assert.Equal(t, axon.ErrInvalidURL, err)
+}
+
+func TestConformance(t *testing.T) {
+	dir := t.TempDir()
+	axontest.RunConformance(t, func(t *testing.T, opts axon.Options) axon.EventStore {
+		opts.Address = "file://" + dir
+		store, err := Init(opts, PollInterval(5*time.Millisecond))
+		require.Nil(t, err)
+		t.Cleanup(func() { _ = store.Close() })
+		return store
+	}, axontest.AckWait(200*time.Millisecond))
+}
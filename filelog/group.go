@@ -0,0 +1,354 @@
+package filelog
+
+import (
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxInFlight bounds the records a group delivers without them being acknowledged, so a long backlog is not read
+// into memory at once.
+const maxInFlight = 1024
+
+// offsetInterval is how long acknowledgements are gathered before the offset they lead to is saved. A crash loses
+// at most that much progress, delivering those records again.
+const offsetInterval = 100 * time.Millisecond
+
+var (
+	directoriesMu sync.Mutex
+	directories   = make(map[string]*directory)
+)
+
+// directory holds the logs and groups shared by the stores of a process opened on the same path.
+type directory struct {
+	path string
+
+	mu     sync.Mutex
+	logs   map[string]*topicLog
+	groups map[string]*group
+}
+
+func openDirectory(path string) (*directory, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, err
+	}
+
+	directoriesMu.Lock()
+	defer directoriesMu.Unlock()
+	d, ok := directories[abs]
+	if !ok {
+		d = &directory{path: abs, logs: make(map[string]*topicLog), groups: make(map[string]*group)}
+		directories[abs] = d
+	}
+	return d, nil
+}
+
+// log returns the log of topic in the kind directory, such as topics or requests. The segment and retention
+// options of the first store opening it apply. Requests are only read once, so the segments of request logs
+// are removed once every replying service read past them.
+func (d *directory) log(kind, topic string, segmentSize int64, syncWrites bool, retention time.Duration) (*topicLog, error) {
+	name := filepath.Join(kind, url.PathEscape(topic))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if l, ok := d.logs[name]; ok {
+		return l, nil
+	}
+	l, err := openLog(filepath.Join(d.path, name), segmentSize, syncWrites)
+	if err != nil {
+		return nil, err
+	}
+	l.retention = retention
+	l.trimConsumed = kind == requestsDir
+	l.offsets = filepath.Join(d.path, "offsets", "*", kind, globEscaper.Replace(url.PathEscape(topic)))
+	d.logs[name] = l
+	return l, nil
+}
+
+// globEscaper escapes the characters of a file name which filepath.Glob would take for a pattern.
+var globEscaper = strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]")
+
+// group returns the group of service reading l.
+func (d *directory) group(service, topic string, l *topicLog, pollInterval time.Duration) *group {
+	rel, _ := filepath.Rel(d.path, l.path)
+	offsetPath := filepath.Join(d.path, "offsets", url.PathEscape(service), rel)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.groups[offsetPath]
+	if !ok {
+		g = &group{
+			topic:        topic,
+			log:          l,
+			offsetPath:   offsetPath,
+			pollInterval: pollInterval,
+			space:        make(chan struct{}, 1),
+			acked:        make(chan struct{}, 1),
+		}
+		d.groups[offsetPath] = g
+	}
+	return g
+}
+
+// group is a service reading a log. Each record is delivered to one of its members and delivered again when it
+// is not acknowledged within the ack wait. The offset below which every record was acknowledged is persisted, so
+// the group resumes from it after a restart; records acknowledged past it are delivered again then.
+type group struct {
+	topic        string
+	log          *topicLog
+	offsetPath   string
+	pollInterval time.Duration
+
+	mu          sync.Mutex
+	members     []*member
+	next        int
+	stop        chan struct{} // Closed to stop the reader, nil while no member is running it.
+	read        uint64        // Sequence number of the next record to read.
+	committed   uint64
+	outstanding map[uint64]struct{}
+	space       chan struct{} // Signalled when a record is acknowledged.
+	acked       chan struct{} // Signalled when the committed offset moves, for the offset writer.
+	written     chan struct{} // Closed once the offset writer of the last reader saved its final offset.
+
+	offsetMu sync.Mutex // Guards unsaved apart from mu, so the offset writer never waits for the reader.
+	unsaved  uint64     // Committed offset for the offset writer to save.
+}
+
+type member struct {
+	ackWait time.Duration
+	deliver func(e *event)
+}
+
+// loadOffset returns the persisted offset of the group. A new group starts from the end of the log, and persists
+// it right away so the records appended while no member runs are kept for it.
+func (g *group) loadOffset() (uint64, error) {
+	data, err := os.ReadFile(g.offsetPath)
+	if err == nil {
+		return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	end, err := g.log.End()
+	if err != nil {
+		return 0, err
+	}
+	return end, g.saveOffset(end)
+}
+
+// saveOffset replaces the offset file through a rename, so it is never read half written.
+func (g *group) saveOffset(offset uint64) error {
+	if err := os.MkdirAll(filepath.Dir(g.offsetPath), 0755); err != nil {
+		return err
+	}
+	tmp := g.offsetPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(offset, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.offsetPath)
+}
+
+// join adds m to the group, starting the reader from the persisted offset when m is its first member.
+func (g *group) join(m *member) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stop == nil {
+		if g.written != nil {
+			<-g.written
+		}
+		offset, err := g.loadOffset()
+		if err != nil {
+			return err
+		}
+		g.stop = make(chan struct{})
+		g.written = make(chan struct{})
+		g.read, g.committed = offset, offset
+		g.outstanding = make(map[uint64]struct{})
+		g.offsetMu.Lock()
+		g.unsaved = offset
+		g.offsetMu.Unlock()
+		go g.run(g.stop, offset)
+		go g.writeOffsets(g.stop, g.written, offset)
+	}
+	g.members = append(g.members, m)
+	return nil
+}
+
+// leave removes m from the group, stopping the reader when m was its last member. Records delivered and not yet
+// acknowledged are read again by the next member to join.
+func (g *group) leave(m *member) {
+	g.mu.Lock()
+	for i, existing := range g.members {
+		if existing == m {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	var written chan struct{}
+	if len(g.members) == 0 && g.stop != nil {
+		close(g.stop)
+		g.stop, written = nil, g.written
+	}
+	g.mu.Unlock()
+
+	if written != nil {
+		<-written // The offset is on disk once the store is closed.
+	}
+}
+
+// writeOffsets saves the committed offset at most every offsetInterval, until stop is closed and the last one is
+// saved.
+func (g *group) writeOffsets(stop, written chan struct{}, saved uint64) {
+	defer close(written)
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-g.acked:
+			select {
+			case <-stop:
+				stopped = true
+			case <-time.After(offsetInterval):
+			}
+		}
+
+		g.offsetMu.Lock()
+		offset := g.unsaved
+		g.offsetMu.Unlock()
+		if offset != saved {
+			if err := g.saveOffset(offset); err != nil {
+				log.Print("failed to save the offset of the file log with the following error: ", err)
+			} else {
+				saved = offset
+			}
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// run reads the log from offset and delivers its records, polling for new ones, until stop is closed.
+func (g *group) run(stop chan struct{}, offset uint64) {
+	r := g.log.newReader(offset)
+	defer r.Close()
+	for {
+		if !g.waitForSpace(stop) {
+			return
+		}
+
+		seq, data, err := r.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Print("failed to read from the file log with the following error: ", err)
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(g.pollInterval):
+			}
+			continue
+		}
+
+		g.mu.Lock()
+		if g.stop != stop {
+			g.mu.Unlock()
+			return
+		}
+		g.read = seq + 1
+		g.outstanding[seq] = struct{}{}
+		g.mu.Unlock()
+		g.send(stop, seq, data)
+	}
+}
+
+func (g *group) waitForSpace(stop chan struct{}) bool {
+	for {
+		g.mu.Lock()
+		full := len(g.outstanding) >= maxInFlight
+		g.mu.Unlock()
+		if !full {
+			return true
+		}
+		select {
+		case <-stop:
+			return false
+		case <-g.space:
+		}
+	}
+}
+
+// send delivers the record to the next member in round robin order, and again when it is not acknowledged in time.
+func (g *group) send(stop chan struct{}, seq uint64, data []byte) {
+	g.mu.Lock()
+	if _, ok := g.outstanding[seq]; !ok || g.stop != stop {
+		g.mu.Unlock()
+		return
+	}
+	m := g.members[g.next%len(g.members)]
+	g.next++
+	g.mu.Unlock()
+
+	e := &event{
+		topic:  g.topic,
+		offset: seq,
+		data:   data,
+		ack:    func() { g.ack(stop, seq) },
+		acked:  make(chan struct{}),
+	}
+	go func() {
+		timer := time.NewTimer(m.ackWait)
+		defer timer.Stop()
+		select {
+		case <-e.acked:
+		case <-timer.C:
+			g.send(stop, seq, data)
+		case <-stop:
+		}
+	}()
+	m.deliver(e)
+}
+
+// ack marks the record acknowledged and has the offset of the oldest record still outstanding saved.
+func (g *group) ack(stop chan struct{}, seq uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stop != stop {
+		return
+	}
+	delete(g.outstanding, seq)
+
+	committed := g.read
+	for outstanding := range g.outstanding {
+		if outstanding < committed {
+			committed = outstanding
+		}
+	}
+	if committed != g.committed {
+		g.committed = committed
+		g.offsetMu.Lock()
+		g.unsaved = committed
+		g.offsetMu.Unlock()
+		select {
+		case g.acked <- struct{}{}:
+		default:
+		}
+	}
+
+	select {
+	case g.space <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package filelog
+
+// lockFile is a no-op where flock is unavailable: a single process may append to a directory at a time.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
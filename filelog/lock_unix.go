@@ -0,0 +1,24 @@
+//go:build unix
+
+package filelog
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on path, shared with the other processes appending to the same log.
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
@@ -0,0 +1,345 @@
+package filelog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSegmentSize = 64 << 20
+	headerSize         = 8 // Record length and CRC-32 checksum.
+	segmentExt         = ".log"
+	lockName           = ".lock"
+)
+
+var ErrCorruptLog = errors.New("Sorry, the log segment is corrupt")
+
+// topicLog is an append-only log stored as segment files named after the sequence number of their first record.
+// Processes sharing the directory append under a file lock and read by polling the segments.
+//
+// Old segments are removed whenever a new one is started: those older than the retention, and when trimConsumed
+// is set, those every group read past, as found in the offset files matching offsets.
+type topicLog struct {
+	path         string
+	segmentSize  int64
+	syncWrites   bool
+	retention    time.Duration
+	trimConsumed bool
+	offsets      string // Glob pattern of the offset files of the groups reading the log.
+
+	mu       sync.Mutex
+	lastBase uint64 // Tail of the last segment as of the last append, refreshed when another process appended.
+	count    uint64
+	size     int64
+}
+
+func openLog(path string, segmentSize int64, syncWrites bool) (*topicLog, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &topicLog{path: path, segmentSize: segmentSize, syncWrites: syncWrites}, nil
+}
+
+// trim removes the oldest segments past the retention or read by every group, keeping the last one. The caller
+// holds l.mu and the file lock.
+func (l *topicLog) trim() error {
+	bases, err := l.segments()
+	if err != nil || len(bases) < 2 {
+		return err
+	}
+	consumed, ok := uint64(0), false
+	if l.trimConsumed {
+		if consumed, ok, err = l.consumed(); err != nil {
+			return err
+		}
+	}
+	var cutoff time.Time
+	if l.retention > 0 {
+		cutoff = time.Now().Add(-l.retention)
+	}
+
+	for i, base := range bases[:len(bases)-1] {
+		expired := false
+		if !cutoff.IsZero() {
+			info, err := os.Stat(l.segmentPath(base))
+			if err != nil {
+				return err
+			}
+			expired = info.ModTime().Before(cutoff)
+		}
+		if !expired && !(ok && bases[i+1] <= consumed) {
+			return nil
+		}
+		if err := os.Remove(l.segmentPath(base)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// consumed returns the lowest offset persisted by the groups reading the log, or false when none has one.
+func (l *topicLog) consumed() (uint64, bool, error) {
+	paths, err := filepath.Glob(l.offsets)
+	if err != nil {
+		return 0, false, err
+	}
+	var lowest uint64
+	found := false
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		offset, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "the offset in %s is corrupt", path)
+		}
+		if !found || offset < lowest {
+			lowest, found = offset, true
+		}
+	}
+	return lowest, found, nil
+}
+
+func (l *topicLog) segmentPath(base uint64) string {
+	return filepath.Join(l.path, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+// segments returns the base sequence numbers of the segments in ascending order.
+func (l *topicLog) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(l.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bases []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err == nil {
+			bases = append(bases, base)
+		}
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	return bases, nil
+}
+
+// Append writes data as the next record and returns its sequence number.
+func (l *topicLog) Append(data []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	unlock, err := lockFile(filepath.Join(l.path, lockName))
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err := l.refreshTail(); err != nil {
+		return 0, err
+	}
+
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[headerSize:], data)
+
+	if l.size > 0 && l.size+int64(len(record)) > l.segmentSize {
+		l.lastBase, l.count, l.size = l.lastBase+l.count, 0, 0
+		defer func() {
+			if err := l.trim(); err != nil {
+				log.Print("failed to remove old segments of the file log with the following error: ", err)
+			}
+		}()
+	}
+
+	f, err := os.OpenFile(l.segmentPath(l.lastBase), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Write(record); err != nil {
+		return 0, err
+	}
+	if l.syncWrites {
+		if err := f.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := l.lastBase + l.count
+	l.count++
+	l.size += int64(len(record))
+	return seq, nil
+}
+
+// End returns the sequence number the next record appended will get.
+func (l *topicLog) End() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	unlock, err := lockFile(filepath.Join(l.path, lockName))
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err := l.refreshTail(); err != nil {
+		return 0, err
+	}
+	return l.lastBase + l.count, nil
+}
+
+// refreshTail brings the cached tail up to date with the last segment, which other processes may have appended
+// to. A record left incomplete by a crashed writer is truncated.
+func (l *topicLog) refreshTail() error {
+	bases, err := l.segments()
+	if err != nil || len(bases) == 0 {
+		return err
+	}
+
+	last := bases[len(bases)-1]
+	if last != l.lastBase {
+		l.lastBase, l.count, l.size = last, 0, 0
+	}
+	f, err := os.OpenFile(l.segmentPath(last), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		_, n, err := readRecord(f, l.size)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return f.Truncate(l.size)
+		}
+		if err != nil {
+			return err
+		}
+		l.count++
+		l.size += n
+	}
+	return nil
+}
+
+// readRecord reads the record at pos and returns its data and size. It returns io.EOF at the end of the file and
+// io.ErrUnexpectedEOF when the record is still being written.
+func readRecord(f *os.File, pos int64) ([]byte, int64, error) {
+	header := make([]byte, headerSize)
+	n, err := f.ReadAt(header, pos)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if n < headerSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if n, _ := f.ReadAt(data, pos+headerSize); n < len(data) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.Wrapf(ErrCorruptLog, "%s at byte %d", f.Name(), pos)
+	}
+	return data, headerSize + int64(len(data)), nil
+}
+
+// logReader reads a log from a sequence number onwards.
+type logReader struct {
+	log  *topicLog
+	next uint64 // Sequence number of the next record.
+	base uint64 // Base of the open segment.
+	file *os.File
+	pos  int64
+}
+
+func (l *topicLog) newReader(from uint64) *logReader {
+	return &logReader{log: l, next: from}
+}
+
+// Next returns the next record, or io.EOF once the reader caught up with the log.
+func (r *logReader) Next() (uint64, []byte, error) {
+	for {
+		if r.file == nil {
+			if err := r.open(); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		data, n, err := readRecord(r.file, r.pos)
+		if err == nil {
+			r.pos += n
+			seq := r.next
+			r.next++
+			return seq, data, nil
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, nil, err
+		}
+
+		// At the end of the segment, move on when the writer started the next one.
+		if _, statErr := os.Stat(r.log.segmentPath(r.next)); err == io.EOF && r.next != r.base && statErr == nil {
+			r.Close()
+			continue
+		}
+		return 0, nil, io.EOF
+	}
+}
+
+// open opens the segment holding the next record, skipping the records before it.
+func (r *logReader) open() error {
+	bases, err := r.log.segments()
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		return io.EOF
+	}
+	if r.next < bases[0] {
+		r.next = bases[0] // Older segments were removed.
+	}
+
+	i := sort.Search(len(bases), func(i int) bool { return bases[i] > r.next }) - 1
+	f, err := os.Open(r.log.segmentPath(bases[i]))
+	if err != nil {
+		return err
+	}
+	r.file, r.base, r.pos = f, bases[i], 0
+
+	for seq := r.base; seq < r.next; seq++ {
+		_, n, err := readRecord(f, r.pos)
+		if err != nil {
+			r.next = seq // The log ends before the requested record.
+			break
+		}
+		r.pos += n
+	}
+	return nil
+}
+
+func (r *logReader) Close() {
+	if r.file != nil {
+		_ = r.file.Close()
+		r.file = nil
+	}
+}
@@ -0,0 +1,112 @@
+package filelog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogRecoversTornWrite(t *testing.T) {
+	l, err := openLog(t.TempDir(), defaultSegmentSize, false)
+	require.Nil(t, err)
+	seq, err := l.Append([]byte("#0"))
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0), seq)
+
+	// A writer crashed halfway through the header of the next record.
+	f, err := os.OpenFile(l.segmentPath(0), os.O_WRONLY|os.O_APPEND, 0644)
+	require.Nil(t, err)
+	_, err = f.Write([]byte{0, 0})
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	r := l.newReader(0)
+	defer r.Close()
+	_, data, err := r.Next()
+	require.Nil(t, err)
+	assert.Equal(t, "#0", string(data))
+	_, _, err = r.Next()
+	assert.Equal(t, io.EOF, err, "an incomplete record is not read")
+
+	fresh, err := openLog(l.path, defaultSegmentSize, false)
+	require.Nil(t, err)
+	seq, err = fresh.Append([]byte("#1"))
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), seq, "the torn record is truncated before appending")
+	seq, data, err = r.Next()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), seq)
+	assert.Equal(t, "#1", string(data))
+}
+
+func TestLogDetectsCorruption(t *testing.T) {
+	l, err := openLog(t.TempDir(), defaultSegmentSize, false)
+	require.Nil(t, err)
+	_, err = l.Append([]byte("#0"))
+	require.Nil(t, err)
+
+	f, err := os.OpenFile(l.segmentPath(0), os.O_WRONLY, 0644)
+	require.Nil(t, err)
+	_, err = f.WriteAt([]byte("x"), headerSize)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	r := l.newReader(0)
+	defer r.Close()
+	_, _, err = r.Next()
+	assert.ErrorIs(t, err, ErrCorruptLog)
+}
+
+func TestLogTrim(t *testing.T) {
+	dir := t.TempDir()
+	d, err := openDirectory(dir)
+	require.Nil(t, err)
+	// Segments of a single 10 byte record each.
+	requests, err := d.log(requestsDir, "user.get", 1, false, 0)
+	require.Nil(t, err)
+	offsets := func(service string, offset string) {
+		path := filepath.Join(dir, "offsets", service, requestsDir, "user.get")
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(t, os.WriteFile(path, []byte(offset), 0644))
+	}
+	segments := func(l *topicLog) []uint64 {
+		bases, err := l.segments()
+		require.Nil(t, err)
+		return bases
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := requests.Append([]byte("#0"))
+		require.Nil(t, err)
+	}
+	assert.Equal(t, []uint64{0, 1, 2}, segments(requests), "no service read the requests yet")
+
+	offsets("users", "2")
+	offsets("audit", "1")
+	_, err = requests.Append([]byte("#3"))
+	require.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, segments(requests), "segments are kept until every service read them")
+
+	// Topic logs are kept whole, unless they have a retention.
+	topic, err := d.log(topicsDir, "order.created", 1, false, time.Hour)
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := topic.Append([]byte("#0"))
+		require.Nil(t, err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	require.Nil(t, os.Chtimes(topic.segmentPath(0), old, old))
+	_, err = topic.Append([]byte("#3"))
+	require.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, segments(topic))
+
+	r := topic.newReader(0)
+	defer r.Close()
+	seq, _, err := r.Next()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), seq, "readers skip the removed segments")
+}
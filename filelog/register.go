@@ -0,0 +1,12 @@
+package filelog
+
+import (
+	"github.com/Just4Ease/axon"
+	"net/url"
+)
+
+func init() {
+	axon.Register("file", func(u *url.URL, opts axon.Options) (axon.EventStore, error) {
+		return Init(opts)
+	})
+}
@@ -0,0 +1,43 @@
+package axon
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type healthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// LivenessHandler answers liveness probes. It only fails once the store is closed, as a reconnecting store
+// can still recover without restarting the process.
+func LivenessHandler(store EventStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := store.Health()
+		if err == ErrCloseConn {
+			writeHealth(w, err)
+			return
+		}
+		writeHealth(w, nil)
+	})
+}
+
+// ReadinessHandler answers readiness probes. It fails whenever the store is not connected to its broker.
+func ReadinessHandler(store EventStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, store.Health())
+	})
+}
+
+func writeHealth(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	status := healthStatus{Status: "ok"}
+	code := http.StatusOK
+	if err != nil {
+		status = healthStatus{Status: "unavailable", Error: err.Error()}
+		code = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
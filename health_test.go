@@ -0,0 +1,54 @@
+package axon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type trackedStore struct {
+	EventStore
+	tracker *StateTracker
+}
+
+func (s *trackedStore) Health() error {
+	return s.tracker.Health()
+}
+
+func TestStateTracker(t *testing.T) {
+	tracker := NewStateTracker(StateConnected)
+	var seen []State
+	tracker.OnStateChange(func(state State) { seen = append(seen, state) })
+
+	tracker.SetState(StateConnected)
+	tracker.SetState(StateReconnecting)
+	assert.Equal(t, ErrReconnecting, tracker.Health())
+	tracker.SetState(StateConnected)
+	assert.Nil(t, tracker.Health())
+	tracker.SetState(StateClosed)
+	tracker.SetState(StateConnected)
+
+	assert.Equal(t, ErrCloseConn, tracker.Health())
+	assert.Equal(t, []State{StateReconnecting, StateConnected, StateClosed}, seen)
+}
+
+func TestHealthHandlers(t *testing.T) {
+	tracker := NewStateTracker(StateConnected)
+	store := &trackedStore{tracker: tracker}
+	probe := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, probe(LivenessHandler(store)))
+	assert.Equal(t, http.StatusOK, probe(ReadinessHandler(store)))
+
+	tracker.SetState(StateDisconnected)
+	assert.Equal(t, http.StatusOK, probe(LivenessHandler(store)))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(ReadinessHandler(store)))
+
+	tracker.SetState(StateClosed)
+	assert.Equal(t, http.StatusServiceUnavailable, probe(LivenessHandler(store)))
+}
@@ -0,0 +1,96 @@
+// Package natsconn builds the connection options shared by the backends running on NATS.
+package natsconn
+
+import (
+	"fmt"
+	"github.com/Just4Ease/axon"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// Options builds the authentication and TLS options of the NATS connection from opts.
+func Options(opts axon.Options) ([]nats.Option, error) {
+	var options []nats.Option
+	if opts.AuthenticationToken != "" {
+		options = append(options, nats.Token(opts.AuthenticationToken))
+	}
+
+	if auth := opts.Auth; auth != nil {
+		if auth.Username != "" {
+			options = append(options, nats.UserInfo(auth.Username, auth.Password))
+		}
+		if auth.CredentialsFile != "" {
+			options = append(options, nats.UserCredentials(auth.CredentialsFile))
+		}
+		if handler := tokenHandler(auth); handler != nil {
+			// NATS refuses a token along with a token handler when connecting, with a less helpful error.
+			if opts.AuthenticationToken != "" {
+				return nil, &axon.FieldError{Field: "token", Err: errors.Wrap(axon.ErrInvalidValue, "a token file or supplier is set too")}
+			}
+			options = append(options, nats.TokenHandler(handler))
+		}
+		if auth.NKeySeedFile != "" {
+			nkey, err := nats.NkeyOptionFromSeed(auth.NKeySeedFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load nkey seed file. failed with error: %v", err)
+			}
+			options = append(options, nkey)
+		}
+	}
+
+	if t := opts.ResolvedTLS(); t != nil {
+		config, err := t.Config()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, nats.Secure(config))
+	}
+	return options, nil
+}
+
+// tokenHandler returns a handler reading the token on every (re)connection, or nil when none is configured.
+func tokenHandler(auth *axon.AuthOptions) nats.AuthTokenHandler {
+	supplier := auth.TokenSupplier
+	if supplier == nil && auth.TokenFile != "" {
+		supplier = func() (string, error) {
+			data, err := ioutil.ReadFile(auth.TokenFile)
+			return strings.TrimSpace(string(data)), err
+		}
+	}
+	if supplier == nil {
+		return nil
+	}
+
+	return func() string {
+		token, err := supplier()
+		if err != nil {
+			log.Print("failed to load the nats authentication token with the following error: ", err)
+		}
+		return token
+	}
+}
+
+// TrackState returns options driving tracker from the connection events. healthy, when set, reports whether a
+// reconnected connection is usable again, for clients layered on NATS that do not recover with it.
+func TrackState(tracker *axon.StateTracker, healthy func() bool) []nats.Option {
+	return []nats.Option{
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			if conn.Opts.AllowReconnect {
+				tracker.SetState(axon.StateReconnecting)
+				return
+			}
+			tracker.SetState(axon.StateDisconnected)
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			if healthy == nil || healthy() {
+				tracker.SetState(axon.StateConnected)
+			}
+		}),
+		nats.ClosedHandler(func(conn *nats.Conn) {
+			tracker.SetState(axon.StateClosed)
+		}),
+	}
+}
@@ -0,0 +1,248 @@
+package natsconn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/Just4Ease/axon"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+func (c testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	pair, err := tls.X509KeyPair([]byte(c.certPEM), []byte(c.keyPEM))
+	require.Nil(t, err)
+	return pair
+}
+
+// issueCert creates a certificate signed by parent, or a self-signed CA when parent is nil.
+func issueCert(t *testing.T, parent *testCert, template *x509.Certificate) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.Nil(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	return testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+type testPKI struct {
+	ca, server, client testCert
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	ca := issueCert(t, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "axon test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	})
+	srv := issueCert(t, &ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	client := issueCert(t, &ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "axon client"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return testPKI{ca: ca, server: srv, client: client}
+}
+
+func runTestServer(t *testing.T, opts *server.Options) *server.Server {
+	opts.Host = "127.0.0.1"
+	opts.Port = -1
+	opts.NoLog = true
+	opts.NoSigs = true
+	s, err := server.NewServer(opts)
+	require.Nil(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second), "nats server did not start")
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func connect(t *testing.T, s *server.Server, opts axon.Options) (*nats.Conn, error) {
+	options, err := Options(opts)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := nats.Connect(s.ClientURL(), append(options, nats.MaxReconnects(0))...)
+	if err == nil {
+		t.Cleanup(nc.Close)
+	}
+	return nc, err
+}
+
+func tlsServerOptions(t *testing.T, pki testPKI, verifyClients bool) *server.Options {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{pki.server.tlsCertificate(t)},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if verifyClients {
+		pool := x509.NewCertPool()
+		pool.AddCert(pki.ca.cert)
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &server.Options{TLS: true, TLSVerify: verifyClients, TLSConfig: config, TLSTimeout: 2}
+}
+
+func TestOptions_TokenAuth(t *testing.T) {
+	s := runTestServer(t, &server.Options{Authorization: "s3cr3t"})
+
+	_, err := connect(t, s, axon.Options{AuthenticationToken: "wrong"})
+	assert.NotNil(t, err)
+
+	nc, err := connect(t, s, axon.Options{AuthenticationToken: "s3cr3t"})
+	assert.Nil(t, err)
+	assert.True(t, nc.IsConnected())
+}
+
+func TestOptions_UserPassword(t *testing.T) {
+	s := runTestServer(t, &server.Options{Username: "axon", Password: "pa55"})
+
+	_, err := connect(t, s, axon.Options{Auth: &axon.AuthOptions{Username: "axon", Password: "nope"}})
+	assert.NotNil(t, err)
+
+	nc, err := connect(t, s, axon.Options{Auth: &axon.AuthOptions{Username: "axon", Password: "pa55"}})
+	assert.Nil(t, err)
+	assert.True(t, nc.IsConnected())
+}
+
+func TestOptions_NKeySeedFile(t *testing.T) {
+	user, err := nkeys.CreateUser()
+	require.Nil(t, err)
+	pub, err := user.PublicKey()
+	require.Nil(t, err)
+	seed, err := user.Seed()
+	require.Nil(t, err)
+
+	seedFile := filepath.Join(t.TempDir(), "user.nk")
+	require.Nil(t, ioutil.WriteFile(seedFile, seed, 0600))
+
+	s := runTestServer(t, &server.Options{Nkeys: []*server.NkeyUser{{Nkey: pub}}})
+	nc, err := connect(t, s, axon.Options{Auth: &axon.AuthOptions{NKeySeedFile: seedFile}})
+	assert.Nil(t, err)
+	assert.True(t, nc.IsConnected())
+
+	_, err = Options(axon.Options{Auth: &axon.AuthOptions{NKeySeedFile: filepath.Join(t.TempDir(), "missing.nk")}})
+	assert.NotNil(t, err)
+}
+
+func TestOptions_TLS(t *testing.T) {
+	pki := newTestPKI(t)
+	s := runTestServer(t, tlsServerOptions(t, pki, false))
+
+	_, err := connect(t, s, axon.Options{TLS: &axon.TLSOptions{}})
+	assert.NotNil(t, err, "the test CA must not be trusted by default")
+
+	nc, err := connect(t, s, axon.Options{TLS: &axon.TLSOptions{CACertContent: pki.ca.certPEM}})
+	assert.Nil(t, err)
+	assert.True(t, nc.IsConnected())
+
+	// The legacy CertContent option is honoured as the trusted CA.
+	nc, err = connect(t, s, axon.Options{CertContent: pki.ca.certPEM})
+	assert.Nil(t, err)
+	assert.True(t, nc.IsConnected())
+
+	_, err = connect(t, s, axon.Options{TLS: &axon.TLSOptions{CACertContent: "not a certificate"}})
+	assert.NotNil(t, err)
+}
+
+func TestOptions_MutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	s := runTestServer(t, tlsServerOptions(t, pki, true))
+
+	_, err := connect(t, s, axon.Options{TLS: &axon.TLSOptions{CACertContent: pki.ca.certPEM}})
+	assert.NotNil(t, err, "connecting without a client certificate must fail")
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	require.Nil(t, ioutil.WriteFile(certFile, []byte(pki.client.certPEM), 0600))
+	require.Nil(t, ioutil.WriteFile(keyFile, []byte(pki.client.keyPEM), 0600))
+
+	nc, err := connect(t, s, axon.Options{TLS: &axon.TLSOptions{
+		CACertContent:  pki.ca.certPEM,
+		ClientCertFile: certFile,
+		ClientKeyFile:  keyFile,
+	}})
+	assert.Nil(t, err)
+	assert.True(t, nc.IsConnected())
+
+	nc, err = connect(t, s, axon.Options{TLS: &axon.TLSOptions{
+		CACertContent:     pki.ca.certPEM,
+		ClientCertContent: pki.client.certPEM,
+		ClientKeyContent:  pki.client.keyPEM,
+	}})
+	assert.Nil(t, err)
+	assert.True(t, nc.IsConnected())
+}
+
+func TestOptions_TokenFileIsReadOnConnect(t *testing.T) {
+	s := runTestServer(t, &server.Options{Authorization: "rotated"})
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.Nil(t, ioutil.WriteFile(tokenFile, []byte("stale\n"), 0600))
+
+	opts := axon.Options{Auth: &axon.AuthOptions{TokenFile: tokenFile}}
+	_, err := connect(t, s, opts)
+	assert.NotNil(t, err)
+
+	require.Nil(t, ioutil.WriteFile(tokenFile, []byte("rotated\n"), 0600))
+	nc, err := connect(t, s, opts)
+	assert.Nil(t, err)
+	assert.True(t, nc.IsConnected())
+}
+
+func TestOptions_TokenAndTokenFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.Nil(t, ioutil.WriteFile(tokenFile, []byte("rotated\n"), 0600))
+
+	_, err := Options(axon.Options{AuthenticationToken: "secret", Auth: &axon.AuthOptions{TokenFile: tokenFile}})
+	var fieldErr *axon.FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "token", fieldErr.Field)
+	assert.ErrorIs(t, err, axon.ErrInvalidValue)
+}
@@ -0,0 +1,64 @@
+// Package payload helps the store decorators transforming the payloads of the events they receive.
+package payload
+
+import (
+	"github.com/Just4Ease/axon"
+	"log"
+)
+
+type event struct {
+	axon.Event
+	data []byte
+}
+
+func (e *event) Data() []byte {
+	return e.data
+}
+
+// positionedEvent keeps the position of an event delivered by a replay.
+type positionedEvent struct {
+	*event
+	positioned axon.PositionedEvent
+}
+
+func (e *positionedEvent) Position() axon.Position {
+	return e.positioned.Position()
+}
+
+// Replace returns e carrying data instead of its payload, still an axon.PositionedEvent when e is one.
+func Replace(e axon.Event, data []byte) axon.Event {
+	d := &event{Event: e, data: data}
+	if p, ok := e.(axon.PositionedEvent); ok {
+		return &positionedEvent{event: d, positioned: p}
+	}
+	return d
+}
+
+// Handler passes the events of topic to handler with their payload transformed by decode. Events failing with an
+// error permanent tells never will decode, so they are logged and acknowledged. Other errors, such as a key
+// provider missing a key not rotated in yet, leave the event unacknowledged for the broker to deliver it again.
+func Handler(topic string, decode func([]byte) ([]byte, error), permanent func(error) bool, handler axon.SubscriptionHandler) axon.SubscriptionHandler {
+	return func(e axon.Event) {
+		data, err := decode(e.Data())
+		if err != nil && permanent(err) {
+			log.Printf("dropped a message of %s which failed to decode with the following error: %v", topic, err)
+			e.Ack()
+			return
+		}
+		if err != nil {
+			log.Printf("failed to decode a message of %s, leaving it for redelivery, with the following error: %v", topic, err)
+			return
+		}
+		handler(Replace(e, data))
+	}
+}
+
+// WithHeader returns a copy of headers with key set to value, leaving the map of the handler untouched.
+func WithHeader(headers map[string]string, key, value string) map[string]string {
+	out := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
@@ -0,0 +1,330 @@
+// Package jet implements axon.EventStore on NATS JetStream, the persistence layer replacing NATS Streaming.
+//
+// Each topic is stored in the stream whose subjects cover it, and a stream named after the topic is created when
+// none does. Subscriptions are durable consumers named after the service and topic, shared by every instance of
+// the service, and deliver each message until it is acknowledged. Request and Reply go over core NATS.
+package jet
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/internal/natsconn"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRequestTimeout = 5 * time.Second
+	pullWait              = time.Second
+)
+
+// HeaderPublisher is implemented by stores able to publish message headers along with the payload.
+type HeaderPublisher interface {
+	PublishWithHeaders(topic string, message []byte, headers nats.Header) error
+}
+
+type Option func(*jetStore)
+
+// Storage sets where the streams created by the store keep their messages. It defaults to nats.FileStorage.
+func Storage(storage nats.StorageType) Option {
+	return func(s *jetStore) {
+		s.storage = storage
+	}
+}
+
+// PullConsumers makes subscriptions fetch up to batch messages at a time from pull consumers instead of having
+// the server push messages to them.
+func PullConsumers(batch int) Option {
+	return func(s *jetStore) {
+		s.pullBatch = batch
+	}
+}
+
+type jetStore struct {
+	*axon.StateTracker
+	natsClient  *nats.Conn
+	js          nats.JetStreamContext
+	serviceName string
+	opts        axon.Options
+	storage     nats.StorageType
+	pullBatch   int
+	streams     sync.Map // Topics known to be covered by a stream.
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+// Init connects to the NATS server at opts.Address, which must have JetStream enabled.
+func Init(opts axon.Options, options ...Option) (axon.EventStore, error) {
+	addr := strings.TrimSpace(opts.Address)
+	if addr == "" {
+		return nil, axon.ErrInvalidURL
+	}
+
+	name := strings.TrimSpace(opts.ServiceName)
+	if name == "" {
+		return nil, axon.ErrEmptyStoreName
+	}
+
+	connOptions, err := natsconn.Options(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := axon.NewStateTracker(axon.StateConnected)
+	connOptions = append(connOptions, nats.Name(name))
+	connOptions = append(connOptions, natsconn.TrackState(tracker, nil)...)
+	nc, err := nats.Connect(addr, connOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, errors.Wrap(err, "unable to use JetStream on the NATS connection")
+	}
+
+	s := &jetStore{
+		StateTracker: tracker,
+		natsClient:   nc,
+		js:           js,
+		serviceName:  name,
+		opts:         opts,
+		storage:      nats.FileStorage,
+		closed:       make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s, nil
+}
+
+func (s *jetStore) GetServiceName() string {
+	return s.serviceName
+}
+
+func (s *jetStore) Publish(topic string, message []byte) error {
+	return s.PublishWithHeaders(topic, message, nil)
+}
+
+// PublishWithHeaders publishes message to topic along with headers, which handlers read from Event.Headers.
+func (s *jetStore) PublishWithHeaders(topic string, message []byte, headers nats.Header) error {
+	if err := s.ensureStream(topic); err != nil {
+		return err
+	}
+
+	_, err := s.js.PublishMsg(&nats.Msg{Subject: topic, Data: message, Header: headers})
+	return err
+}
+
+// ensureStream creates a stream for topic unless one already covers it.
+func (s *jetStore) ensureStream(topic string) error {
+	if _, ok := s.streams.Load(topic); ok {
+		return nil
+	}
+
+	_, err := s.js.StreamNameBySubject(topic)
+	if errors.Is(err, nats.ErrNoMatchingStream) {
+		_, err = s.js.AddStream(&nats.StreamConfig{
+			Name:     sanitize(topic),
+			Subjects: []string{topic},
+			Storage:  s.storage,
+		})
+		if errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+			err = nil // Created concurrently by another instance.
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to find or create a stream for %s", topic)
+	}
+
+	s.streams.Store(topic, true)
+	return nil
+}
+
+// Subscribe delivers the messages of topic to handler through the durable consumer of the service, blocking
+// until the store is closed. Handlers receive an Event they may Nak or Term instead of acknowledging.
+func (s *jetStore) Subscribe(topic string, handler axon.SubscriptionHandler) error {
+	if err := s.ensureStream(topic); err != nil {
+		return err
+	}
+
+	topicOpts := s.opts.Topic(topic)
+	limiter := axon.NewLimiter(topicOpts.Concurrency)
+	durable := sanitize(s.serviceName + "_" + topic)
+	subOpts := []nats.SubOpt{nats.ManualAck(), nats.AckExplicit(), nats.DeliverNew()}
+	if topicOpts.AckWait > 0 {
+		subOpts = append(subOpts, nats.AckWait(topicOpts.AckWait))
+	}
+	if topicOpts.Concurrency > 0 {
+		subOpts = append(subOpts, nats.MaxAckPending(topicOpts.Concurrency))
+	}
+
+	if s.pullBatch > 0 {
+		return s.pull(topic, durable, limiter, handler, subOpts)
+	}
+
+	// The subscription is not unsubscribed on return, as that would delete the durable consumer.
+	_, err := s.js.QueueSubscribe(topic, durable, func(msg *nats.Msg) {
+		limiter.Go(handler, newEvent(msg))
+	}, append(subOpts, nats.Durable(durable))...)
+	if err != nil {
+		return err
+	}
+	<-s.closed
+	return axon.ErrCloseConn
+}
+
+func (s *jetStore) pull(topic, durable string, limiter *axon.Limiter, handler axon.SubscriptionHandler, subOpts []nats.SubOpt) error {
+	sub, err := s.js.PullSubscribe(topic, durable, subOpts...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		msgs, err := sub.Fetch(s.pullBatch, nats.MaxWait(pullWait))
+		select {
+		case <-s.closed:
+			return axon.ErrCloseConn
+		default:
+		}
+		if err != nil && !errors.Is(err, nats.ErrTimeout) {
+			log.Print("failed to fetch messages from the pull consumer with the following error: ", err)
+			time.Sleep(pullWait)
+			continue
+		}
+		for _, msg := range msgs {
+			limiter.Go(handler, newEvent(msg))
+		}
+	}
+}
+
+// Replay reads topic again with an ephemeral ordered consumer, from axon.Earliest, the stream sequence of
+// axon.AtSequence, axon.AtTime or axon.Latest, and follows the messages published afterwards until the store is
+// closed. The durable consumers of the service are unaffected.
+func (s *jetStore) Replay(topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	return s.ReplayContext(context.Background(), topic, from, handler)
+}
+
+func (s *jetStore) ReplayContext(ctx context.Context, topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	var start nats.SubOpt
+	switch from.Kind {
+	case axon.PositionEarliest:
+		start = nats.DeliverAll()
+	case axon.PositionSequence:
+		start = nats.StartSequence(from.Sequence)
+	case axon.PositionTime:
+		start = nats.StartTime(from.Time)
+	case axon.PositionLatest:
+		start = nats.DeliverNew()
+	default:
+		return from.Unsupported()
+	}
+	if err := s.ensureStream(topic); err != nil {
+		return err
+	}
+
+	// An ordered consumer delivers the messages one at a time, in order.
+	sub, err := s.js.Subscribe(topic, func(msg *nats.Msg) {
+		handler(newEvent(msg))
+	}, nats.OrderedConsumer(), start)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	select {
+	case <-s.closed:
+		return axon.ErrCloseConn
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *jetStore) Request(topic string, payload []byte, v interface{}, opts ...axon.RequestOption) error {
+	if timeout := s.opts.Topic(topic).RequestTimeout; timeout > 0 {
+		opts = append([]axon.RequestOption{axon.WithTimeout(timeout)}, opts...)
+	}
+	req := axon.NewRequestPayload(topic, payload, opts...)
+	req.ServiceName = s.serviceName
+	data, err := req.Compact()
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.natsClient.Request(topic, data, req.Timeout(defaultRequestTimeout))
+	if err != nil {
+		log.Print("Error making eventful request: ", err)
+		return err
+	}
+
+	var reply axon.ReplyPayload
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		log.Print("failed to unmarshal reply event into reply struct with the following errors: ", err)
+		return err
+	}
+	req.SetReplyHeaders(reply.Headers)
+	if replyErr := reply.GetError(); replyErr != nil {
+		return replyErr
+	}
+	return json.Unmarshal(reply.GetPayload(), v)
+}
+
+func (s *jetStore) Reply(topic string, handler axon.ReplyHandler) error {
+	return s.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+// ReplyContext answers the requests made on topic, load balanced across the instances of the service, blocking
+// until the store is closed.
+func (s *jetStore) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	_, err := s.natsClient.QueueSubscribe(topic, s.serviceName, func(msg *nats.Msg) {
+		_, data, err := axon.ServeRequest(topic, msg.Data, handler)
+		if err != nil {
+			log.Print("failed to handle incoming request payload with the following error: ", err)
+			return
+		}
+
+		if err := msg.Respond(data); err != nil {
+			log.Print("failed to reply data to the incoming request with the following error: ", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	<-s.closed
+	return axon.ErrCloseConn
+}
+
+func (s *jetStore) Run(ctx context.Context, handlers ...axon.EventHandler) {
+	for _, handler := range handlers {
+		go handler.Run()
+	}
+
+	<-ctx.Done()
+}
+
+// Close closes the NATS connection. Durable consumers are kept on the server for the next instance.
+func (s *jetStore) Close() error {
+	s.closeOnce.Do(func() {
+		s.natsClient.Close()
+		s.SetState(axon.StateClosed)
+		close(s.closed)
+	})
+	return nil
+}
+
+// sanitize replaces the characters NATS does not allow in stream and consumer names.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', '/', '\\', ' ', '\t':
+			return '_'
+		}
+		return r
+	}, name)
+}
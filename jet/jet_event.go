@@ -0,0 +1,74 @@
+package jet
+
+import (
+	"github.com/Just4Ease/axon"
+	"github.com/nats-io/nats.go"
+	"time"
+)
+
+// Event is the axon.Event handed to jet subscription handlers. Type assert to it to negatively acknowledge or
+// terminate a message, or to read its headers.
+type Event interface {
+	axon.PositionedEvent
+	Nak()                             // Redeliver the message now.
+	NakWithDelay(delay time.Duration) // Redeliver the message after delay.
+	Term()                            // Never redeliver the message.
+	InProgress()                      // Reset the ack wait of a message still being worked on.
+	Headers() nats.Header
+	Metadata() (*nats.MsgMetadata, error)
+}
+
+type jetEvent struct {
+	m *nats.Msg
+}
+
+func (e jetEvent) Ack() {
+	_ = e.m.Ack()
+}
+
+func (e jetEvent) Nak() {
+	_ = e.m.Nak()
+}
+
+func (e jetEvent) NakWithDelay(delay time.Duration) {
+	_ = e.m.NakWithDelay(delay)
+}
+
+func (e jetEvent) Term() {
+	_ = e.m.Term()
+}
+
+func (e jetEvent) InProgress() {
+	_ = e.m.InProgress()
+}
+
+func (e jetEvent) Data() []byte {
+	return e.m.Data
+}
+
+func (e jetEvent) Topic() string {
+	return e.m.Subject
+}
+
+func (e jetEvent) Headers() nats.Header {
+	return e.m.Header
+}
+
+func (e jetEvent) Metadata() (*nats.MsgMetadata, error) {
+	return e.m.Metadata()
+}
+
+// Position returns the stream sequence of the message, to replay its topic from.
+func (e jetEvent) Position() axon.Position {
+	meta, err := e.m.Metadata()
+	if err != nil {
+		return axon.Earliest()
+	}
+	return axon.AtSequence(meta.Sequence.Stream)
+}
+
+func newEvent(msg *nats.Msg) Event {
+	return &jetEvent{
+		m: msg,
+	}
+}
@@ -0,0 +1,285 @@
+package jet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func runServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.Nil(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second), "nats server did not start")
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func newStore(t *testing.T, s *server.Server, opts axon.Options, options ...Option) axon.EventStore {
+	opts.Address = s.ClientURL()
+	store, err := Init(opts, options...)
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func receive(t *testing.T, events <-chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+// subscribe runs Subscribe in the background and waits for the durable consumer to be created.
+func subscribe(t *testing.T, store axon.EventStore, topic string, events chan<- Event) {
+	go func() { _ = store.Subscribe(topic, func(e axon.Event) { events <- e.(Event) }) }()
+	js, err := store.(*jetStore).natsClient.JetStream()
+	require.Nil(t, err)
+	durable := sanitize(store.GetServiceName() + "_" + topic)
+	require.Eventually(t, func() bool {
+		stream, err := js.StreamNameBySubject(topic)
+		if err != nil {
+			return false
+		}
+		_, err = js.ConsumerInfo(stream, durable)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPublishSubscribeWithHeaders(t *testing.T) {
+	s := runServer(t)
+	publisher := newStore(t, s, axon.Options{ServiceName: "publisher"})
+	subscriber := newStore(t, s, axon.Options{ServiceName: "orders"})
+
+	events := make(chan Event, 1)
+	subscribe(t, subscriber, "order.created", events)
+
+	require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+	e := receive(t, events)
+	assert.Equal(t, "#1", string(e.Data()))
+	assert.Equal(t, "order.created", e.Topic())
+	e.Ack()
+
+	hp := publisher.(HeaderPublisher)
+	require.Nil(t, hp.PublishWithHeaders("order.created", []byte("#2"), nats.Header{"Trace-Id": []string{"abc"}}))
+	e = receive(t, events)
+	assert.Equal(t, "abc", e.Headers().Get("Trace-Id"))
+	e.Ack()
+}
+
+func TestNakAndTerm(t *testing.T) {
+	s := runServer(t)
+	store := newStore(t, s, axon.Options{ServiceName: "orders"})
+
+	events := make(chan Event, 4)
+	subscribe(t, store, "order.created", events)
+
+	require.Nil(t, store.Publish("order.created", []byte("#1")))
+	first := receive(t, events)
+	first.Nak()
+	redelivered := receive(t, events)
+	assert.Equal(t, "#1", string(redelivered.Data()))
+	meta, err := redelivered.Metadata()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), meta.NumDelivered)
+	redelivered.Term()
+
+	require.Nil(t, store.Publish("order.created", []byte("#2")))
+	next := receive(t, events)
+	assert.Equal(t, "#2", string(next.Data()), "a terminated message is not delivered again")
+	next.Ack()
+}
+
+func TestRedeliveryAfterAckWait(t *testing.T) {
+	s := runServer(t)
+	store := newStore(t, s, axon.Options{
+		ServiceName: "orders",
+		Topics:      map[string]axon.TopicOptions{"order.created": {AckWait: 200 * time.Millisecond}},
+	})
+
+	events := make(chan Event, 2)
+	subscribe(t, store, "order.created", events)
+
+	require.Nil(t, store.Publish("order.created", []byte("#1")))
+	receive(t, events) // Never acknowledged.
+	e := receive(t, events)
+	assert.Equal(t, "#1", string(e.Data()))
+	e.Ack()
+}
+
+func TestDurableConsumerResumes(t *testing.T) {
+	for name, options := range map[string][]Option{"push": nil, "pull": {PullConsumers(10)}} {
+		t.Run(name, func(t *testing.T) {
+			s := runServer(t)
+			publisher := newStore(t, s, axon.Options{ServiceName: "publisher"})
+			subscriber := newStore(t, s, axon.Options{ServiceName: "orders"}, options...)
+
+			events := make(chan Event, 4)
+			subscribe(t, subscriber, "order.created", events)
+			require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+			receive(t, events).Ack()
+
+			// Messages published while no instance is running wait for the next one.
+			require.Nil(t, subscriber.Close())
+			require.Nil(t, publisher.Publish("order.created", []byte("#2")))
+
+			resumed := newStore(t, s, axon.Options{ServiceName: "orders"}, options...)
+			subscribe(t, resumed, "order.created", events)
+			e := receive(t, events)
+			assert.Equal(t, "#2", string(e.Data()))
+			e.Ack()
+		})
+	}
+}
+
+func TestQueueGroupLoadBalancing(t *testing.T) {
+	for name, options := range map[string][]Option{"push": nil, "pull": {PullConsumers(1)}} {
+		t.Run(name, func(t *testing.T) {
+			s := runServer(t)
+			publisher := newStore(t, s, axon.Options{ServiceName: "publisher"})
+			first := newStore(t, s, axon.Options{ServiceName: "orders"}, options...)
+			second := newStore(t, s, axon.Options{ServiceName: "orders"}, options...)
+
+			var mu sync.Mutex
+			received := map[string]int{}
+			done := make(chan struct{}, 20)
+			handler := func(e axon.Event) {
+				mu.Lock()
+				received[string(e.Data())]++
+				mu.Unlock()
+				e.Ack()
+				done <- struct{}{}
+			}
+			events := make(chan Event, 1)
+			subscribe(t, first, "order.created", events)
+			go func() { _ = second.Subscribe("order.created", handler) }()
+			go func() {
+				for e := range events {
+					handler(e)
+				}
+			}()
+			time.Sleep(100 * time.Millisecond)
+
+			for i := 0; i < 20; i++ {
+				require.Nil(t, publisher.Publish("order.created", []byte{byte('a' + i)}))
+			}
+			for i := 0; i < 20; i++ {
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for messages")
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Len(t, received, 20)
+			for _, count := range received {
+				assert.Equal(t, 1, count, "each message is delivered to a single instance")
+			}
+		})
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	s := runServer(t)
+	caller := newStore(t, s, axon.Options{ServiceName: "caller"})
+	replier := newStore(t, s, axon.Options{ServiceName: "greeter"})
+
+	go func() {
+		_ = replier.ReplyContext("greet", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			var in struct{ Name string }
+			if err := req.ParsePayload(&in); err != nil {
+				return axon.Response{}, err
+			}
+			if in.Name == "" {
+				return axon.Response{}, errors.New("name is required")
+			}
+			out, _ := json.Marshal(map[string]string{"greeting": "hello " + in.Name, "from": req.ServiceName})
+			return axon.Response{Payload: out}, nil
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	var out map[string]string
+	require.Nil(t, caller.Request("greet", []byte(`{"Name":"axon"}`), &out))
+	assert.Equal(t, map[string]string{"greeting": "hello axon", "from": "caller"}, out)
+
+	err := caller.Request("greet", []byte(`{}`), &out)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "name is required")
+}
+
+func TestOpen(t *testing.T) {
+	s := runServer(t)
+	store, err := axon.Open("nats+jetstream://"+s.Addr().String(), axon.Options{ServiceName: "orders"})
+	require.Nil(t, err)
+	assert.Nil(t, store.Health())
+	require.Nil(t, store.Close())
+	assert.Equal(t, axon.ErrCloseConn, store.Health())
+}
+
+func TestReplay(t *testing.T) {
+	store := newStore(t, runServer(t), axon.Options{ServiceName: "orders"})
+	require.Nil(t, store.Publish("order.created", []byte("#1")))
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	require.Nil(t, store.Publish("order.created", []byte("#2")))
+	require.Nil(t, store.Publish("order.created", []byte("#3")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replay := func(from axon.Position, want ...string) []Event {
+		events := make(chan Event, 8)
+		go func() {
+			_ = axon.Replay(ctx, store, "order.created", from, func(e axon.Event) { events <- e.(Event) })
+		}()
+		var received []Event
+		for _, data := range want {
+			e := receive(t, events)
+			assert.Equal(t, data, string(e.Data()))
+			received = append(received, e)
+		}
+		return received
+	}
+
+	all := replay(axon.Earliest(), "#1", "#2", "#3")
+	assert.Equal(t, axon.AtSequence(2), all[1].Position())
+	replay(axon.AtSequence(2), "#2", "#3")
+	replay(axon.AtTime(since), "#2", "#3")
+
+	// Replays leave the durable consumer of the service alone: it only sees the messages published next.
+	events := make(chan Event, 8)
+	subscribe(t, store, "order.created", events)
+	require.Nil(t, store.Publish("order.created", []byte("#4")))
+	assert.Equal(t, "#4", string(receive(t, events).Data()))
+
+	err := axon.Replay(ctx, store, "order.created", axon.AtMessageID([]byte("2")), func(axon.Event) {})
+	assert.ErrorIs(t, err, axon.ErrUnsupportedPosition)
+}
+
+func TestConformance(t *testing.T) {
+	s := runServer(t)
+	axontest.RunConformance(t, func(t *testing.T, opts axon.Options) axon.EventStore {
+		return newStore(t, s, opts)
+	}, axontest.AckWait(200*time.Millisecond))
+}
@@ -0,0 +1,18 @@
+package jet
+
+import (
+	"github.com/Just4Ease/axon"
+	"net/url"
+)
+
+func init() {
+	axon.Register("nats+jetstream", open)
+}
+
+// open connects to the NATS server of a `nats+jetstream://localhost:4222` URL.
+func open(u *url.URL, opts axon.Options) (axon.EventStore, error) {
+	address := *u
+	address.Scheme = "nats"
+	opts.Address = address.String()
+	return Init(opts)
+}
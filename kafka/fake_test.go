@@ -0,0 +1,384 @@
+package kafka
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"sync"
+	"time"
+)
+
+// fakeBroker is an in-process stand-in for a Kafka cluster: topics are partitioned logs, consumer groups commit
+// offsets and split the partitions of their topics between their members, rebalancing as members join or leave.
+// New groups start from the end of the log, like sarama.OffsetNewest.
+type fakeBroker struct {
+	mu         sync.Mutex
+	partitions int
+	logs       map[string][][]*sarama.ConsumerMessage
+	groups     map[string]*fakeGroupState
+	changed    chan struct{} // Closed and replaced whenever a message is appended.
+	next       int
+}
+
+type fakeGroupState struct {
+	members   []*fakeConsumerGroup
+	committed map[string]map[int32]int64
+	sessions  *sync.WaitGroup // Sessions of the current generation.
+	previous  *sync.WaitGroup // Sessions of the previous generation, which must end before new ones consume.
+	cancels   []context.CancelFunc
+}
+
+func newFakeBroker(partitions int) *fakeBroker {
+	return &fakeBroker{
+		partitions: partitions,
+		logs:       make(map[string][][]*sarama.ConsumerMessage),
+		groups:     make(map[string]*fakeGroupState),
+		changed:    make(chan struct{}),
+	}
+}
+
+func (b *fakeBroker) log(topic string) [][]*sarama.ConsumerMessage {
+	if _, ok := b.logs[topic]; !ok {
+		b.logs[topic] = make([][]*sarama.ConsumerMessage, b.partitions)
+	}
+	return b.logs[topic]
+}
+
+func (b *fakeBroker) append(msg *sarama.ProducerMessage) (int32, int64, error) {
+	value, err := msg.Value.Encode()
+	if err != nil {
+		return 0, 0, err
+	}
+	var headers []*sarama.RecordHeader
+	for i := range msg.Headers {
+		headers = append(headers, &msg.Headers[i])
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log := b.log(msg.Topic)
+	partition := int32(b.next % b.partitions)
+	b.next++
+	offset := int64(len(log[partition]))
+	log[partition] = append(log[partition], &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Partition: partition,
+		Offset:    offset,
+		Value:     value,
+		Headers:   headers,
+		Timestamp: time.Now(),
+	})
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return partition, offset, nil
+}
+
+// read returns the message of partition at offset, or a channel closed once more messages are appended.
+func (b *fakeBroker) read(topic string, partition int32, offset int64) (*sarama.ConsumerMessage, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log := b.log(topic)[partition]
+	if offset < int64(len(log)) {
+		return log[offset], nil
+	}
+	return nil, b.changed
+}
+
+func (b *fakeBroker) producer() sarama.SyncProducer {
+	return &fakeProducer{broker: b}
+}
+
+func (b *fakeBroker) newGroup(groupID string) (sarama.ConsumerGroup, error) {
+	return &fakeConsumerGroup{broker: b, id: groupID, errors: make(chan error)}, nil
+}
+
+// rebalance ends the sessions of every member of the group, which join again with a new assignment.
+func (b *fakeBroker) rebalance(g *fakeGroupState) {
+	for _, cancel := range g.cancels {
+		cancel()
+	}
+	g.cancels = nil
+	g.previous, g.sessions = g.sessions, &sync.WaitGroup{}
+}
+
+func (b *fakeBroker) group(id string) *fakeGroupState {
+	g, ok := b.groups[id]
+	if !ok {
+		g = &fakeGroupState{
+			committed: make(map[string]map[int32]int64),
+			sessions:  &sync.WaitGroup{},
+			previous:  &sync.WaitGroup{},
+		}
+		b.groups[id] = g
+	}
+	return g
+}
+
+type fakeProducer struct {
+	sarama.SyncProducer // Only the methods below are implemented.
+	broker              *fakeBroker
+}
+
+func (p *fakeProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return p.broker.append(msg)
+}
+
+func (p *fakeProducer) Close() error {
+	return nil
+}
+
+type fakeConsumerGroup struct {
+	sarama.ConsumerGroup // Only the methods below are implemented.
+	broker               *fakeBroker
+	id                   string
+	errors               chan error
+	joined               bool
+	closed               bool
+}
+
+func (c *fakeConsumerGroup) Errors() <-chan error {
+	return c.errors
+}
+
+func (c *fakeConsumerGroup) Close() error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	g := b.group(c.id)
+	for i, member := range g.members {
+		if member == c {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	b.rebalance(g)
+	return nil
+}
+
+// Consume joins the group on its first call, then runs a session over the partitions assigned to the member
+// until ctx is done or the group rebalances.
+func (c *fakeConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	b := c.broker
+	b.mu.Lock()
+	if c.closed {
+		b.mu.Unlock()
+		return sarama.ErrClosedConsumerGroup
+	}
+	g := b.group(c.id)
+	if !c.joined {
+		c.joined = true
+		g.members = append(g.members, c)
+		b.rebalance(g)
+	}
+
+	index := 0
+	for i, member := range g.members {
+		if member == c {
+			index = i
+		}
+	}
+	claims := make(map[string][]int32)
+	for _, topic := range topics {
+		b.log(topic)
+		for p := 0; p < b.partitions; p++ {
+			if p%len(g.members) == index {
+				claims[topic] = append(claims[topic], int32(p))
+			}
+		}
+	}
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	g.cancels = append(g.cancels, cancel)
+	sessions, previous := g.sessions, g.previous
+	sessions.Add(1)
+	b.mu.Unlock()
+
+	defer sessions.Done()
+	defer cancel()
+	previous.Wait()
+
+	session := &fakeSession{ctx: sessionCtx, broker: b, group: g, claims: claims}
+	if err := handler.Setup(session); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			claim := session.claim(topic, partition)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = handler.ConsumeClaim(session, claim)
+			}()
+		}
+	}
+	<-sessionCtx.Done()
+	wg.Wait()
+	return handler.Cleanup(session)
+}
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession // Only the methods below are implemented.
+	ctx                         context.Context
+	broker                      *fakeBroker
+	group                       *fakeGroupState
+	claims                      map[string][]int32
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if s.group.committed[topic] == nil {
+		s.group.committed[topic] = make(map[int32]int64)
+	}
+	if offset > s.group.committed[topic][partition] {
+		s.group.committed[topic][partition] = offset
+	}
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// claim streams the messages of partition from the committed offset, or from the end of the log.
+func (s *fakeSession) claim(topic string, partition int32) *fakeClaim {
+	b := s.broker
+	b.mu.Lock()
+	offset, ok := s.group.committed[topic][partition]
+	if !ok {
+		offset = int64(len(b.log(topic)[partition]))
+		if s.group.committed[topic] == nil {
+			s.group.committed[topic] = make(map[int32]int64)
+		}
+		s.group.committed[topic][partition] = offset
+	}
+	b.mu.Unlock()
+
+	claim := &fakeClaim{topic: topic, partition: partition, offset: offset, messages: make(chan *sarama.ConsumerMessage)}
+	go func() {
+		defer close(claim.messages)
+		for next := offset; ; {
+			msg, changed := b.read(topic, partition, next)
+			if msg == nil {
+				select {
+				case <-changed:
+					continue
+				case <-s.ctx.Done():
+					return
+				}
+			}
+			select {
+			case claim.messages <- msg:
+				next++
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+	return claim
+}
+
+type fakeClaim struct {
+	topic     string
+	partition int32
+	offset    int64
+	messages  chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string                            { return c.topic }
+func (c *fakeClaim) Partition() int32                         { return c.partition }
+func (c *fakeClaim) InitialOffset() int64                     { return c.offset }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func (b *fakeBroker) newConsumer() (sarama.Consumer, error) {
+	return &fakeConsumer{broker: b}, nil
+}
+
+// fakeConsumer reads partitions without a consumer group, committing nothing.
+type fakeConsumer struct {
+	sarama.Consumer // Only the methods below are implemented.
+	broker          *fakeBroker
+	mu              sync.Mutex
+	partitions      []*fakePartitionConsumer
+}
+
+func (c *fakeConsumer) Partitions(topic string) ([]int32, error) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	partitions := make([]int32, len(c.broker.log(topic)))
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions, nil
+}
+
+// ConsumePartition streams the messages of partition from offset, or from the end of the log for
+// sarama.OffsetNewest.
+func (c *fakeConsumer) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	b := c.broker
+	if offset == sarama.OffsetNewest {
+		b.mu.Lock()
+		offset = int64(len(b.log(topic)[partition]))
+		b.mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pc := &fakePartitionConsumer{cancel: cancel, messages: make(chan *sarama.ConsumerMessage)}
+	c.mu.Lock()
+	c.partitions = append(c.partitions, pc)
+	c.mu.Unlock()
+
+	go func() {
+		defer close(pc.messages)
+		for next := offset; ; {
+			msg, changed := b.read(topic, partition, next)
+			if msg == nil {
+				select {
+				case <-changed:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case pc.messages <- msg:
+				next++
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return pc, nil
+}
+
+func (c *fakeConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pc := range c.partitions {
+		pc.AsyncClose()
+	}
+	return nil
+}
+
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer // Only the methods below are implemented.
+	cancel                   context.CancelFunc
+	messages                 chan *sarama.ConsumerMessage
+}
+
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+func (pc *fakePartitionConsumer) AsyncClose()                              { pc.cancel() }
+func (pc *fakePartitionConsumer) Close() error                             { pc.cancel(); return nil }
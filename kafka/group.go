@@ -0,0 +1,71 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"sort"
+	"sync"
+)
+
+// claimHandler hands the messages of the partitions claimed by a consumer group session to deliver, along with
+// the function acknowledging them.
+type claimHandler struct {
+	deliver func(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, ack func())
+}
+
+func (h *claimHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *claimHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *claimHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	offsets := &partitionOffsets{}
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			offsets.received(msg.Offset)
+			var once sync.Once
+			h.deliver(session, msg, func() {
+				once.Do(func() {
+					session.MarkOffset(msg.Topic, msg.Partition, offsets.acked(msg.Offset), "")
+				})
+			})
+		}
+	}
+}
+
+// partitionOffsets tracks the messages of a partition handed to handlers. Kafka commits a position rather than
+// single messages, so the committed offset is the oldest message still unacknowledged.
+type partitionOffsets struct {
+	mu          sync.Mutex
+	outstanding []int64 // Ascending, as partitions are consumed in order.
+	last        int64
+}
+
+func (p *partitionOffsets) received(offset int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.outstanding = append(p.outstanding, offset)
+	p.last = offset
+}
+
+// acked returns the offset to commit once offset is acknowledged.
+func (p *partitionOffsets) acked(offset int64) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := sort.Search(len(p.outstanding), func(i int) bool { return p.outstanding[i] >= offset })
+	if i < len(p.outstanding) && p.outstanding[i] == offset {
+		p.outstanding = append(p.outstanding[:i], p.outstanding[i+1:]...)
+	}
+	if len(p.outstanding) == 0 {
+		return p.last + 1
+	}
+	return p.outstanding[0]
+}
@@ -0,0 +1,418 @@
+// Package kafka implements axon.EventStore on Apache Kafka.
+//
+// Topics map to Kafka topics and every service consumes through a consumer group named after it, so each
+// message is delivered to one instance of the service. Kafka commits positions rather than single messages:
+// acknowledging a message commits the offset of the oldest message of its partition still unacknowledged, and
+// a message left unacknowledged for longer than the ack wait is handed to the handler again. Replies are
+// produced to the `<service>.replies` topic of the caller and matched to requests with a header. Instances read
+// the reply topic without a consumer group, from the end of each partition, so they leave no group behind.
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/IBM/sarama"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAckWait        = 30 * time.Second
+	defaultRequestTimeout = 5 * time.Second
+	retryDelay            = time.Second
+	requestIDHeader       = "axon-request-id"
+)
+
+type Option func(*kafkaStore)
+
+// AckWait sets how long a delivered message may stay unacknowledged before it is delivered again.
+func AckWait(d time.Duration) Option {
+	return func(s *kafkaStore) {
+		s.ackWait = d
+	}
+}
+
+// Configure adjusts the sarama configuration built from axon.Options before the client is created, such as to
+// set the Kafka version or SASL mechanism.
+func Configure(fn func(config *sarama.Config)) Option {
+	return func(s *kafkaStore) {
+		s.configure = append(s.configure, fn)
+	}
+}
+
+type kafkaStore struct {
+	*axon.StateTracker
+	producer    sarama.SyncProducer
+	newGroup    func(groupID string) (sarama.ConsumerGroup, error)
+	newConsumer func() (sarama.Consumer, error)
+	client      io.Closer
+	serviceName string
+	opts        axon.Options
+	ackWait     time.Duration
+	configure   []func(config *sarama.Config)
+
+	repliesOnce sync.Once
+	repliesErr  error
+	replies     sarama.Consumer
+	pending     sync.Map // Request id to the channel awaiting its reply.
+
+	groupsMu  sync.Mutex
+	groups    []sarama.ConsumerGroup
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// Init connects to the Kafka brokers of opts.Address, either `kafka://host:9092?broker=other:9092` or a comma
+// separated list of brokers. Auth.Username and Auth.Password enable SASL/PLAIN, and TLS is used when configured.
+func Init(opts axon.Options, options ...Option) (axon.EventStore, error) {
+	brokers, err := parseBrokers(opts.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(opts.ServiceName)
+	if name == "" {
+		return nil, axon.ErrEmptyStoreName
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = name
+	config.Version = sarama.V2_1_0_0 // Record headers need 0.11 or later.
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	if auth := opts.Auth; auth != nil && auth.Username != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		config.Net.SASL.User = auth.Username
+		config.Net.SASL.Password = auth.Password
+	}
+	if t := opts.ResolvedTLS(); t != nil {
+		tlsConfig, err := t.Config()
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	s := newStore(nil, nil, opts, options...)
+	for _, fn := range s.configure {
+		fn(config)
+	}
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to kafka")
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	s.client = client
+	s.producer = producer
+	s.newGroup = func(groupID string) (sarama.ConsumerGroup, error) {
+		return sarama.NewConsumerGroupFromClient(groupID, client)
+	}
+	s.newConsumer = func() (sarama.Consumer, error) {
+		return sarama.NewConsumerFromClient(client)
+	}
+	return s, nil
+}
+
+func parseBrokers(address string) ([]string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return nil, axon.ErrInvalidURL
+	}
+
+	if !strings.Contains(address, "://") {
+		return strings.Split(address, ","), nil
+	}
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme != "kafka" || u.Host == "" {
+		return nil, axon.ErrInvalidURL
+	}
+	return append([]string{u.Host}, u.Query()["broker"]...), nil
+}
+
+func newStore(producer sarama.SyncProducer, newGroup func(string) (sarama.ConsumerGroup, error), opts axon.Options, options ...Option) *kafkaStore {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &kafkaStore{
+		StateTracker: axon.NewStateTracker(axon.StateConnected),
+		producer:     producer,
+		newGroup:     newGroup,
+		serviceName:  strings.TrimSpace(opts.ServiceName),
+		opts:         opts,
+		ackWait:      defaultAckWait,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.ackWait <= 0 {
+		s.ackWait = defaultAckWait
+	}
+	return s
+}
+
+func (s *kafkaStore) GetServiceName() string {
+	return s.serviceName
+}
+
+// track updates the connection state from the outcome of a call to the brokers.
+func (s *kafkaStore) track(err error) error {
+	if err == nil {
+		s.SetState(axon.StateConnected)
+	} else if errors.Is(err, sarama.ErrOutOfBrokers) || errors.Is(err, sarama.ErrNotConnected) {
+		s.SetState(axon.StateDisconnected)
+	}
+	return err
+}
+
+func (s *kafkaStore) Publish(topic string, message []byte) error {
+	return s.send(&sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(message)})
+}
+
+func (s *kafkaStore) send(msg *sarama.ProducerMessage) error {
+	if s.ctx.Err() != nil {
+		return axon.ErrCloseConn
+	}
+	_, _, err := s.producer.SendMessage(msg)
+	return s.track(err)
+}
+
+// consume runs the consumer group groupID on topic until the store is closed, joining it again after every
+// rebalance.
+func (s *kafkaStore) consume(groupID, topic string, handler *claimHandler) error {
+	group, err := s.newGroup(groupID)
+	if err != nil {
+		return s.track(err)
+	}
+	s.groupsMu.Lock()
+	s.groups = append(s.groups, group)
+	s.groupsMu.Unlock()
+
+	for {
+		err := group.Consume(s.ctx, []string{topic}, handler)
+		if s.ctx.Err() != nil || errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			return axon.ErrCloseConn
+		}
+		if err != nil {
+			log.Print("failed to consume from kafka with the following error: ", s.track(err))
+			time.Sleep(retryDelay)
+		}
+	}
+}
+
+// Subscribe delivers the messages of topic to one instance of the service, blocking until the store is closed.
+func (s *kafkaStore) Subscribe(topic string, handler axon.SubscriptionHandler) error {
+	topicOpts := s.opts.Topic(topic)
+	limiter := axon.NewLimiter(topicOpts.Concurrency)
+	ackWait := s.ackWait
+	if topicOpts.AckWait > 0 {
+		ackWait = topicOpts.AckWait
+	}
+
+	return s.consume(s.serviceName, topic, &claimHandler{
+		deliver: func(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, ack func()) {
+			e := newEvent(msg, ack)
+			limiter.Go(handler, e) // Holds the partition back while the handlers are busy.
+			go func() {
+				timer := time.NewTimer(ackWait)
+				defer timer.Stop()
+				for {
+					select {
+					case <-e.acked:
+						return
+					case <-session.Context().Done():
+						return // The next owner of the partition resumes from the committed offset.
+					case <-timer.C:
+						limiter.Go(handler, e)
+						timer.Reset(ackWait)
+					}
+				}
+			}()
+		},
+	})
+}
+
+func (s *kafkaStore) replyTopic() string {
+	return s.serviceName + ".replies"
+}
+
+// startReplies reads every partition of the reply topic of the service from its end, without a consumer group,
+// so the instance sees the replies to the requests it sends from now on and commits nothing.
+func (s *kafkaStore) startReplies() error {
+	s.repliesOnce.Do(func() {
+		consumer, err := s.newConsumer()
+		if err != nil {
+			s.repliesErr = s.track(err)
+			return
+		}
+		partitions, err := consumer.Partitions(s.replyTopic())
+		if err != nil {
+			_ = consumer.Close()
+			s.repliesErr = s.track(err)
+			return
+		}
+		for _, partition := range partitions {
+			pc, err := consumer.ConsumePartition(s.replyTopic(), partition, sarama.OffsetNewest)
+			if err != nil {
+				_ = consumer.Close()
+				s.repliesErr = s.track(err)
+				return
+			}
+			go s.readReplies(pc)
+		}
+
+		s.groupsMu.Lock()
+		s.replies = consumer
+		s.groupsMu.Unlock()
+	})
+	return s.repliesErr
+}
+
+// readReplies hands the replies of a partition to the requests awaiting them, until the store is closed.
+func (s *kafkaStore) readReplies(pc sarama.PartitionConsumer) {
+	for msg := range pc.Messages() {
+		if replies, ok := s.pending.Load(header(msg, requestIDHeader)); ok {
+			select {
+			case replies.(chan []byte) <- msg.Value:
+			default:
+			}
+		}
+	}
+}
+
+func (s *kafkaStore) Request(topic string, payload []byte, v interface{}, opts ...axon.RequestOption) error {
+	if err := s.startReplies(); err != nil {
+		return err
+	}
+
+	if timeout := s.opts.Topic(topic).RequestTimeout; timeout > 0 {
+		opts = append([]axon.RequestOption{axon.WithTimeout(timeout)}, opts...)
+	}
+	req := axon.NewRequestPayload(topic, payload, opts...)
+	req.ServiceName = s.serviceName
+	req.ReplyPipe = s.replyTopic()
+	data, err := req.Compact()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, req.Timeout(defaultRequestTimeout))
+	defer cancel()
+
+	requestID := axon.GenerateRandomString()
+	replies := make(chan []byte, 1)
+	s.pending.Store(requestID, replies)
+	defer s.pending.Delete(requestID)
+
+	if err := s.send(&sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(data),
+		Headers: []sarama.RecordHeader{{Key: []byte(requestIDHeader), Value: []byte(requestID)}},
+	}); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case out := <-replies:
+		var reply axon.ReplyPayload
+		if err := json.Unmarshal(out, &reply); err != nil {
+			log.Print("failed to unmarshal reply event into reply struct with the following errors: ", err)
+			return err
+		}
+		req.SetReplyHeaders(reply.Headers)
+		if replyErr := reply.GetError(); replyErr != nil {
+			return replyErr
+		}
+		return json.Unmarshal(reply.GetPayload(), v)
+	}
+}
+
+func (s *kafkaStore) Reply(topic string, handler axon.ReplyHandler) error {
+	return s.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+// ReplyContext answers the requests made on topic, load balanced across the instances of the service, blocking
+// until the store is closed.
+func (s *kafkaStore) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	return s.consume(s.serviceName, topic, &claimHandler{
+		deliver: func(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, ack func()) {
+			go func() {
+				defer ack()
+				req, out, err := axon.ServeRequest(topic, msg.Value, handler)
+				if err != nil {
+					log.Print("failed to handle incoming request payload with the following error: ", err)
+					return
+				}
+
+				if err := s.send(&sarama.ProducerMessage{
+					Topic:   req.GetReplyAddress(),
+					Value:   sarama.ByteEncoder(out),
+					Headers: []sarama.RecordHeader{{Key: []byte(requestIDHeader), Value: []byte(header(msg, requestIDHeader))}},
+				}); err != nil {
+					log.Print("failed to reply data to the incoming request with the following error: ", err)
+				}
+			}()
+		},
+	})
+}
+
+func (s *kafkaStore) Run(ctx context.Context, handlers ...axon.EventHandler) {
+	for _, handler := range handlers {
+		go handler.Run()
+	}
+
+	<-ctx.Done()
+}
+
+// Close leaves the consumer groups, stops reading replies and closes the producer and client. Committed offsets
+// stay with the brokers.
+func (s *kafkaStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.cancel()
+		s.SetState(axon.StateClosed)
+
+		s.groupsMu.Lock()
+		for _, group := range s.groups {
+			_ = group.Close()
+		}
+		if s.replies != nil {
+			_ = s.replies.Close()
+		}
+		s.groupsMu.Unlock()
+
+		err = s.producer.Close()
+		if s.client != nil {
+			if clientErr := s.client.Close(); err == nil {
+				err = clientErr
+			}
+		}
+	})
+	return err
+}
+
+func header(msg *sarama.ConsumerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"sync"
+)
+
+type kafkaEvent struct {
+	m     *sarama.ConsumerMessage
+	ack   func()
+	once  sync.Once
+	acked chan struct{}
+}
+
+func (e *kafkaEvent) Ack() {
+	e.once.Do(func() {
+		close(e.acked)
+		e.ack()
+	})
+}
+
+func (e *kafkaEvent) Data() []byte {
+	return e.m.Value
+}
+
+func (e *kafkaEvent) Topic() string {
+	return e.m.Topic
+}
+
+func newEvent(msg *sarama.ConsumerMessage, ack func()) *kafkaEvent {
+	return &kafkaEvent{
+		m:     msg,
+		ack:   ack,
+		acked: make(chan struct{}),
+	}
+}
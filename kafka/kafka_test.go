@@ -0,0 +1,197 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, b *fakeBroker, opts axon.Options, options ...Option) *kafkaStore {
+	store := newStore(b.producer(), b.newGroup, opts, options...)
+	store.newConsumer = b.newConsumer
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func receive(t *testing.T, events <-chan axon.Event) axon.Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+// subscribe runs Subscribe in the background and waits for the store to join the group of its service.
+func subscribe(t *testing.T, b *fakeBroker, store *kafkaStore, topic string, handler axon.SubscriptionHandler) {
+	b.mu.Lock()
+	members := len(b.group(store.serviceName).members)
+	b.mu.Unlock()
+
+	go func() { _ = store.Subscribe(topic, handler) }()
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(b.group(store.serviceName).members) > members
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond) // Let the rebalance settle.
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	b := newFakeBroker(4)
+	publisher := newTestStore(t, b, axon.Options{ServiceName: "publisher"})
+	first := newTestStore(t, b, axon.Options{ServiceName: "orders"})
+	second := newTestStore(t, b, axon.Options{ServiceName: "orders"})
+	billing := newTestStore(t, b, axon.Options{ServiceName: "billing"})
+
+	var mu sync.Mutex
+	counts := map[string]int{}
+	done := make(chan struct{}, 20)
+	handler := func(name string) axon.SubscriptionHandler {
+		return func(e axon.Event) {
+			assert.Equal(t, "order.created", e.Topic())
+			mu.Lock()
+			counts[name]++
+			mu.Unlock()
+			e.Ack()
+			done <- struct{}{}
+		}
+	}
+	subscribe(t, b, first, "order.created", handler("first"))
+	subscribe(t, b, second, "order.created", handler("second"))
+	subscribe(t, b, billing, "order.created", handler("billing"))
+
+	for i := 0; i < 8; i++ {
+		require.Nil(t, publisher.Publish("order.created", []byte("order")))
+	}
+	for i := 0; i < 16; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 4, counts["first"], "partitions are split between the instances of a service")
+	assert.Equal(t, 4, counts["second"])
+	assert.Equal(t, 8, counts["billing"])
+}
+
+func TestAckCommitsOffsets(t *testing.T) {
+	b := newFakeBroker(1)
+	publisher := newTestStore(t, b, axon.Options{ServiceName: "publisher"})
+	subscriber := newTestStore(t, b, axon.Options{ServiceName: "orders"}, AckWait(100*time.Millisecond))
+
+	events := make(chan axon.Event, 8)
+	subscribe(t, b, subscriber, "order.created", func(e axon.Event) { events <- e })
+
+	require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+	require.Nil(t, publisher.Publish("order.created", []byte("#2")))
+	// #2 is acknowledged but #1 is not, so the committed offset stays at #1, which is delivered again.
+	var redelivered axon.Event
+	acked, deliveries := false, 0
+	for !acked || deliveries < 2 {
+		e := receive(t, events)
+		if string(e.Data()) == "#2" {
+			e.Ack()
+			acked = true
+			continue
+		}
+		redelivered = e
+		deliveries++
+	}
+	b.mu.Lock()
+	assert.Equal(t, int64(0), b.group("orders").committed["order.created"][0])
+	b.mu.Unlock()
+	redelivered.Ack()
+	b.mu.Lock()
+	assert.Equal(t, int64(2), b.group("orders").committed["order.created"][0])
+	b.mu.Unlock()
+
+	// A new instance resumes from the committed offset.
+	require.Nil(t, subscriber.Close())
+	require.Nil(t, publisher.Publish("order.created", []byte("#3")))
+	resumed := newTestStore(t, b, axon.Options{ServiceName: "orders"})
+	subscribe(t, b, resumed, "order.created", func(e axon.Event) { events <- e })
+	e := receive(t, events)
+	assert.Equal(t, "#3", string(e.Data()))
+	e.Ack()
+}
+
+func TestRequestReply(t *testing.T) {
+	b := newFakeBroker(2)
+	caller := newTestStore(t, b, axon.Options{ServiceName: "caller"})
+	replier := newTestStore(t, b, axon.Options{ServiceName: "greeter"})
+
+	go func() {
+		_ = replier.ReplyContext("greet", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			var in struct{ Name string }
+			if err := req.ParsePayload(&in); err != nil {
+				return axon.Response{}, err
+			}
+			if in.Name == "" {
+				return axon.Response{}, errors.New("name is required")
+			}
+			if in.Name == "sleepy" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			out, _ := json.Marshal(map[string]string{"greeting": "hello " + in.Name, "from": req.ServiceName})
+			return axon.Response{Payload: out}, nil
+		})
+	}()
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(b.group("greeter").members) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	var out map[string]string
+	require.Nil(t, caller.Request("greet", []byte(`{"Name":"axon"}`), &out))
+	assert.Equal(t, map[string]string{"greeting": "hello axon", "from": "caller"}, out)
+
+	err := caller.Request("greet", []byte(`{}`), &out)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "name is required")
+
+	err = caller.Request("greet", []byte(`{"Name":"sleepy"}`), &out, axon.WithTimeout(50*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// Replies are read without a consumer group, so no group is left behind per instance.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id := range b.groups {
+		assert.NotContains(t, id, "replies")
+	}
+}
+
+func TestParseBrokers(t *testing.T) {
+	brokers, err := parseBrokers("kafka://one:9092?broker=two:9092&broker=three:9092")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"one:9092", "two:9092", "three:9092"}, brokers)
+
+	brokers, err = parseBrokers("one:9092,two:9092")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"one:9092", "two:9092"}, brokers)
+
+	_, err = parseBrokers("pulsar://one:6650")
+	assert.Equal(t, axon.ErrInvalidURL, err)
+}
+
+func TestConformance(t *testing.T) {
+	b := newFakeBroker(4)
+	axontest.RunConformance(t, func(t *testing.T, opts axon.Options) axon.EventStore {
+		return newTestStore(t, b, opts)
+	}, axontest.AckWait(200*time.Millisecond))
+}
@@ -0,0 +1,429 @@
+// Package mem implements axon.EventStore in memory, for tests and single process deployments.
+//
+// Stores opened on the same broker behave like services connected to the same cluster: every service name
+// subscribed to a topic receives each message once, load balanced across its subscribers, and messages that
+// are not acknowledged within the ack wait are delivered again.
+package mem
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAckWait        = 30 * time.Second
+	defaultRequestTimeout = 5 * time.Second
+	defaultBrokerName     = "default"
+)
+
+var ErrNoReplier = errors.New("Sorry, no service is replying on this topic")
+
+var (
+	brokersMu sync.Mutex
+	brokers   = make(map[string]*Broker)
+)
+
+// Broker routes messages between the stores connected to it.
+type Broker struct {
+	mu       sync.Mutex
+	groups   []*group
+	repliers []*group
+}
+
+func NewBroker() *Broker {
+	return &Broker{}
+}
+
+// NamedBroker returns the process wide broker used by stores whose address is `mem://<name>`.
+func NamedBroker(name string) *Broker {
+	if name == "" {
+		name = defaultBrokerName
+	}
+
+	brokersMu.Lock()
+	defer brokersMu.Unlock()
+	b, ok := brokers[name]
+	if !ok {
+		b = NewBroker()
+		brokers[name] = b
+	}
+	return b
+}
+
+type Option func(*memStore)
+
+// WithBroker connects the store to b instead of the broker named by the address.
+func WithBroker(b *Broker) Option {
+	return func(s *memStore) {
+		s.broker = b
+	}
+}
+
+// AckWait sets how long a delivered message may stay unacknowledged before it is delivered again.
+func AckWait(d time.Duration) Option {
+	return func(s *memStore) {
+		s.ackWait = d
+	}
+}
+
+// RequestTimeout sets how long Request waits for a reply when the caller sets no timeout.
+func RequestTimeout(d time.Duration) Option {
+	return func(s *memStore) {
+		s.requestTimeout = d
+	}
+}
+
+type memStore struct {
+	*axon.StateTracker
+	serviceName    string
+	opts           axon.Options
+	broker         *Broker
+	ackWait        time.Duration
+	requestTimeout time.Duration
+	closed         chan struct{}
+	closeOnce      sync.Once
+}
+
+// Init connects a store to the broker named by the host of opts.Address, such as `mem://orders`.
+// An empty address uses the default broker.
+func Init(opts axon.Options, options ...Option) (axon.EventStore, error) {
+	name := strings.TrimSpace(opts.ServiceName)
+	if name == "" {
+		return nil, axon.ErrEmptyStoreName
+	}
+
+	s := &memStore{
+		StateTracker:   axon.NewStateTracker(axon.StateConnected),
+		serviceName:    name,
+		opts:           opts,
+		ackWait:        defaultAckWait,
+		requestTimeout: defaultRequestTimeout,
+		closed:         make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.broker == nil {
+		brokerName := ""
+		if addr := strings.TrimSpace(opts.Address); addr != "" {
+			u, err := url.Parse(addr)
+			if err != nil || u.Scheme != "mem" {
+				return nil, axon.ErrInvalidURL
+			}
+			brokerName = u.Host
+		}
+		s.broker = NamedBroker(brokerName)
+	}
+	return s, nil
+}
+
+func (s *memStore) GetServiceName() string {
+	return s.serviceName
+}
+
+func (s *memStore) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *memStore) Publish(topic string, message []byte) error {
+	if s.isClosed() {
+		return axon.ErrCloseConn
+	}
+
+	data := append([]byte(nil), message...)
+	for _, g := range s.broker.matching(&s.broker.groups, topic) {
+		g.deliver(topic, data)
+	}
+	return nil
+}
+
+func (s *memStore) Subscribe(topic string, handler axon.SubscriptionHandler) error {
+	topicOpts := s.opts.Topic(topic)
+	return s.subscribe(&group{topic: topic, service: s.serviceName}, topicOpts, handler)
+}
+
+// SubscribePattern subscribes to every topic matching pattern, including topics first published afterwards.
+func (s *memStore) SubscribePattern(pattern *regexp.Regexp, handler axon.SubscriptionHandler) error {
+	return s.subscribe(&group{pattern: pattern, service: s.serviceName}, axon.TopicOptions{Concurrency: s.opts.Concurrency}, handler)
+}
+
+func (s *memStore) subscribe(g *group, topicOpts axon.TopicOptions, handler axon.SubscriptionHandler) error {
+	if s.isClosed() {
+		return axon.ErrCloseConn
+	}
+
+	ackWait := s.ackWait
+	if topicOpts.AckWait > 0 {
+		ackWait = topicOpts.AckWait
+	}
+	m := &member{store: s, ackWait: ackWait, handler: handler, limit: topicOpts.Concurrency}
+	g = s.broker.join(&s.broker.groups, g, m)
+	<-s.closed
+	s.broker.leave(&s.broker.groups, g, m)
+	return axon.ErrCloseConn
+}
+
+func (s *memStore) Reply(topic string, handler axon.ReplyHandler) error {
+	return s.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+func (s *memStore) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	if s.isClosed() {
+		return axon.ErrCloseConn
+	}
+
+	m := &member{store: s, replyHandler: handler}
+	g := s.broker.join(&s.broker.repliers, &group{topic: topic, service: s.serviceName}, m)
+	<-s.closed
+	s.broker.leave(&s.broker.repliers, g, m)
+	return axon.ErrCloseConn
+}
+
+func (s *memStore) Request(topic string, payload []byte, v interface{}, opts ...axon.RequestOption) error {
+	if s.isClosed() {
+		return axon.ErrCloseConn
+	}
+
+	if timeout := s.opts.Topic(topic).RequestTimeout; timeout > 0 {
+		opts = append([]axon.RequestOption{axon.WithTimeout(timeout)}, opts...)
+	}
+	req := axon.NewRequestPayload(topic, payload, opts...)
+	req.ServiceName = s.serviceName
+	data, err := req.Compact()
+	if err != nil {
+		return err
+	}
+
+	var m *member
+	for _, g := range s.broker.matching(&s.broker.repliers, topic) {
+		if m = g.pick(); m != nil {
+			break
+		}
+	}
+	if m == nil {
+		return ErrNoReplier
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout(s.requestTimeout))
+	defer cancel()
+
+	replyChan := make(chan []byte, 1)
+	errChan := make(chan error, 1)
+	go func() {
+		_, out, err := axon.ServeRequest(topic, data, m.replyHandler)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		replyChan <- out
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errChan:
+		return err
+	case out := <-replyChan:
+		var reply axon.ReplyPayload
+		if err := json.Unmarshal(out, &reply); err != nil {
+			log.Print("failed to unmarshal reply event into reply struct with the following errors: ", err)
+			return err
+		}
+		req.SetReplyHeaders(reply.Headers)
+		if replyErr := reply.GetError(); replyErr != nil {
+			return replyErr
+		}
+		return json.Unmarshal(reply.GetPayload(), v)
+	}
+}
+
+func (s *memStore) Run(ctx context.Context, handlers ...axon.EventHandler) {
+	for _, handler := range handlers {
+		go handler.Run()
+	}
+	<-ctx.Done()
+}
+
+func (s *memStore) Close() error {
+	s.closeOnce.Do(func() {
+		s.SetState(axon.StateClosed)
+		close(s.closed)
+	})
+	return nil
+}
+
+// group is a service subscribed to a topic or pattern. Each message is delivered to one of its members.
+// Like a durable subscription, a group outlives its members and keeps messages until a member joins again.
+type group struct {
+	topic   string
+	pattern *regexp.Regexp
+	service string
+
+	mu      sync.Mutex
+	members []*member
+	next    int
+	pending []queued
+}
+
+type queued struct {
+	topic string
+	data  []byte
+}
+
+type member struct {
+	store        *memStore
+	ackWait      time.Duration
+	handler      axon.SubscriptionHandler
+	replyHandler axon.ContextReplyHandler
+
+	// Events are delivered from Publish, which must not wait for the handlers, so at most limit workers run the
+	// handler and the events delivered meanwhile are queued for them.
+	limit   int
+	mu      sync.Mutex
+	running int
+	queue   []*event
+}
+
+// dispatch hands e to a worker of m, starting one unless limit of them run.
+func (m *member) dispatch(e *event) {
+	m.mu.Lock()
+	if m.limit > 0 && m.running >= m.limit {
+		m.queue = append(m.queue, e)
+		m.mu.Unlock()
+		return
+	}
+	m.running++
+	m.mu.Unlock()
+	go m.work(e)
+}
+
+// work runs the handler with e, then with the queued events until none is left.
+func (m *member) work(e *event) {
+	for {
+		m.handler(e)
+
+		m.mu.Lock()
+		if len(m.queue) == 0 {
+			m.running--
+			m.mu.Unlock()
+			return
+		}
+		e = m.queue[0]
+		m.queue[0] = nil
+		m.queue = m.queue[1:]
+		m.mu.Unlock()
+	}
+}
+
+func (g *group) key() string {
+	if g.pattern != nil {
+		return "pattern:" + g.pattern.String()
+	}
+	return "topic:" + g.topic
+}
+
+func (g *group) matches(topic string) bool {
+	if g.pattern != nil {
+		return g.pattern.MatchString(topic)
+	}
+	return g.topic == topic
+}
+
+// pick returns the next member in round robin order, or nil when the group has none left.
+func (g *group) pick() *member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.members) == 0 {
+		return nil
+	}
+	m := g.members[g.next%len(g.members)]
+	g.next++
+	return m
+}
+
+func (g *group) deliver(topic string, data []byte) {
+	g.mu.Lock()
+	if len(g.members) == 0 {
+		g.pending = append(g.pending, queued{topic: topic, data: data})
+		g.mu.Unlock()
+		return
+	}
+	m := g.members[g.next%len(g.members)]
+	g.next++
+	g.mu.Unlock()
+
+	e := &event{topic: topic, data: data, acked: make(chan struct{})}
+	m.dispatch(e)
+	go func() {
+		timer := time.NewTimer(m.ackWait)
+		defer timer.Stop()
+		select {
+		case <-e.acked:
+		case <-timer.C:
+			g.deliver(topic, data)
+		}
+	}()
+}
+
+func (b *Broker) join(groups *[]*group, g *group, m *member) *group {
+	b.mu.Lock()
+	found := false
+	for _, existing := range *groups {
+		if existing.service == g.service && existing.key() == g.key() {
+			g, found = existing, true
+			break
+		}
+	}
+	if !found {
+		*groups = append(*groups, g)
+	}
+	b.mu.Unlock()
+
+	g.mu.Lock()
+	g.members = append(g.members, m)
+	pending := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+
+	for _, msg := range pending {
+		g.deliver(msg.topic, msg.data)
+	}
+	return g
+}
+
+func (b *Broker) leave(groups *[]*group, g *group, m *member) {
+	g.mu.Lock()
+	for i, existing := range g.members {
+		if existing == m {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	g.mu.Unlock()
+}
+
+func (b *Broker) matching(groups *[]*group, topic string) []*group {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []*group
+	for _, g := range *groups {
+		if g.matches(topic) {
+			out = append(out, g)
+		}
+	}
+	return out
+}
@@ -0,0 +1,24 @@
+package mem
+
+import "sync"
+
+type event struct {
+	topic string
+	data  []byte
+	once  sync.Once
+	acked chan struct{}
+}
+
+func (e *event) Ack() {
+	e.once.Do(func() {
+		close(e.acked)
+	})
+}
+
+func (e *event) Data() []byte {
+	return e.data
+}
+
+func (e *event) Topic() string {
+	return e.topic
+}
@@ -0,0 +1,238 @@
+package mem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newStore(t *testing.T, b *Broker, serviceName string, options ...Option) axon.EventStore {
+	store, err := Init(axon.Options{ServiceName: serviceName}, append([]Option{WithBroker(b)}, options...)...)
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func receive(t *testing.T, events <-chan axon.Event) axon.Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	b := NewBroker()
+	publisher := newStore(t, b, "publisher")
+	orders := newStore(t, b, "orders")
+	billing := newStore(t, b, "billing")
+
+	orderEvents, billingEvents := make(chan axon.Event, 1), make(chan axon.Event, 1)
+	go func() { _ = orders.Subscribe("order.created", func(e axon.Event) { orderEvents <- e }) }()
+	go func() { _ = billing.Subscribe("order.created", func(e axon.Event) { billingEvents <- e }) }()
+	time.Sleep(50 * time.Millisecond)
+
+	require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+	for _, events := range []chan axon.Event{orderEvents, billingEvents} {
+		e := receive(t, events)
+		assert.Equal(t, "#1", string(e.Data()))
+		assert.Equal(t, "order.created", e.Topic())
+		e.Ack()
+	}
+}
+
+func TestQueueGroupLoadBalancing(t *testing.T) {
+	b := NewBroker()
+	publisher := newStore(t, b, "publisher")
+	first, second := newStore(t, b, "orders"), newStore(t, b, "orders")
+
+	var mu sync.Mutex
+	counts := map[string]int{}
+	done := make(chan struct{}, 10)
+	handler := func(name string) axon.SubscriptionHandler {
+		return func(e axon.Event) {
+			mu.Lock()
+			counts[name]++
+			mu.Unlock()
+			e.Ack()
+			done <- struct{}{}
+		}
+	}
+	go func() { _ = first.Subscribe("order.created", handler("first")) }()
+	go func() { _ = second.Subscribe("order.created", handler("second")) }()
+	time.Sleep(50 * time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, publisher.Publish("order.created", []byte("order")))
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 5, counts["first"])
+	assert.Equal(t, 5, counts["second"])
+}
+
+func TestRedeliveryAndDurableGroups(t *testing.T) {
+	b := NewBroker()
+	publisher := newStore(t, b, "publisher")
+	subscriber := newStore(t, b, "orders", AckWait(50*time.Millisecond))
+
+	events := make(chan axon.Event, 4)
+	go func() { _ = subscriber.Subscribe("order.created", func(e axon.Event) { events <- e }) }()
+	time.Sleep(50 * time.Millisecond)
+
+	require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+	first := receive(t, events)
+	redelivered := receive(t, events)
+	assert.Equal(t, first.Data(), redelivered.Data())
+	redelivered.Ack()
+
+	// Messages published while the group has no subscriber are kept for its next subscriber.
+	require.Nil(t, subscriber.Close())
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, publisher.Publish("order.created", []byte("#2")))
+
+	resumed := newStore(t, b, "orders")
+	go func() { _ = resumed.Subscribe("order.created", func(e axon.Event) { events <- e }) }()
+	e := receive(t, events)
+	assert.Equal(t, "#2", string(e.Data()))
+	e.Ack()
+}
+
+func TestConcurrency(t *testing.T) {
+	b := NewBroker()
+	publisher := newStore(t, b, "publisher")
+	store, err := Init(axon.Options{
+		ServiceName: "orders",
+		Topics:      map[string]axon.TopicOptions{"order.created": {Concurrency: 2}},
+	}, WithBroker(b))
+	require.Nil(t, err)
+	defer store.Close()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	running, peak, handled := 0, 0, 0
+	go func() {
+		_ = store.Subscribe("order.created", func(e axon.Event) {
+			mu.Lock()
+			if running++; running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			<-release
+			e.Ack()
+			mu.Lock()
+			running--
+			handled++
+			mu.Unlock()
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// Publish returns at once, while the handlers run two at a time.
+	for i := 0; i < 5; i++ {
+		require.Nil(t, publisher.Publish("order.created", []byte("order")))
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 2, running)
+	mu.Unlock()
+	close(release)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return handled == 5
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 2, peak)
+}
+
+func TestSubscribePattern(t *testing.T) {
+	b := NewBroker()
+	publisher := newStore(t, b, "publisher")
+	subscriber := newStore(t, b, "audit")
+
+	events := make(chan axon.Event, 2)
+	ps := subscriber.(axon.PatternSubscriber)
+	go func() {
+		_ = ps.SubscribePattern(regexp.MustCompile(`^order\.[^.]+$`), func(e axon.Event) { events <- e })
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	require.Nil(t, publisher.Publish("order.shipped", []byte("#1")))
+	require.Nil(t, publisher.Publish("payment.captured", []byte("#2")))
+	assert.Equal(t, "order.shipped", receive(t, events).Topic())
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event on %s", e.Topic())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	b := NewBroker()
+	caller := newStore(t, b, "caller")
+	replier := newStore(t, b, "greeter")
+
+	go func() {
+		_ = replier.ReplyContext("greet", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			var in struct{ Name string }
+			if err := req.ParsePayload(&in); err != nil {
+				return axon.Response{}, err
+			}
+			if in.Name == "" {
+				return axon.Response{}, errors.New("name is required")
+			}
+			if in.Name == "sleepy" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			out, _ := json.Marshal(map[string]string{"greeting": "hello " + in.Name, "from": req.ServiceName})
+			return axon.Response{Payload: out}, nil
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	var out map[string]string
+	require.Nil(t, caller.Request("greet", []byte(`{"Name":"axon"}`), &out))
+	assert.Equal(t, map[string]string{"greeting": "hello axon", "from": "caller"}, out)
+
+	err := caller.Request("greet", []byte(`{}`), &out)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "name is required")
+
+	err = caller.Request("greet", []byte(`{"Name":"sleepy"}`), &out, axon.WithTimeout(50*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.Equal(t, ErrNoReplier, caller.Request("nobody", []byte(`{}`), &out))
+}
+
+func TestClose(t *testing.T) {
+	store := newStore(t, NewBroker(), "orders")
+	assert.Nil(t, store.Health())
+	require.Nil(t, store.Close())
+	assert.Equal(t, axon.ErrCloseConn, store.Health())
+	assert.Equal(t, axon.ErrCloseConn, store.Publish("order.created", nil))
+	assert.Equal(t, axon.ErrCloseConn, store.Subscribe("order.created", func(axon.Event) {}))
+}
+
+func TestConformance(t *testing.T) {
+	b := NewBroker()
+	axontest.RunConformance(t, func(t *testing.T, opts axon.Options) axon.EventStore {
+		store, err := Init(opts, WithBroker(b))
+		require.Nil(t, err)
+		t.Cleanup(func() { _ = store.Close() })
+		return store
+	}, axontest.AckWait(200*time.Millisecond))
+}
@@ -0,0 +1,14 @@
+package mem
+
+import (
+	"github.com/Just4Ease/axon"
+	"net/url"
+)
+
+func init() {
+	axon.Register("mem", open)
+}
+
+func open(u *url.URL, opts axon.Options) (axon.EventStore, error) {
+	return Init(opts)
+}
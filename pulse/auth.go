@@ -0,0 +1,144 @@
+package pulse
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/Just4Ease/axon"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"net/url"
+)
+
+var ErrInvalidAuthConfiguration = errors.New("Sorry, you have provided an invalid authentication configuration")
+
+// authentication builds the Pulsar authentication provider from opts. At most one method may be configured,
+// a TLS client certificate included, which configureTLS checks.
+//
+// Token files, token suppliers and OAuth2 tokens are fetched again whenever the broker challenges the client,
+// so rotated or expired tokens are replaced without reconnecting.
+func authentication(opts axon.Options) (pulsar.Authentication, error) {
+	var methods []pulsar.Authentication
+	if opts.AuthenticationToken != "" {
+		methods = append(methods, pulsar.NewAuthenticationToken(opts.AuthenticationToken))
+	}
+
+	if auth := opts.Auth; auth != nil {
+		if auth.TokenFile != "" {
+			methods = append(methods, pulsar.NewAuthenticationTokenFromFile(auth.TokenFile))
+		}
+		if auth.TokenSupplier != nil {
+			methods = append(methods, pulsar.NewAuthenticationTokenFromSupplier(auth.TokenSupplier))
+		}
+		if auth.Username != "" {
+			methods = append(methods, &basicAuth{username: auth.Username, password: auth.Password})
+		}
+		if auth.OAuth2 != nil {
+			provider, err := oauth2Authentication(auth.OAuth2)
+			if err != nil {
+				return nil, err
+			}
+			methods = append(methods, provider)
+		}
+	}
+
+	switch len(methods) {
+	case 0:
+		return nil, nil
+	case 1:
+		return methods[0], nil
+	}
+	return nil, errors.Wrap(ErrInvalidAuthConfiguration, "pulsar accepts a single authentication method")
+}
+
+func oauth2Authentication(o *axon.OAuth2Options) (pulsar.Authentication, error) {
+	if o.KeyFile != "" {
+		params, err := json.Marshal(map[string]string{
+			"type":       "client_credentials",
+			"issuerUrl":  o.IssuerURL,
+			"audience":   o.Audience,
+			"privateKey": o.KeyFile,
+			"clientId":   o.ClientID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		provider, err := pulsar.NewAuthentication("oauth2", string(params))
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidAuthConfiguration, err.Error())
+		}
+		return provider, nil
+	}
+
+	if o.TokenURL == "" || o.ClientID == "" {
+		return nil, errors.Wrap(ErrInvalidAuthConfiguration, "oauth2 needs either a key file or a token url and client id")
+	}
+	config := clientcredentials.Config{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		TokenURL:     o.TokenURL,
+		Scopes:       o.Scopes,
+	}
+	if o.Audience != "" {
+		config.EndpointParams = url.Values{"audience": {o.Audience}}
+	}
+	return &oauth2Auth{source: config.TokenSource(context.Background())}, nil
+}
+
+// basicAuth authenticates with the user and password of Pulsar's basic authentication provider.
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (b *basicAuth) Init() error {
+	return nil
+}
+
+func (b *basicAuth) Name() string {
+	return "basic"
+}
+
+func (b *basicAuth) GetTLSCertificate() (*tls.Certificate, error) {
+	return nil, nil
+}
+
+func (b *basicAuth) GetData() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s:%s", b.username, b.password)), nil
+}
+
+func (b *basicAuth) Close() error {
+	return nil
+}
+
+// oauth2Auth sends access tokens from an OAuth2 token source, which fetches a new token once the current one expires.
+type oauth2Auth struct {
+	source oauth2.TokenSource
+}
+
+func (o *oauth2Auth) Init() error {
+	return nil
+}
+
+func (o *oauth2Auth) Name() string {
+	return "token"
+}
+
+func (o *oauth2Auth) GetTLSCertificate() (*tls.Certificate, error) {
+	return nil, nil
+}
+
+func (o *oauth2Auth) GetData() ([]byte, error) {
+	token, err := o.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(token.AccessToken), nil
+}
+
+func (o *oauth2Auth) Close() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+package pulse
+
+import (
+	"fmt"
+	"github.com/Just4Ease/axon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+type authProvider interface {
+	Name() string
+	GetData() ([]byte, error)
+}
+
+func provider(t *testing.T, opts axon.Options) authProvider {
+	auth, err := authentication(opts)
+	require.Nil(t, err)
+	p, ok := auth.(authProvider)
+	require.True(t, ok, "unexpected authentication provider %T", auth)
+	return p
+}
+
+func TestAuthentication_TokenFileIsReloaded(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.Nil(t, ioutil.WriteFile(tokenFile, []byte("first"), 0600))
+
+	p := provider(t, axon.Options{Auth: &axon.AuthOptions{TokenFile: tokenFile}})
+	data, err := p.GetData()
+	assert.Nil(t, err)
+	assert.Equal(t, "first", string(data))
+
+	require.Nil(t, ioutil.WriteFile(tokenFile, []byte("rotated"), 0600))
+	data, err = p.GetData()
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated", string(data))
+}
+
+func TestAuthentication_TokenSupplier(t *testing.T) {
+	calls := 0
+	p := provider(t, axon.Options{Auth: &axon.AuthOptions{TokenSupplier: func() (string, error) {
+		calls++
+		return fmt.Sprintf("token-%d", calls), nil
+	}}})
+
+	first, _ := p.GetData()
+	second, _ := p.GetData()
+	assert.Equal(t, "token-1", string(first))
+	assert.Equal(t, "token-2", string(second))
+}
+
+func TestAuthentication_Basic(t *testing.T) {
+	p := provider(t, axon.Options{Auth: &axon.AuthOptions{Username: "axon", Password: "pa55"}})
+	data, err := p.GetData()
+	assert.Nil(t, err)
+	assert.Equal(t, "basic", p.Name())
+	assert.Equal(t, "axon:pa55", string(data))
+}
+
+func TestAuthentication_OAuth2ClientCredentials(t *testing.T) {
+	var issued int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.Form.Get("grant_type"))
+		assert.Equal(t, "urn:pulsar", r.Form.Get("audience"))
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		// Tokens expiring within a few seconds are refreshed by the token source on the next use.
+		_, _ = fmt.Fprintf(w, `{"access_token":"jwt-%d","token_type":"bearer","expires_in":1}`, n)
+	}))
+	defer server.Close()
+
+	p := provider(t, axon.Options{Auth: &axon.AuthOptions{OAuth2: &axon.OAuth2Options{
+		TokenURL:     server.URL,
+		ClientID:     "orders",
+		ClientSecret: "secret",
+		Audience:     "urn:pulsar",
+	}}})
+
+	first, err := p.GetData()
+	assert.Nil(t, err)
+	second, err := p.GetData()
+	assert.Nil(t, err)
+	assert.Equal(t, "token", p.Name())
+	assert.Equal(t, "jwt-1", string(first))
+	assert.Equal(t, "jwt-2", string(second))
+}
+
+func TestAuthentication_Invalid(t *testing.T) {
+	_, err := authentication(axon.Options{
+		AuthenticationToken: "static",
+		Auth:                &axon.AuthOptions{Username: "axon"},
+	})
+	assert.NotNil(t, err)
+
+	_, err = authentication(axon.Options{Auth: &axon.AuthOptions{OAuth2: &axon.OAuth2Options{}}})
+	assert.NotNil(t, err)
+
+	auth, err := authentication(axon.Options{})
+	assert.Nil(t, err)
+	assert.Nil(t, auth)
+}
@@ -1,26 +1,48 @@
 package pulse
 
 import (
-	"bytes"
 	"context"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/Just4Ease/axon"
 	"github.com/apache/pulsar-client-go/pulsar"
-	"io/ioutil"
+	"hash/fnv"
 	"log"
 	"math/rand"
-	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
 
+const (
+	defaultNamespace       = "persistent://public/default/"
+	patternDiscoveryPeriod = time.Minute
+	replayRetryDelay       = 100 * time.Millisecond
+	maxReplayRetryDelay    = 5 * time.Second
+)
+
 type pulsarStore struct {
+	*axon.StateTracker
 	serviceName string
+	opts        axon.Options
 	client      Client
+	certPath    string // Temporary CA file removed on Close.
+	compression pulsar.CompressionType
+	level       pulsar.CompressionLevel
+	ctx         context.Context // Done once the store is closed.
+	cancel      context.CancelFunc
+}
+
+type Option func(*pulsarStore)
+
+// Compression makes producers compress the messages they send with Pulsar's own compression, LZ4, ZLib or ZSTD,
+// which consumers undo without configuration.
+func Compression(compression pulsar.CompressionType, level pulsar.CompressionLevel) Option {
+	return func(s *pulsarStore) {
+		s.compression = compression
+		s.level = level
+	}
 }
 
 type Client interface {
@@ -49,45 +71,41 @@ type Consumer interface {
 }
 
 func (s *pulsarStore) Reply(topic string, handler axon.ReplyHandler) error {
+	return s.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+func (s *pulsarStore) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
 	serviceName := s.GetServiceName()
-	var consumer Consumer
-	var err error
-	if consumer, err = s.client.Subscribe(pulsar.ConsumerOptions{
+	consumer, err := s.client.Subscribe(pulsar.ConsumerOptions{
 		Topic:                       topic,
 		AutoDiscoveryPeriod:         0,
 		SubscriptionName:            fmt.Sprintf("%s-%s", serviceName, topic),
 		Type:                        pulsar.Shared,
 		SubscriptionInitialPosition: pulsar.SubscriptionPositionLatest,
 		Name:                        serviceName,
-	}); err != nil {
+	})
+	s.track(err)
+	if err != nil {
 		return fmt.Errorf("error subscribing to topic. %v", err)
 	}
 
 	defer consumer.Close()
 	for {
 		message, err := consumer.Recv(context.Background())
+		if err == axon.ErrCloseConn || s.State() == axon.StateClosed {
+			return axon.ErrCloseConn
+		}
+		s.track(err)
 		if err != nil {
-			if err == axon.ErrCloseConn {
-				break
-			}
 			continue
 		}
 
 		event := NewEvent(message, consumer)
 		go func(event axon.Event) {
-			var reqPl axon.RequestPayload
-			decoder := json.NewDecoder(bytes.NewBuffer(event.Data()))
-			decoder.UseNumber()
-			if err := decoder.Decode(&reqPl); err != nil {
-				log.Print("failed to decode incoming request payload []bytes with the following error: ", err)
-				return
-			}
 			// Execute Handler
-			handlerPayload, handlerError := handler(reqPl.GetPayload())
-			replyPayload := axon.NewReply(handlerPayload, handlerError)
-			data, err := replyPayload.Compact()
+			reqPl, data, err := axon.ServeRequest(topic, event.Data(), handler)
 			if err != nil {
-				log.Print("failed to encode reply payload into []bytes with the following error: ", err)
+				log.Print("failed to handle incoming request payload with the following error: ", err)
 				return
 			}
 
@@ -99,15 +117,26 @@ func (s *pulsarStore) Reply(topic string, handler axon.ReplyHandler) error {
 			event.Ack()
 		}(event)
 	}
-	return nil
 }
 
-func (s *pulsarStore) Request(topic string, message []byte, v interface{}) error {
-	errChan := make(chan error)
-	eventChan := make(chan axon.Event)
-	req := axon.NewRequestPayload(topic, message)
+func (s *pulsarStore) Request(topic string, message []byte, v interface{}, opts ...axon.RequestOption) error {
+	errChan := make(chan error, 2)
+	eventChan := make(chan axon.Event, 1)
+	if timeout := s.opts.Topic(topic).RequestTimeout; timeout > 0 {
+		opts = append([]axon.RequestOption{axon.WithTimeout(timeout)}, opts...)
+	}
+	req := axon.NewRequestPayload(topic, message, opts...)
 
 	serviceName := s.GetServiceName()
+	req.ServiceName = serviceName
+
+	ctx := context.Background()
+	if deadline, ok := req.GetDeadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
 	consumer, err := s.client.Subscribe(pulsar.ConsumerOptions{
 		Topic:                       req.ReplyPipe,
 		AutoDiscoveryPeriod:         0,
@@ -124,7 +153,7 @@ func (s *pulsarStore) Request(topic string, message []byte, v interface{}) error
 
 	go func(errChan chan<- error, eventChan chan<- axon.Event, consumer Consumer) {
 		for {
-			message, err := consumer.Recv(context.Background())
+			message, err := consumer.Recv(ctx)
 			if err == axon.ErrCloseConn {
 				errChan <- axon.ErrCloseConn
 				break
@@ -161,9 +190,7 @@ func (s *pulsarStore) Request(topic string, message []byte, v interface{}) error
 		case err := <-errChan:
 			log.Print("failed to receive reply-response with the following errors: ", err)
 			return err
-		default:
-			event := <-eventChan
-
+		case event := <-eventChan:
 			// This is the ReplyPayload
 			var reply axon.ReplyPayload
 			if err := json.Unmarshal(event.Data(), &reply); err != nil {
@@ -172,6 +199,8 @@ func (s *pulsarStore) Request(topic string, message []byte, v interface{}) error
 				return err
 			}
 
+			req.SetReplyHeaders(reply.Headers)
+
 			// Check if reply has an issue.
 			if replyErr := reply.GetError(); replyErr != nil {
 				event.Ack()
@@ -194,14 +223,123 @@ func (s *pulsarStore) Request(topic string, message []byte, v interface{}) error
 // Manually put the fqdn of your topics.
 func (s *pulsarStore) Subscribe(topic string, handler axon.SubscriptionHandler) error {
 	serviceName := s.GetServiceName()
-	consumer, err := s.client.Subscribe(pulsar.ConsumerOptions{
+	return s.consume(pulsar.ConsumerOptions{
 		Topic:                       topic,
 		AutoDiscoveryPeriod:         0,
 		SubscriptionName:            fmt.Sprintf("%s-%s", serviceName, topic),
 		Type:                        pulsar.Shared,
 		SubscriptionInitialPosition: pulsar.SubscriptionPositionLatest,
 		Name:                        serviceName,
-	})
+	}, axon.NewLimiter(s.opts.Topic(topic).Concurrency), handler)
+}
+
+// SubscribePattern subscribes to every topic of the namespace whose name matches pattern, using Pulsar's
+// TopicsPattern consumer. Patterns without a namespace are resolved against persistent://public/default.
+func (s *pulsarStore) SubscribePattern(pattern *regexp.Regexp, handler axon.SubscriptionHandler) error {
+	serviceName := s.GetServiceName()
+	expr := strings.TrimPrefix(pattern.String(), "^")
+	if !strings.Contains(expr, "://") {
+		expr = defaultNamespace + expr
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(expr))
+	return s.consume(pulsar.ConsumerOptions{
+		TopicsPattern:               expr,
+		AutoDiscoveryPeriod:         patternDiscoveryPeriod,
+		SubscriptionName:            fmt.Sprintf("%s-pattern-%x", serviceName, h.Sum32()),
+		Type:                        pulsar.Shared,
+		SubscriptionInitialPosition: pulsar.SubscriptionPositionLatest,
+		Name:                        serviceName,
+	}, axon.NewLimiter(s.opts.Concurrency), handler)
+}
+
+// messageIDDeserializer is implemented by clients with their own message IDs, such as the pulsetest Broker's.
+type messageIDDeserializer interface {
+	DeserializeMessageID(data []byte) (pulsar.MessageID, error)
+}
+
+// Replay reads topic again with a Pulsar reader, from axon.Earliest, the serialized message ID of axon.AtMessageID,
+// the publish time of axon.AtTime or axon.Latest, and follows the messages published afterwards until the store is
+// closed. Readers hold no subscription, so the subscriptions of the service are unaffected.
+func (s *pulsarStore) Replay(topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	return s.ReplayContext(context.Background(), topic, from, handler)
+}
+
+func (s *pulsarStore) ReplayContext(ctx context.Context, topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	options := pulsar.ReaderOptions{Topic: topic, StartMessageID: pulsar.EarliestMessageID(), StartMessageIDInclusive: true}
+	switch from.Kind {
+	case axon.PositionEarliest, axon.PositionTime:
+	case axon.PositionLatest:
+		options.StartMessageID, options.StartMessageIDInclusive = pulsar.LatestMessageID(), false
+	case axon.PositionMessageID:
+		var err error
+		if d, ok := s.client.(messageIDDeserializer); ok {
+			options.StartMessageID, err = d.DeserializeMessageID(from.MessageID)
+		} else {
+			options.StartMessageID, err = pulsar.DeserializeMessageID(from.MessageID)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid message id to replay %s from. %v", topic, err)
+		}
+	default:
+		return from.Unsupported()
+	}
+
+	reader, err := s.client.CreateReader(options)
+	s.track(err)
+	if err != nil {
+		return fmt.Errorf("error creating a reader on topic. %v", err)
+	}
+	defer reader.Close()
+	if from.Kind == axon.PositionTime {
+		if err := reader.SeekByTime(from.Time); err != nil {
+			return fmt.Errorf("error seeking topic %s to %s. %v", topic, from.Time, err)
+		}
+	}
+
+	// Readers only return from Next once their context is done, so closing the store must cancel it too.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	delay := replayRetryDelay
+	for {
+		message, err := reader.Next(ctx)
+		if err == axon.ErrCloseConn || s.ctx.Err() != nil {
+			return axon.ErrCloseConn
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.track(err)
+		if err != nil {
+			log.Printf("failed to read %s to replay it with the following error: %v", topic, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > maxReplayRetryDelay {
+				delay = maxReplayRetryDelay
+			}
+			continue
+		}
+		delay = replayRetryDelay
+		handler(NewEvent(message, nil))
+	}
+}
+
+// consume subscribes with options and hands the messages received to handler through limiter, so no more are
+// received while the handlers are busy.
+func (s *pulsarStore) consume(options pulsar.ConsumerOptions, limiter *axon.Limiter, handler axon.SubscriptionHandler) error {
+	consumer, err := s.client.Subscribe(options)
+	s.track(err)
 	if err != nil {
 		return fmt.Errorf("error subscribing to topic. %v", err)
 	}
@@ -209,21 +347,20 @@ func (s *pulsarStore) Subscribe(topic string, handler axon.SubscriptionHandler)
 	defer consumer.Close()
 	for {
 		message, err := consumer.Recv(context.Background())
-		if err == axon.ErrCloseConn {
-			break
+		if err == axon.ErrCloseConn || s.State() == axon.StateClosed {
+			return axon.ErrCloseConn
 		}
+		s.track(err)
 		if err != nil {
 			continue
 		}
 
-		event := NewEvent(message, consumer)
-		go handler(event)
+		limiter.Go(handler, NewEvent(message, consumer))
 	}
-	return nil
 }
 
 // Note: If you need a more controlled init func, write your pulsar lib to implement the EventStore interface.
-func Init(opts axon.Options) (axon.EventStore, error) {
+func Init(opts axon.Options, options ...Option) (axon.EventStore, error) {
 	addr := strings.TrimSpace(opts.Address)
 	if addr == "" {
 		return nil, axon.ErrInvalidURL
@@ -234,29 +371,65 @@ func Init(opts axon.Options) (axon.EventStore, error) {
 		return nil, axon.ErrEmptyStoreName
 	}
 
-	clientOptions := pulsar.ClientOptions{URL: addr}
-	if opts.CertContent != "" {
-		certPath, err := initCert(opts.CertContent)
-		if err != nil {
-			return nil, err
-		}
-		clientOptions.TLSAllowInsecureConnection = true
-		clientOptions.TLSTrustCertsFilePath = certPath
+	auth, err := authentication(opts)
+	if err != nil {
+		return nil, err
 	}
-	if opts.AuthenticationToken != "" {
-		clientOptions.Authentication = pulsar.NewAuthenticationToken(opts.AuthenticationToken)
+	clientOptions := pulsar.ClientOptions{URL: addr, Authentication: auth}
+	certPath, err := configureTLS(&clientOptions, opts.ResolvedTLS())
+	if err != nil {
+		return nil, err
 	}
 
 	rand.Seed(time.Now().UnixNano())
 	p, err := pulsar.NewClient(clientOptions)
 	if err != nil {
+		removeCert(certPath)
 		return nil, fmt.Errorf("unable to connect with Pulsar with provided configuration. failed with error: %v", err)
 	}
-	return &pulsarStore{client: newClientWrapper(p), serviceName: name}, nil
+	opts.ServiceName = name
+	s := newStore(newClientWrapper(p), opts, options...)
+	s.certPath = certPath
+	return s, nil
+}
+
+// InitTestEventStore returns a store for serviceName using mockClient, such as a client of a pulsetest Broker.
+func InitTestEventStore(mockClient Client, serviceName string, options ...Option) (axon.EventStore, error) {
+	return newStore(mockClient, axon.Options{ServiceName: serviceName}, options...), nil
+}
+
+func newStore(client Client, opts axon.Options, options ...Option) *pulsarStore {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &pulsarStore{
+		StateTracker: axon.NewStateTracker(axon.StateConnected),
+		client:       client,
+		serviceName:  opts.ServiceName,
+		opts:         opts,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Close closes the underlying Pulsar client. Blocked subscriptions return once their consumers are closed.
+func (s *pulsarStore) Close() error {
+	s.cancel()
+	s.SetState(axon.StateClosed)
+	s.client.Close()
+	removeCert(s.certPath)
+	return nil
 }
 
-func InitTestEventStore(mockClient Client, serviceName string) (axon.EventStore, error) {
-	return &pulsarStore{client: mockClient, serviceName: serviceName}, nil
+// track records the outcome of a producer or consumer operation as the store's connection state.
+func (s *pulsarStore) track(err error) {
+	if err != nil {
+		s.SetState(axon.StateDisconnected)
+		return
+	}
+	s.SetState(axon.StateConnected)
 }
 
 func (s *pulsarStore) GetServiceName() string {
@@ -266,9 +439,12 @@ func (s *pulsarStore) GetServiceName() string {
 func (s *pulsarStore) Publish(topic string, message []byte) error {
 	sn := s.GetServiceName()
 	producer, err := s.client.CreateProducer(pulsar.ProducerOptions{
-		Topic: topic,
-		Name:  fmt.Sprintf("%s-producer-%s", sn, generateRandomName()), // the servicename-producer-randomstring
+		Topic:            topic,
+		Name:             fmt.Sprintf("%s-producer-%s", sn, generateRandomName()), // the servicename-producer-randomstring
+		CompressionType:  s.compression,
+		CompressionLevel: s.level,
 	})
+	s.track(err)
 	if err != nil {
 		return fmt.Errorf("failed to create new producer with the following error: %v", err)
 	}
@@ -277,6 +453,7 @@ func (s *pulsarStore) Publish(topic string, message []byte) error {
 	defer producer.Close()
 
 	id, err := producer.Send(context.Background(), message)
+	s.track(err)
 	if err != nil {
 		return fmt.Errorf("failed to send message. %v", err)
 	}
@@ -306,19 +483,3 @@ func generateRandomName() string {
 	}
 	return string(bytes)
 }
-
-func initCert(content string) (string, error) {
-	if len(content) == 0 {
-		return "", errors.New("cert content is empty")
-	}
-
-	pwd, err := os.Getwd()
-	if err != nil {
-		return "", err
-	}
-	certPath := filepath.Join(pwd, "tls.crt")
-	if err := ioutil.WriteFile(certPath, []byte(content), os.ModePerm); err != nil {
-		return "", err
-	}
-	return certPath, nil
-}
@@ -2,6 +2,7 @@ package pulse
 
 import (
 	"github.com/Just4Ease/axon"
+	"strings"
 )
 
 type event struct {
@@ -9,6 +10,7 @@ type event struct {
 	consumer Consumer
 }
 
+// NewEvent wraps a message received by consumer. Events of a nil consumer, read by a replay, are not acknowledged.
 func NewEvent(message Message, consumer Consumer) axon.Event {
 	return &event{raw: message, consumer: consumer}
 }
@@ -18,11 +20,17 @@ func (e *event) Data() []byte {
 }
 
 func (e *event) Topic() string {
-	t := e.raw.Topic()
-	// Manually agree what we want the topic to look like from pulsar.
-	return t
+	// Topics of the default namespace are named as they were published, like on the other backends.
+	return strings.TrimPrefix(e.raw.Topic(), defaultNamespace)
 }
 
 func (e *event) Ack() {
-	e.consumer.Ack(e.raw.ID())
+	if e.consumer != nil {
+		e.consumer.Ack(e.raw.ID())
+	}
+}
+
+// Position returns the serialized message ID of the event, to replay its topic from.
+func (e *event) Position() axon.Position {
+	return axon.AtMessageID(e.raw.ID().Serialize())
 }
@@ -3,17 +3,14 @@ package pulse
 import (
 	"context"
 	"github.com/Just4Ease/axon"
+	"github.com/apache/pulsar-client-go/pulsar"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
 	"testing"
 	"time"
 )
 
-var topic = "test" // for random topic name.
-var store, _ = Init(axon.Options{
-	ServiceName: "test-service",
-	Address:     "pulsar+ssl://localhost:6650",
-})
-
 const cert = `-----BEGIN CERTIFICATE-----
 MIIDQsdDCCAiigAwIBAgIQFmNdB6eBqmhjHzmFqeOHANBgkqhkiG9w0BAQsFADAP
 MRUwEwYDVQQKEwxjZXJ0LW1hbmFnZXIxITAfBgNVBAMTGHB1bHNhci5zdmMuY2x1
@@ -36,59 +33,25 @@ $NomZDoquhKWaSpVP/zk8vuQsVOMAy28BG0JBHFqTyCdS5+05oAZievjJGh2EaYL
 -----END CERTIFICATE-----`
 
 func TestInit(t *testing.T) {
-	addrs := "pulsar+ssl://localhost:6651"
-	opts := axon.Options{
+	options := axon.Options{
 		ServiceName:         "test-service",
-		Address:             addrs,
+		Address:             "pulsar+ssl://localhost:6651",
 		CertContent:         cert,
 		AuthenticationToken: "tyJ3bGciOiJIUzI1NiJ9.eyJzdWIi9iJhZG1pbiJ9.vGEsDKZNolLbP7PWlhzzAZMaO4MrsswkDf9eMb6S8M5",
 	}
-	store, err := Init(opts)
-	assert.Nil(t, err)
-	go func() {
-		time.Sleep(4 * time.Second)
-		err = store.Publish("test-topic", []byte("Hello, pulsar"))
-	}()
+	_, err := Init(options)
+	assert.ErrorIs(t, err, axon.ErrInvalidTlsConfiguration, "cert is a corrupt PEM")
 
-	c := make(chan struct{}, 1)
-	go func() {
-		err = store.Subscribe(topic, func(event axon.Event) {
-			t.Log(string(event.Data()))
-			assert.Equal(t, string(event.Data()), "Hello, pulsar")
-			c <- struct{}{}
-		})
-	}()
+	options.CertContent, _ = clientCert(t)
+	store, err := Init(options)
+	require.Nil(t, err, "the client connects lazily")
+	assert.Equal(t, "test-service", store.GetServiceName())
+	require.Nil(t, store.Close())
 
-	<-c
-}
-
-func TestStore_Publish(t *testing.T) {
-	if err := store.Publish(topic, []byte("Hello World!")); err != nil {
-		t.Errorf("Failed to publish data to event store topic %s. Failed with error: %v", topic, err)
-	}
-}
-
-func TestStore_Subscribe(t *testing.T) {
-	timer := time.AfterFunc(3*time.Second, func() {
-		if err := store.Subscribe(topic, func(event axon.Event) {
-			data := event.Data()
-
-			eventTopic := event.Topic()
-
-			if topic != eventTopic {
-				t.Errorf("Event topic is not the same as subscription topic. Why?: Expected %s, instead got: %s \n", topic, eventTopic)
-				return
-			}
-
-			t.Logf("Received data: %s on topic: %s \n", string(data), eventTopic)
-			event.Ack() // Acknowledge event.
-		}); err != nil {
-			t.Errorf("Failed to subscribe to topic: %s, with the following error: %v \n", topic, err)
-			return
-		}
-	})
-
-	defer timer.Stop()
+	_, err = Init(axon.Options{ServiceName: "test-service"})
+	assert.Equal(t, axon.ErrInvalidURL, err)
+	_, err = Init(axon.Options{Address: "pulsar://localhost:6650"})
+	assert.Equal(t, axon.ErrEmptyStoreName, err)
 }
 
 func TestPulsarStore_Run(t *testing.T) {
@@ -96,24 +59,52 @@ func TestPulsarStore_Run(t *testing.T) {
 	store, _ := InitTestEventStore(nil, "svc")
 
 	now := time.Now()
-	// cancel after 3secs
-	time.AfterFunc(3*time.Second, func() {
-		t.Log("cancelling...")
-		cancel()
-	})
+	time.AfterFunc(100*time.Millisecond, cancel)
+	ran := make(chan struct{}, 2)
 	store.Run(ctx, func() error {
-		t.Log("first function")
+		ran <- struct{}{}
 		return nil
 	}, func() error {
-		t.Log("second function ")
+		ran <- struct{}{}
 		return nil
 	})
-	interval := time.Since(now)
-	if interval.Seconds() < 3 {
-		t.Fail()
+	assert.GreaterOrEqual(t, time.Since(now), 100*time.Millisecond)
+	receiveRun := func() {
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("handler did not run")
+		}
 	}
+	receiveRun()
+	receiveRun()
 }
 
 func Test_generateRandomName(t *testing.T) {
-	t.Log(generateRandomName())
+	name := generateRandomName()
+	assert.Len(t, name, 10)
+	assert.Regexp(t, "^[a-z]+$", name)
+}
+
+func TestURLOptions(t *testing.T) {
+	options, err := urlOptions(url.Values{"compression": {"zstd"}, "compression_level": {"better"}})
+	require.Nil(t, err)
+	store := newStore(nil, axon.Options{ServiceName: "orders"}, options...)
+	assert.Equal(t, pulsar.ZSTD, store.compression)
+	assert.Equal(t, pulsar.Better, store.level)
+
+	options, err = urlOptions(url.Values{"compression": {"lz4"}})
+	require.Nil(t, err)
+	assert.Len(t, options, 1)
+
+	options, err = urlOptions(url.Values{})
+	require.Nil(t, err)
+	assert.Empty(t, options)
+
+	_, err = urlOptions(url.Values{"compression": {"snappy"}})
+	assert.ErrorIs(t, err, axon.ErrInvalidURL)
+	_, err = urlOptions(url.Values{"compression": {"zlib"}, "compression_level": {"max"}})
+	assert.ErrorIs(t, err, axon.ErrInvalidURL)
+	_, err = urlOptions(url.Values{"compression_level": {"faster"}})
+	assert.ErrorIs(t, err, axon.ErrInvalidURL)
 }
@@ -0,0 +1,617 @@
+// Package pulsetest provides an in-memory Pulsar broker, to test the pulse store without a cluster.
+package pulsetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/pulse"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConsumerBusy = errors.New("Sorry, an exclusive consumer is already connected to this subscription")
+	ErrInvalidTopic = errors.New("Sorry, a topic, topics or topics pattern is required")
+	ErrNoSubscriber = errors.New("Sorry, a subscription name is required")
+	ErrUnknownID    = errors.New("Sorry, the message id was not issued by the test broker")
+)
+
+const defaultNamespace = "persistent://public/default/"
+
+// Broker is an in-memory stand-in for a Pulsar cluster, for tests that should not need a broker. Clients
+// created from the same Broker see the same topics and subscriptions:
+//
+//   - Topics keep every message published. Names without a namespace belong to persistent://public/default.
+//   - Subscriptions are durable and start from SubscriptionInitialPosition when first created. Shared and
+//     KeyShared subscriptions deliver each message to one of their consumers; Exclusive ones reject a second
+//     consumer; Failover ones deliver to their oldest consumer only.
+//   - Messages a consumer did not acknowledge are delivered again once it closes, and after the ack timeout
+//     when AckTimeout is set.
+//   - TopicsPattern consumers also receive the topics created afterwards, from their first message.
+//   - Readers start from the earliest, the latest or a given message.
+type Broker struct {
+	mu         sync.Mutex
+	topics     map[string]*fakeTopic
+	patterns   []*fakeConsumer
+	changed    chan struct{} // Closed and replaced whenever a consumer or reader may make progress.
+	ackTimeout time.Duration
+}
+
+type Option func(*Broker)
+
+// AckTimeout delivers again the messages left unacknowledged for longer than d.
+func AckTimeout(d time.Duration) Option {
+	return func(b *Broker) {
+		b.ackTimeout = d
+	}
+}
+
+// NewBroker returns a broker without topics.
+func NewBroker(options ...Option) *Broker {
+	b := &Broker{topics: make(map[string]*fakeTopic), changed: make(chan struct{})}
+	for _, option := range options {
+		option(b)
+	}
+	return b
+}
+
+// Client returns a new client of the broker. Closing it closes its consumers and readers only.
+func (b *Broker) Client() pulse.Client {
+	return &fakeClient{broker: b}
+}
+
+// Messages returns the payloads published on topic so far.
+func (b *Broker) Messages(topic string) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out [][]byte
+	if t, ok := b.topics[fullTopicName(topic)]; ok {
+		for _, msg := range t.messages {
+			out = append(out, msg.payload)
+		}
+	}
+	return out
+}
+
+// Unacked returns the number of messages of topic delivered to subscription and not acknowledged yet.
+func (b *Broker) Unacked(topic, subscription string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[fullTopicName(topic)]; ok {
+		if s, ok := t.subscriptions[subscription]; ok {
+			return len(s.unacked) + len(s.redeliver)
+		}
+	}
+	return 0
+}
+
+// Consumers returns the number of consumers connected to subscription on topic, or to any of its subscriptions
+// when subscription is empty.
+func (b *Broker) Consumers(topic, subscription string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	if t, ok := b.topics[fullTopicName(topic)]; ok {
+		for name, s := range t.subscriptions {
+			if subscription == "" || name == subscription {
+				n += len(s.consumers)
+			}
+		}
+	}
+	return n
+}
+
+func fullTopicName(topic string) string {
+	if strings.Contains(topic, "://") {
+		return topic
+	}
+	return defaultNamespace + topic
+}
+
+// notify wakes up the consumers and readers waiting for messages. The caller holds b.mu.
+func (b *Broker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// topic returns the topic named name, creating it along with the subscriptions of the matching pattern
+// consumers. The caller holds b.mu.
+func (b *Broker) topic(name string) *fakeTopic {
+	name = fullTopicName(name)
+	t, ok := b.topics[name]
+	if !ok {
+		t = &fakeTopic{name: name, subscriptions: make(map[string]*fakeSubscription)}
+		b.topics[name] = t
+		for _, c := range b.patterns {
+			if !c.closed && c.pattern.MatchString(name) {
+				_ = c.join(t, pulsar.SubscriptionPositionEarliest)
+			}
+		}
+	}
+	return t
+}
+
+// wait blocks until the broker changes, ctx is done or, with an ack timeout, it is time to check for expired
+// deliveries. The caller holds b.mu, which is released while waiting.
+func (b *Broker) wait(ctx context.Context) error {
+	changed := b.changed
+	b.mu.Unlock()
+	defer b.mu.Lock()
+
+	var expired <-chan time.Time
+	if b.ackTimeout > 0 {
+		timer := time.NewTimer(b.ackTimeout / 2)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-changed:
+	case <-expired:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+type fakeTopic struct {
+	name          string
+	messages      []*fakeMessage
+	subscriptions map[string]*fakeSubscription
+}
+
+type fakeSubscription struct {
+	topic       *fakeTopic
+	name        string
+	typ         pulsar.SubscriptionType
+	cursor      int     // Index of the next message never delivered.
+	redeliver   []int64 // Entries to deliver again, in order.
+	unacked     map[int64]*fakeDelivery
+	redelivered map[int64]uint32
+	consumers   []*fakeConsumer
+	turn        int // Consumer of a shared subscription to deliver the next message to, while it is waiting.
+}
+
+type fakeDelivery struct {
+	consumer *fakeConsumer
+	at       time.Time
+}
+
+// take returns the next message of the subscription for c, or nil when there is none or c may not receive.
+func (s *fakeSubscription) take(c *fakeConsumer, ackTimeout time.Duration) *fakeMessage {
+	if len(s.consumers) == 0 || (s.typ == pulsar.Exclusive || s.typ == pulsar.Failover) && s.consumers[0] != c {
+		return nil
+	}
+	// Shared subscriptions dispatch round-robin like Pulsar, rather than to whichever consumer wakes up first.
+	if turn := s.consumers[s.turn%len(s.consumers)]; turn != c && turn.waiting {
+		return nil
+	}
+
+	if ackTimeout > 0 {
+		for entry, d := range s.unacked {
+			if time.Since(d.at) >= ackTimeout {
+				s.requeue(entry)
+			}
+		}
+	}
+
+	var entry int64
+	switch {
+	case len(s.redeliver) > 0:
+		entry, s.redeliver = s.redeliver[0], s.redeliver[1:]
+		s.redelivered[entry]++
+	case s.cursor < len(s.topic.messages):
+		entry = int64(s.cursor)
+		s.cursor++
+	default:
+		return nil
+	}
+
+	for i, consumer := range s.consumers {
+		if consumer == c {
+			s.turn = i + 1
+		}
+	}
+	s.unacked[entry] = &fakeDelivery{consumer: c, at: time.Now()}
+	msg := *s.topic.messages[entry]
+	msg.redeliveryCount = s.redelivered[entry]
+	return &msg
+}
+
+// requeue moves an unacknowledged entry back to the messages to deliver.
+func (s *fakeSubscription) requeue(entry int64) {
+	delete(s.unacked, entry)
+	i := sort.Search(len(s.redeliver), func(i int) bool { return s.redeliver[i] >= entry })
+	s.redeliver = append(s.redeliver, 0)
+	copy(s.redeliver[i+1:], s.redeliver[i:])
+	s.redeliver[i] = entry
+}
+
+type fakeClient struct {
+	broker    *Broker
+	consumers []*fakeConsumer
+	readers   []*fakeReader
+	closed    bool
+}
+
+func (c *fakeClient) CreateProducer(opts pulsar.ProducerOptions) (pulse.Producer, error) {
+	if opts.Topic == "" {
+		return nil, ErrInvalidTopic
+	}
+
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil, axon.ErrCloseConn
+	}
+	return &fakeProducer{client: c, topic: fullTopicName(opts.Topic), name: opts.Name}, nil
+}
+
+func (c *fakeClient) Subscribe(opts pulsar.ConsumerOptions) (pulse.Consumer, error) {
+	if opts.SubscriptionName == "" {
+		return nil, ErrNoSubscriber
+	}
+
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil, axon.ErrCloseConn
+	}
+
+	consumer := &fakeConsumer{client: c, name: opts.SubscriptionName, typ: opts.Type}
+	switch {
+	case opts.TopicsPattern != "":
+		pattern, err := regexp.Compile(opts.TopicsPattern)
+		if err != nil {
+			return nil, err
+		}
+		consumer.pattern = pattern
+		for name, t := range b.topics {
+			if pattern.MatchString(name) {
+				if err := consumer.join(t, opts.SubscriptionInitialPosition); err != nil {
+					consumer.leave()
+					return nil, err
+				}
+			}
+		}
+		b.patterns = append(b.patterns, consumer)
+	case opts.Topic != "" || len(opts.Topics) > 0:
+		topics := opts.Topics
+		if opts.Topic != "" {
+			topics = append([]string{opts.Topic}, topics...)
+		}
+		for _, topic := range topics {
+			if err := consumer.join(b.topic(topic), opts.SubscriptionInitialPosition); err != nil {
+				consumer.leave()
+				return nil, err
+			}
+		}
+	default:
+		return nil, ErrInvalidTopic
+	}
+
+	c.consumers = append(c.consumers, consumer)
+	return consumer, nil
+}
+
+func (c *fakeClient) CreateReader(opts pulsar.ReaderOptions) (pulsar.Reader, error) {
+	if opts.Topic == "" {
+		return nil, ErrInvalidTopic
+	}
+
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil, axon.ErrCloseConn
+	}
+
+	r := &fakeReader{client: c, topic: b.topic(opts.Topic)}
+	if err := r.seek(opts.StartMessageID, opts.StartMessageIDInclusive); err != nil {
+		return nil, err
+	}
+	c.readers = append(c.readers, r)
+	return r, nil
+}
+
+// DeserializeMessageID reads the IDs of the broker's messages, which Pulsar's DeserializeMessageID does not know.
+func (c *fakeClient) DeserializeMessageID(data []byte) (pulsar.MessageID, error) {
+	i := bytes.LastIndexByte(data, ':')
+	if i < 0 {
+		return nil, ErrUnknownID
+	}
+	entry, err := strconv.ParseInt(string(data[i+1:]), 10, 64)
+	if err != nil {
+		return nil, ErrUnknownID
+	}
+	return fakeMessageID{topic: string(data[:i]), entry: entry}, nil
+}
+
+func (c *fakeClient) TopicPartitions(topic string) ([]string, error) {
+	return []string{fullTopicName(topic)}, nil
+}
+
+func (c *fakeClient) Close() {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c.closed = true
+	for _, consumer := range c.consumers {
+		consumer.leave()
+	}
+	for _, r := range c.readers {
+		r.closed = true
+	}
+	b.notify()
+}
+
+type fakeProducer struct {
+	client *fakeClient
+	topic  string
+	name   string
+}
+
+func (p *fakeProducer) Send(ctx context.Context, data []byte) (pulsar.MessageID, error) {
+	b := p.client.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p.client.closed {
+		return nil, axon.ErrCloseConn
+	}
+
+	t := b.topic(p.topic)
+	now := time.Now()
+	msg := &fakeMessage{
+		id:          fakeMessageID{topic: t.name, entry: int64(len(t.messages))},
+		payload:     append([]byte(nil), data...),
+		producer:    p.name,
+		publishTime: now,
+		eventTime:   now,
+	}
+	t.messages = append(t.messages, msg)
+	b.notify()
+	return msg.id, nil
+}
+
+func (p *fakeProducer) Close() {}
+
+type fakeConsumer struct {
+	client        *fakeClient
+	name          string
+	typ           pulsar.SubscriptionType
+	pattern       *regexp.Regexp
+	subscriptions []*fakeSubscription
+	next          int
+	waiting       bool // Blocked in Recv, ready for a message.
+	closed        bool
+}
+
+// join adds the consumer to its subscription on t, creating the subscription at position when it is new.
+// The caller holds the broker lock.
+func (c *fakeConsumer) join(t *fakeTopic, position pulsar.SubscriptionInitialPosition) error {
+	s, ok := t.subscriptions[c.name]
+	if !ok {
+		s = &fakeSubscription{
+			topic:       t,
+			name:        c.name,
+			typ:         c.typ,
+			unacked:     make(map[int64]*fakeDelivery),
+			redelivered: make(map[int64]uint32),
+		}
+		if position == pulsar.SubscriptionPositionLatest {
+			s.cursor = len(t.messages)
+		}
+		t.subscriptions[c.name] = s
+	}
+	if s.typ == pulsar.Exclusive && len(s.consumers) > 0 {
+		return errors.Wrapf(ErrConsumerBusy, "subscription %s of %s", c.name, t.name)
+	}
+	s.consumers = append(s.consumers, c)
+	c.subscriptions = append(c.subscriptions, s)
+	return nil
+}
+
+// leave removes the consumer from its subscriptions, which deliver its unacknowledged messages again. The
+// caller holds the broker lock.
+func (c *fakeConsumer) leave() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	for _, s := range c.subscriptions {
+		for i, consumer := range s.consumers {
+			if consumer == c {
+				s.consumers = append(s.consumers[:i], s.consumers[i+1:]...)
+				break
+			}
+		}
+		for entry, d := range s.unacked {
+			if d.consumer == c {
+				s.requeue(entry)
+			}
+		}
+	}
+
+	b := c.client.broker
+	for i, consumer := range b.patterns {
+		if consumer == c {
+			b.patterns = append(b.patterns[:i], b.patterns[i+1:]...)
+			break
+		}
+	}
+	b.notify()
+}
+
+// Recv returns the next message of the subscriptions of the consumer, in turn, blocking until one is available.
+// It returns axon.ErrCloseConn once the consumer or its client is closed.
+func (c *fakeConsumer) Recv(ctx context.Context) (pulse.Message, error) {
+	b := c.client.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		if c.closed {
+			return nil, axon.ErrCloseConn
+		}
+		for i := range c.subscriptions {
+			s := c.subscriptions[(c.next+i)%len(c.subscriptions)]
+			if msg := s.take(c, b.ackTimeout); msg != nil {
+				c.next = (c.next + i + 1) % len(c.subscriptions)
+				b.notify() // The next message may be for a consumer that skipped it.
+				return msg, nil
+			}
+		}
+		c.waiting = true
+		err := b.wait(ctx)
+		c.waiting = false
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *fakeConsumer) Ack(id pulsar.MessageID) {
+	fakeID, ok := id.(fakeMessageID)
+	if !ok {
+		return
+	}
+
+	b := c.client.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range c.subscriptions {
+		if s.topic.name == fakeID.topic {
+			delete(s.unacked, fakeID.entry)
+		}
+	}
+}
+
+func (c *fakeConsumer) Close() {
+	b := c.client.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c.leave()
+}
+
+type fakeReader struct {
+	client *fakeClient
+	topic  *fakeTopic
+	next   int
+	closed bool
+}
+
+// seek positions the reader at id, or past it when not inclusive. The caller holds the broker lock.
+func (r *fakeReader) seek(id pulsar.MessageID, inclusive bool) error {
+	switch {
+	case id == nil || bytes.Equal(id.Serialize(), pulsar.EarliestMessageID().Serialize()):
+		r.next = 0
+	case bytes.Equal(id.Serialize(), pulsar.LatestMessageID().Serialize()):
+		r.next = len(r.topic.messages)
+	default:
+		fakeID, ok := id.(fakeMessageID)
+		if !ok || fakeID.topic != r.topic.name {
+			return ErrUnknownID
+		}
+		r.next = int(fakeID.entry)
+		if !inclusive {
+			r.next++
+		}
+	}
+	return nil
+}
+
+func (r *fakeReader) Topic() string {
+	return r.topic.name
+}
+
+func (r *fakeReader) Next(ctx context.Context) (pulsar.Message, error) {
+	b := r.client.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		if r.closed {
+			return nil, axon.ErrCloseConn
+		}
+		if r.next < len(r.topic.messages) {
+			msg := r.topic.messages[r.next]
+			r.next++
+			return msg, nil
+		}
+		if err := b.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *fakeReader) HasNext() bool {
+	b := r.client.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return r.next < len(r.topic.messages)
+}
+
+func (r *fakeReader) Close() {
+	b := r.client.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r.closed = true
+	b.notify()
+}
+
+// Seek positions the reader at id, which is read next.
+func (r *fakeReader) Seek(id pulsar.MessageID) error {
+	b := r.client.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return r.seek(id, true)
+}
+
+// SeekByTime positions the reader at the first message published at or after t.
+func (r *fakeReader) SeekByTime(t time.Time) error {
+	b := r.client.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	messages := r.topic.messages
+	r.next = sort.Search(len(messages), func(i int) bool { return !messages[i].publishTime.Before(t) })
+	return nil
+}
+
+type fakeMessageID struct {
+	topic string
+	entry int64
+}
+
+func (id fakeMessageID) Serialize() []byte {
+	return []byte(fmt.Sprintf("%s:%d", id.topic, id.entry))
+}
+
+// fakeMessage implements both Message and pulsar.Message.
+type fakeMessage struct {
+	id              fakeMessageID
+	payload         []byte
+	producer        string
+	publishTime     time.Time
+	eventTime       time.Time
+	redeliveryCount uint32
+}
+
+func (m *fakeMessage) Topic() string                 { return m.id.topic }
+func (m *fakeMessage) ProducerName() string          { return m.producer }
+func (m *fakeMessage) Properties() map[string]string { return nil }
+func (m *fakeMessage) Payload() []byte               { return m.payload }
+func (m *fakeMessage) ID() pulsar.MessageID          { return m.id }
+func (m *fakeMessage) PublishTime() time.Time        { return m.publishTime }
+func (m *fakeMessage) EventTime() time.Time          { return m.eventTime }
+func (m *fakeMessage) Key() string                   { return "" }
+func (m *fakeMessage) RedeliveryCount() uint32       { return m.redeliveryCount }
+func (m *fakeMessage) IsReplicated() bool            { return false }
+func (m *fakeMessage) GetReplicatedFrom() string     { return "" }
@@ -0,0 +1,131 @@
+package pulsetest
+
+import (
+	"context"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/pulse"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func publish(t *testing.T, client pulse.Client, topic string, payloads ...string) []pulsar.MessageID {
+	producer, err := client.CreateProducer(pulsar.ProducerOptions{Topic: topic})
+	require.Nil(t, err)
+	defer producer.Close()
+
+	var ids []pulsar.MessageID
+	for _, payload := range payloads {
+		id, err := producer.Send(context.Background(), []byte(payload))
+		require.Nil(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func recv(t *testing.T, consumer pulse.Consumer) pulse.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := consumer.Recv(ctx)
+	require.Nil(t, err)
+	return msg
+}
+
+func TestBroker_ExclusiveAndFailover(t *testing.T) {
+	client := NewBroker().Client()
+	defer client.Close()
+	options := pulsar.ConsumerOptions{Topic: "orders", SubscriptionName: "exclusive", Type: pulsar.Exclusive}
+	_, err := client.Subscribe(options)
+	require.Nil(t, err)
+	_, err = client.Subscribe(options)
+	assert.ErrorIs(t, err, ErrConsumerBusy)
+
+	options = pulsar.ConsumerOptions{Topic: "orders", SubscriptionName: "failover", Type: pulsar.Failover}
+	active, err := client.Subscribe(options)
+	require.Nil(t, err)
+	standby, err := client.Subscribe(options)
+	require.Nil(t, err)
+
+	publish(t, client, "orders", "#1")
+	assert.Equal(t, "#1", string(recv(t, active).Payload()))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = standby.Recv(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "only the active consumer receives")
+
+	// The standby takes over with the message the active consumer did not acknowledge.
+	active.Close()
+	msg := recv(t, standby)
+	assert.Equal(t, "#1", string(msg.Payload()))
+	assert.Equal(t, uint32(1), msg.(pulsar.Message).RedeliveryCount())
+}
+
+func TestBroker_AckTimeout(t *testing.T) {
+	client := NewBroker(AckTimeout(50 * time.Millisecond)).Client()
+	defer client.Close()
+	consumer, err := client.Subscribe(pulsar.ConsumerOptions{
+		Topic:                       "orders",
+		SubscriptionName:            "orders",
+		Type:                        pulsar.Shared,
+		SubscriptionInitialPosition: pulsar.SubscriptionPositionEarliest,
+	})
+	require.Nil(t, err)
+	publish(t, client, "orders", "#1", "#2")
+
+	first := recv(t, consumer)
+	consumer.Ack(first.ID())
+	second := recv(t, consumer)
+	assert.Equal(t, "#2", string(second.Payload()))
+	redelivered := recv(t, consumer)
+	assert.Equal(t, second.ID(), redelivered.ID(), "only the unacknowledged message is delivered again")
+
+	// Closing the client unblocks its consumers.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		client.Close()
+	}()
+	consumer.Ack(redelivered.ID())
+	_, err = consumer.Recv(context.Background())
+	assert.Equal(t, axon.ErrCloseConn, err)
+}
+
+func TestBroker_Reader(t *testing.T) {
+	client := NewBroker().Client()
+	defer client.Close()
+	ids := publish(t, client, "orders", "#0", "#1")
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	ids = append(ids, publish(t, client, "orders", "#2")...)
+
+	read := func(r pulsar.Reader) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		msg, err := r.Next(ctx)
+		require.Nil(t, err)
+		return string(msg.Payload())
+	}
+
+	r, err := client.CreateReader(pulsar.ReaderOptions{Topic: "orders", StartMessageID: pulsar.EarliestMessageID()})
+	require.Nil(t, err)
+	assert.Equal(t, "persistent://public/default/orders", r.Topic())
+	assert.Equal(t, "#0", read(r))
+	require.Nil(t, r.SeekByTime(since))
+	assert.Equal(t, "#2", read(r))
+	assert.False(t, r.HasNext())
+
+	r, err = client.CreateReader(pulsar.ReaderOptions{Topic: "orders", StartMessageID: ids[0]})
+	require.Nil(t, err)
+	assert.Equal(t, "#1", read(r), "the start message is excluded by default")
+	r, err = client.CreateReader(pulsar.ReaderOptions{Topic: "orders", StartMessageID: ids[0], StartMessageIDInclusive: true})
+	require.Nil(t, err)
+	assert.Equal(t, "#0", read(r))
+
+	r, err = client.CreateReader(pulsar.ReaderOptions{Topic: "orders", StartMessageID: pulsar.LatestMessageID()})
+	require.Nil(t, err)
+	assert.False(t, r.HasNext())
+	publish(t, client, "orders", "#3")
+	assert.Equal(t, "#3", read(r))
+}
@@ -0,0 +1,66 @@
+package pulse
+
+import (
+	"github.com/Just4Ease/axon"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/pkg/errors"
+	"net/url"
+)
+
+var compressionTypes = map[string]pulsar.CompressionType{
+	"none": pulsar.NoCompression,
+	"lz4":  pulsar.LZ4,
+	"zlib": pulsar.ZLib,
+	"zstd": pulsar.ZSTD,
+}
+
+var compressionLevels = map[string]pulsar.CompressionLevel{
+	"default": pulsar.Default,
+	"faster":  pulsar.Faster,
+	"better":  pulsar.Better,
+}
+
+func init() {
+	axon.Register("pulsar", open)
+	axon.Register("pulsar+ssl", open)
+}
+
+// open connects to the Pulsar cluster of u. The `compression` query parameter, lz4, zlib or zstd, makes producers
+// compress messages as Compression does, at the `compression_level` default, faster or better.
+func open(u *url.URL, opts axon.Options) (axon.EventStore, error) {
+	query := u.Query()
+	options, err := urlOptions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	query.Del("compression")
+	query.Del("compression_level")
+	address := *u
+	address.RawQuery = query.Encode()
+	opts.Address = address.String()
+	return Init(opts, options...)
+}
+
+// urlOptions returns the options set by the query parameters of a URL.
+func urlOptions(query url.Values) ([]Option, error) {
+	name, levelName := query.Get("compression"), query.Get("compression_level")
+	if name == "" {
+		if levelName != "" {
+			return nil, errors.Wrap(axon.ErrInvalidURL, "compression_level needs a compression")
+		}
+		return nil, nil
+	}
+
+	compression, ok := compressionTypes[name]
+	if !ok {
+		return nil, errors.Wrapf(axon.ErrInvalidURL, "unknown compression %s, expected lz4, zlib or zstd", name)
+	}
+	level := pulsar.Default
+	if levelName != "" {
+		if level, ok = compressionLevels[levelName]; !ok {
+			return nil, errors.Wrapf(axon.ErrInvalidURL, "unknown compression level %s, expected default, faster or better", levelName)
+		}
+	}
+	return []Option{Compression(compression, level)}, nil
+}
@@ -0,0 +1,339 @@
+package pulse_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/Just4Ease/axon/pulse"
+	"github.com/Just4Ease/axon/pulse/pulsetest"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, broker *pulsetest.Broker, serviceName string) axon.EventStore {
+	store, err := pulse.InitTestEventStore(broker.Client(), serviceName)
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func receive(t *testing.T, events <-chan axon.Event) axon.Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+// subscribe runs Subscribe in the background and waits for the subscription of the service to exist.
+func subscribe(t *testing.T, broker *pulsetest.Broker, store axon.EventStore, topic string, handler axon.SubscriptionHandler) {
+	go func() { _ = store.Subscribe(topic, handler) }()
+	waitForSubscription(t, broker, topic, store.GetServiceName()+"-"+topic)
+}
+
+func waitForSubscription(t *testing.T, broker *pulsetest.Broker, topic, subscription string) {
+	require.Eventually(t, func() bool {
+		return broker.Consumers(topic, subscription) > 0
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestStore_PublishSubscribe(t *testing.T) {
+	broker := pulsetest.NewBroker()
+	publisher := newTestStore(t, broker, "publisher")
+	first, second := newTestStore(t, broker, "orders"), newTestStore(t, broker, "orders")
+	billing := newTestStore(t, broker, "billing")
+
+	var mu sync.Mutex
+	counts := map[string]int{}
+	done := make(chan struct{}, 20)
+	handler := func(name string) axon.SubscriptionHandler {
+		return func(e axon.Event) {
+			assert.Equal(t, "order.created", e.Topic())
+			mu.Lock()
+			counts[name]++
+			mu.Unlock()
+			e.Ack()
+			done <- struct{}{}
+		}
+	}
+	subscribe(t, broker, first, "order.created", handler("first"))
+	subscribe(t, broker, second, "order.created", handler("second"))
+	subscribe(t, broker, billing, "order.created", handler("billing"))
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, publisher.Publish("order.created", []byte("order")))
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 10, counts["first"]+counts["second"], "each message is delivered to one orders instance")
+	assert.Equal(t, 10, counts["billing"])
+	assert.Len(t, broker.Messages("order.created"), 10)
+	assert.Equal(t, 0, broker.Unacked("order.created", "orders-order.created"))
+}
+
+// producerOptionsClient records the options of the producers created.
+type producerOptionsClient struct {
+	pulse.Client
+	options []pulsar.ProducerOptions
+}
+
+func (c *producerOptionsClient) CreateProducer(opts pulsar.ProducerOptions) (pulse.Producer, error) {
+	c.options = append(c.options, opts)
+	return c.Client.CreateProducer(opts)
+}
+
+func TestStore_Compression(t *testing.T) {
+	client := &producerOptionsClient{Client: pulsetest.NewBroker().Client()}
+	store, err := pulse.InitTestEventStore(client, "orders", pulse.Compression(pulsar.ZSTD, pulsar.Better))
+	require.Nil(t, err)
+	defer store.Close()
+
+	require.Nil(t, store.Publish("order.created", []byte("order")))
+	require.Len(t, client.options, 1)
+	assert.Equal(t, pulsar.ZSTD, client.options[0].CompressionType)
+	assert.Equal(t, pulsar.Better, client.options[0].CompressionLevel)
+}
+
+func TestStore_RedeliveryAndClose(t *testing.T) {
+	broker := pulsetest.NewBroker()
+	publisher := newTestStore(t, broker, "publisher")
+	subscriber := newTestStore(t, broker, "orders")
+
+	events := make(chan axon.Event, 4)
+	returned := make(chan error, 1)
+	go func() { returned <- subscriber.Subscribe("order.created", func(e axon.Event) { events <- e }) }()
+	waitForSubscription(t, broker, "order.created", "orders-order.created")
+
+	require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+	assert.Equal(t, "#1", string(receive(t, events).Data()))
+
+	// Closing the store returns the subscription, and the message it did not acknowledge goes to the next
+	// instance of the service.
+	require.Nil(t, subscriber.Close())
+	select {
+	case err := <-returned:
+		assert.Equal(t, axon.ErrCloseConn, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe did not return after Close")
+	}
+	assert.Equal(t, axon.ErrCloseConn, subscriber.Health())
+
+	resumed := newTestStore(t, broker, "orders")
+	go func() { _ = resumed.Subscribe("order.created", func(e axon.Event) { events <- e }) }()
+	e := receive(t, events)
+	assert.Equal(t, "#1", string(e.Data()))
+	e.Ack()
+	require.Eventually(t, func() bool {
+		return broker.Unacked("order.created", "orders-order.created") == 0
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestStore_RequestReply(t *testing.T) {
+	broker := pulsetest.NewBroker()
+	caller := newTestStore(t, broker, "caller")
+	replier := newTestStore(t, broker, "greeter")
+
+	go func() {
+		_ = replier.ReplyContext("greet", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			var in struct{ Name string }
+			if err := req.ParsePayload(&in); err != nil {
+				return axon.Response{}, err
+			}
+			if in.Name == "" {
+				return axon.Response{}, errors.New("name is required")
+			}
+			if in.Name == "sleepy" {
+				time.Sleep(500 * time.Millisecond)
+			}
+			out, _ := json.Marshal(map[string]string{"greeting": "hello " + in.Name, "from": req.ServiceName})
+			return axon.Response{Payload: out}, nil
+		})
+	}()
+	waitForSubscription(t, broker, "greet", "greeter-greet")
+
+	var out map[string]string
+	require.Nil(t, caller.Request("greet", []byte(`{"Name":"axon"}`), &out))
+	assert.Equal(t, map[string]string{"greeting": "hello axon", "from": "caller"}, out)
+
+	err := caller.Request("greet", []byte(`{}`), &out)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "name is required")
+
+	err = caller.Request("greet", []byte(`{"Name":"sleepy"}`), &out, axon.WithTimeout(100*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestStore_Replay(t *testing.T) {
+	broker := pulsetest.NewBroker()
+	store := newTestStore(t, broker, "orders")
+	require.Nil(t, store.Publish("order.created", []byte("#0")))
+	require.Nil(t, store.Publish("order.created", []byte("#1")))
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	require.Nil(t, store.Publish("order.created", []byte("#2")))
+
+	replay := func(ctx context.Context, from axon.Position, n int) []axon.Event {
+		events := make(chan axon.Event, 8)
+		go func() { _ = axon.Replay(ctx, store, "order.created", from, func(e axon.Event) { events <- e }) }()
+		var received []axon.Event
+		for i := 0; i < n; i++ {
+			received = append(received, receive(t, events))
+		}
+		return received
+	}
+	data := func(events []axon.Event) (out []string) {
+		for _, e := range events {
+			out = append(out, string(e.Data()))
+		}
+		return out
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	all := replay(ctx, axon.Earliest(), 3)
+	assert.Equal(t, []string{"#0", "#1", "#2"}, data(all))
+	assert.Equal(t, "order.created", all[0].Topic())
+	all[0].Ack() // A no-op, replays use no subscription.
+
+	from := all[1].(axon.PositionedEvent).Position()
+	assert.Equal(t, []string{"#1", "#2"}, data(replay(ctx, from, 2)), "the position is included")
+	assert.Equal(t, []string{"#2"}, data(replay(ctx, axon.AtTime(since), 1)))
+	latest := make(chan axon.Event, 1)
+	go func() {
+		_ = axon.Replay(ctx, store, "order.created", axon.Latest(), func(e axon.Event) { latest <- e })
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, store.Publish("order.created", []byte("#3")))
+	assert.Equal(t, "#3", string(receive(t, latest).Data()), "only the messages published afterwards are read")
+
+	err := axon.Replay(ctx, store, "order.created", axon.AtSequence(1), func(axon.Event) {})
+	assert.ErrorIs(t, err, axon.ErrUnsupportedPosition)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	returned := make(chan error, 1)
+	go func() { returned <- axon.Replay(ctx, store, "order.created", axon.Earliest(), func(axon.Event) {}) }()
+	cancel()
+	select {
+	case err := <-returned:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replay did not return once its context was done")
+	}
+}
+
+// blockingReaderClient creates readers which, like those of the Pulsar client, only return from Next once its
+// context is done, or fail every call when err is set.
+type blockingReaderClient struct {
+	pulse.Client
+	err   error
+	calls int32
+}
+
+func (c *blockingReaderClient) CreateReader(opts pulsar.ReaderOptions) (pulsar.Reader, error) {
+	reader, err := c.Client.CreateReader(opts)
+	return &blockingReader{Reader: reader, client: c}, err
+}
+
+type blockingReader struct {
+	pulsar.Reader
+	client *blockingReaderClient
+}
+
+func (r *blockingReader) Next(ctx context.Context) (pulsar.Message, error) {
+	atomic.AddInt32(&r.client.calls, 1)
+	if r.client.err != nil {
+		return nil, r.client.err
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestStore_ReplayClose(t *testing.T) {
+	store, err := pulse.InitTestEventStore(&blockingReaderClient{Client: pulsetest.NewBroker().Client()}, "orders")
+	require.Nil(t, err)
+	returned := make(chan error, 1)
+	go func() {
+		returned <- axon.Replay(context.Background(), store, "order.created", axon.Earliest(), func(axon.Event) {})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, store.Close())
+	select {
+	case err := <-returned:
+		assert.Equal(t, axon.ErrCloseConn, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replay did not return once the store was closed")
+	}
+}
+
+func TestStore_ReplayBacksOff(t *testing.T) {
+	client := &blockingReaderClient{Client: pulsetest.NewBroker().Client(), err: errors.New("connection reset")}
+	store, err := pulse.InitTestEventStore(client, "orders")
+	require.Nil(t, err)
+	defer store.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, axon.Replay(ctx, store, "order.created", axon.Earliest(), func(axon.Event) {}))
+	// Retried after 100ms and 200ms, then once more when the deadline cuts the 400ms wait short.
+	assert.LessOrEqual(t, atomic.LoadInt32(&client.calls), int32(4))
+}
+
+func TestStore_SubscribePattern(t *testing.T) {
+	broker := pulsetest.NewBroker()
+	publisher := newTestStore(t, broker, "publisher")
+	subscriber := newTestStore(t, broker, "audit")
+	require.Nil(t, publisher.Publish("order.created", []byte("before")))
+
+	events := make(chan axon.Event, 4)
+	go func() {
+		_ = subscriber.(axon.PatternSubscriber).SubscribePattern(regexp.MustCompile(`^order\..*`), func(e axon.Event) {
+			e.Ack()
+			events <- e
+		})
+	}()
+	waitForSubscription(t, broker, "order.created", "")
+
+	require.Nil(t, publisher.Publish("order.created", []byte("created")))
+	require.Nil(t, publisher.Publish("order.shipped", []byte("shipped")))
+	require.Nil(t, publisher.Publish("invoice.sent", []byte("ignored")))
+
+	received := map[string]string{}
+	for i := 0; i < 2; i++ {
+		e := receive(t, events)
+		received[e.Topic()] = string(e.Data())
+	}
+	assert.Equal(t, map[string]string{
+		"order.created": "created",
+		"order.shipped": "shipped",
+	}, received)
+}
+
+func TestConformance(t *testing.T) {
+	// The store sets no ack timeout on its consumers, so Pulsar delivers unacknowledged messages again once
+	// their consumer reconnects only, as the broker without AckTimeout does.
+	broker := pulsetest.NewBroker()
+	axontest.RunConformance(t, func(t *testing.T, opts axon.Options) axon.EventStore {
+		store, err := pulse.InitTestEventStore(broker.Client(), opts.ServiceName)
+		require.Nil(t, err)
+		t.Cleanup(func() { _ = store.Close() })
+		return store
+	}, axontest.AckWait(200*time.Millisecond), axontest.RedeliveryOnReconnect())
+}
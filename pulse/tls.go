@@ -0,0 +1,83 @@
+package pulse
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/Just4Ease/axon"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+)
+
+// configureTLS applies t to clientOptions. Hostname verification is on unless InsecureSkipVerify is set. A client
+// certificate authenticates the client, so it fails with ErrInvalidAuthConfiguration when clientOptions already
+// has another authentication method, as Pulsar accepts a single one.
+//
+// The Pulsar client only reads trusted CAs from a file, so CA content is written to a private temporary file
+// whose path is returned; it must be kept for the lifetime of the client, which re-reads it on reconnection. The
+// content is parsed first, failing with axon.ErrInvalidTlsConfiguration when it holds no certificate, as the
+// client would only report it when connecting.
+func configureTLS(clientOptions *pulsar.ClientOptions, t *axon.TLSOptions) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+
+	if t.InsecureSkipVerify {
+		clientOptions.TLSAllowInsecureConnection = true
+	} else {
+		clientOptions.TLSValidateHostname = true
+	}
+
+	cert, err := t.ClientCertificate()
+	if err != nil {
+		return "", err
+	}
+	if cert != nil && clientOptions.Authentication != nil {
+		return "", errors.Wrap(ErrInvalidAuthConfiguration, "pulsar accepts a single authentication method, a client certificate or another")
+	}
+	if cert != nil {
+		clientOptions.Authentication = pulsar.NewAuthenticationFromTLSCertSupplier(func() (*tls.Certificate, error) {
+			return cert, nil
+		})
+	}
+
+	if t.CACertContent == "" {
+		clientOptions.TLSTrustCertsFilePath = t.CACertFile
+		return "", nil
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(t.CACertContent)) {
+		return "", errors.Wrap(axon.ErrInvalidTlsConfiguration, "no valid CA certificate found")
+	}
+	certPath, err := writeCert(t.CACertContent)
+	if err != nil {
+		return "", err
+	}
+	clientOptions.TLSTrustCertsFilePath = certPath
+	return certPath, nil
+}
+
+// writeCert writes content to a temporary file readable by the current user only.
+func writeCert(content string) (string, error) {
+	f, err := ioutil.TempFile("", "axon-pulsar-ca-*.crt")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to write the CA certificate to a temporary file")
+	}
+	if _, err := f.WriteString(content); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", errors.Wrap(err, "unable to write the CA certificate to a temporary file")
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func removeCert(certPath string) {
+	if certPath != "" {
+		_ = os.Remove(certPath)
+	}
+}
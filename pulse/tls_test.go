@@ -0,0 +1,101 @@
+package pulse
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/Just4Ease/axon"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+// clientCert returns the PEM certificate and key of a self-signed client certificate.
+func clientCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "orders"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestConfigureTLS(t *testing.T) {
+	ca, _ := clientCert(t)
+	var clientOptions pulsar.ClientOptions
+	certPath, err := configureTLS(&clientOptions, axon.Options{CertContent: ca}.ResolvedTLS())
+	assert.Nil(t, err)
+	defer removeCert(certPath)
+
+	assert.False(t, clientOptions.TLSAllowInsecureConnection)
+	assert.True(t, clientOptions.TLSValidateHostname)
+	assert.Equal(t, certPath, clientOptions.TLSTrustCertsFilePath)
+
+	info, err := os.Stat(certPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, err := ioutil.ReadFile(certPath)
+	assert.Nil(t, err)
+	assert.Equal(t, ca, string(content))
+
+	removeCert(certPath)
+	_, err = os.Stat(certPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestConfigureTLS_InvalidCACertificate(t *testing.T) {
+	var clientOptions pulsar.ClientOptions
+	certPath, err := configureTLS(&clientOptions, axon.Options{CertContent: cert}.ResolvedTLS())
+	assert.ErrorIs(t, err, axon.ErrInvalidTlsConfiguration)
+	assert.Equal(t, "", certPath)
+	assert.Equal(t, "", clientOptions.TLSTrustCertsFilePath)
+}
+
+func TestConfigureTLS_InsecureOptIn(t *testing.T) {
+	var clientOptions pulsar.ClientOptions
+	certPath, err := configureTLS(&clientOptions, &axon.TLSOptions{CACertFile: "/etc/pulsar/ca.crt", InsecureSkipVerify: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "", certPath)
+	assert.True(t, clientOptions.TLSAllowInsecureConnection)
+	assert.False(t, clientOptions.TLSValidateHostname)
+	assert.Equal(t, "/etc/pulsar/ca.crt", clientOptions.TLSTrustCertsFilePath)
+}
+
+func TestConfigureTLS_InvalidClientCertificate(t *testing.T) {
+	var clientOptions pulsar.ClientOptions
+	_, err := configureTLS(&clientOptions, &axon.TLSOptions{ClientCertContent: "nope", ClientKeyContent: "nope"})
+	assert.NotNil(t, err)
+	assert.Nil(t, clientOptions.Authentication)
+}
+
+func TestConfigureTLS_ClientCertificate(t *testing.T) {
+	certPEM, keyPEM := clientCert(t)
+	tlsOptions := &axon.TLSOptions{CACertFile: "/etc/pulsar/ca.crt", ClientCertContent: certPEM, ClientKeyContent: keyPEM}
+
+	var clientOptions pulsar.ClientOptions
+	_, err := configureTLS(&clientOptions, tlsOptions)
+	require.Nil(t, err)
+	assert.NotNil(t, clientOptions.Authentication)
+
+	// The certificate would be silently ignored next to a token, so both are refused.
+	clientOptions = pulsar.ClientOptions{Authentication: pulsar.NewAuthenticationToken("token")}
+	_, err = configureTLS(&clientOptions, tlsOptions)
+	assert.ErrorIs(t, err, ErrInvalidAuthConfiguration)
+}
@@ -0,0 +1,48 @@
+package redisstream
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+)
+
+type redisEvent struct {
+	store *redisStore
+	key   string
+	topic string
+	id    string
+	data  []byte
+}
+
+func (e *redisEvent) Ack() {
+	_ = e.store.track(e.store.client.XAck(context.Background(), e.key, e.store.serviceName, e.id).Err())
+}
+
+func (e *redisEvent) Data() []byte {
+	return e.data
+}
+
+func (e *redisEvent) Topic() string {
+	return e.topic
+}
+
+func (s *redisStore) newEvent(key, topic string, msg redis.XMessage) *redisEvent {
+	return &redisEvent{
+		store: s,
+		key:   key,
+		topic: topic,
+		id:    msg.ID,
+		data:  messageData(msg),
+	}
+}
+
+func messageData(msg redis.XMessage) []byte {
+	switch data := msg.Values[dataField].(type) {
+	case string:
+		return []byte(data)
+	case nil:
+		return nil
+	default:
+		return []byte(fmt.Sprint(data))
+	}
+}
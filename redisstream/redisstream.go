@@ -0,0 +1,381 @@
+// Package redisstream implements axon.EventStore on Redis Streams, for deployments already running Redis.
+//
+// Each topic is a stream appended to with XADD. Every service reads a topic through a consumer group named after
+// it, so each message is delivered to one instance of the service, and messages left unacknowledged for longer
+// than the ack wait are claimed with XCLAIM and delivered again. Requests go through a stream of their own and
+// replies are pushed to a short-lived inbox list of the caller.
+package redisstream
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAckWait        = 30 * time.Second
+	defaultRequestTimeout = 5 * time.Second
+	defaultBatch          = 10
+	blockTimeout          = time.Second
+	claimBatch            = 100
+	inboxTTL              = time.Minute
+	dataField             = "data"
+)
+
+type Option func(*redisStore)
+
+// KeyPrefix prefixes every key used by the store, to share a database with other applications.
+func KeyPrefix(prefix string) Option {
+	return func(s *redisStore) {
+		s.prefix = prefix
+	}
+}
+
+// MaxLen trims topic streams to about n messages as messages are published. Streams grow unbounded by default.
+func MaxLen(n int64) Option {
+	return func(s *redisStore) {
+		s.maxLen = n
+	}
+}
+
+// AckWait sets how long a delivered message may stay unacknowledged before it is delivered again.
+func AckWait(d time.Duration) Option {
+	return func(s *redisStore) {
+		s.ackWait = d
+	}
+}
+
+type redisStore struct {
+	*axon.StateTracker
+	client      *redis.Client
+	serviceName string
+	consumer    string
+	opts        axon.Options
+	prefix      string
+	maxLen      int64
+	ackWait     time.Duration
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+// Init connects to the Redis server at opts.Address, such as `redis://localhost:6379/0` or `rediss://` for TLS.
+// Auth.Username and Auth.Password, or AuthenticationToken as the password, override the credentials of the URL.
+func Init(opts axon.Options, options ...Option) (axon.EventStore, error) {
+	addr := strings.TrimSpace(opts.Address)
+	if addr == "" {
+		return nil, axon.ErrInvalidURL
+	}
+
+	name := strings.TrimSpace(opts.ServiceName)
+	if name == "" {
+		return nil, axon.ErrEmptyStoreName
+	}
+
+	redisOptions, err := redis.ParseURL(addr)
+	if err != nil {
+		return nil, errors.Wrap(axon.ErrInvalidURL, err.Error())
+	}
+	if opts.AuthenticationToken != "" {
+		redisOptions.Password = opts.AuthenticationToken
+	}
+	if auth := opts.Auth; auth != nil && auth.Username != "" {
+		redisOptions.Username = auth.Username
+		redisOptions.Password = auth.Password
+	}
+	if t := opts.ResolvedTLS(); t != nil {
+		config, err := t.Config()
+		if err != nil {
+			return nil, err
+		}
+		redisOptions.TLSConfig = config
+	}
+
+	client := redis.NewClient(redisOptions)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, errors.Wrap(err, "unable to connect to redis")
+	}
+
+	s := &redisStore{
+		StateTracker: axon.NewStateTracker(axon.StateConnected),
+		client:       client,
+		serviceName:  name,
+		consumer:     name + "-" + axon.GenerateRandomString(),
+		opts:         opts,
+		ackWait:      defaultAckWait,
+		closed:       make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.ackWait <= 0 {
+		s.ackWait = defaultAckWait
+	}
+	return s, nil
+}
+
+func (s *redisStore) GetServiceName() string {
+	return s.serviceName
+}
+
+func (s *redisStore) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// track updates the connection state from the outcome of a command: any reply from the server, errors included,
+// shows it is reachable.
+func (s *redisStore) track(err error) error {
+	var redisErr redis.Error
+	if err == nil || errors.Is(err, redis.Nil) || errors.As(err, &redisErr) {
+		s.SetState(axon.StateConnected)
+	} else {
+		s.SetState(axon.StateDisconnected)
+	}
+	return err
+}
+
+func (s *redisStore) key(topic string) string {
+	return s.prefix + topic
+}
+
+func (s *redisStore) requestKey(topic string) string {
+	return s.prefix + topic + ":requests"
+}
+
+func (s *redisStore) Publish(topic string, message []byte) error {
+	return s.add(s.key(topic), message)
+}
+
+func (s *redisStore) add(key string, message []byte) error {
+	args := &redis.XAddArgs{Stream: key, Values: map[string]interface{}{dataField: message}}
+	if s.maxLen > 0 {
+		args.MaxLen = s.maxLen
+		args.Approx = true
+	}
+	return s.track(s.client.XAdd(context.Background(), args).Err())
+}
+
+// createGroup creates the consumer group of the service on key, starting from messages added from now on.
+func (s *redisStore) createGroup(key string) error {
+	err := s.client.XGroupCreateMkStream(context.Background(), key, s.serviceName, "$").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil // The group already exists, created by an earlier instance.
+	}
+	return s.track(err)
+}
+
+// Subscribe delivers the messages of topic to one instance of the service, blocking until the store is closed.
+func (s *redisStore) Subscribe(topic string, handler axon.SubscriptionHandler) error {
+	key := s.key(topic)
+	if err := s.createGroup(key); err != nil {
+		return err
+	}
+
+	topicOpts := s.opts.Topic(topic)
+	limiter := axon.NewLimiter(topicOpts.Concurrency)
+	ackWait := s.ackWait
+	if topicOpts.AckWait > 0 {
+		ackWait = topicOpts.AckWait
+	}
+	batch := int64(defaultBatch)
+	if topicOpts.Concurrency > 0 {
+		batch = int64(topicOpts.Concurrency)
+	}
+
+	deliver := func(msg redis.XMessage) {
+		limiter.Go(handler, s.newEvent(key, topic, msg))
+	}
+	go s.reclaim(key, ackWait, deliver)
+	return s.consume(key, batch, deliver)
+}
+
+// consume reads the new messages of key for the service until the store is closed.
+func (s *redisStore) consume(key string, batch int64, deliver func(msg redis.XMessage)) error {
+	for {
+		streams, err := s.client.XReadGroup(context.Background(), &redis.XReadGroupArgs{
+			Group:    s.serviceName,
+			Consumer: s.consumer,
+			Streams:  []string{key, ">"},
+			Count:    batch,
+			Block:    blockTimeout,
+		}).Result()
+		if s.isClosed() {
+			return axon.ErrCloseConn
+		}
+		if errors.Is(s.track(err), redis.Nil) {
+			continue
+		}
+		if err != nil {
+			log.Print("failed to read from the redis stream with the following error: ", err)
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				_ = s.createGroup(key) // The stream was deleted.
+			}
+			time.Sleep(blockTimeout)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				deliver(msg)
+			}
+		}
+	}
+}
+
+// reclaim claims the messages of key left unacknowledged for longer than ackWait, by any instance of the service,
+// and delivers them again until the store is closed.
+func (s *redisStore) reclaim(key string, ackWait time.Duration, deliver func(msg redis.XMessage)) {
+	ticker := time.NewTicker(ackWait / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+
+		ctx := context.Background()
+		pending, err := s.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: key,
+			Group:  s.serviceName,
+			Start:  "-",
+			End:    "+",
+			Count:  claimBatch,
+		}).Result()
+		if s.track(err) != nil {
+			continue
+		}
+
+		var ids []string
+		for _, p := range pending {
+			if p.Idle >= ackWait {
+				ids = append(ids, p.ID)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		// XCLAIM checks the idle time again, so a message claimed meanwhile by another instance is skipped.
+		msgs, err := s.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   key,
+			Group:    s.serviceName,
+			Consumer: s.consumer,
+			MinIdle:  ackWait,
+			Messages: ids,
+		}).Result()
+		if s.track(err) != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			deliver(msg)
+		}
+	}
+}
+
+func (s *redisStore) Request(topic string, payload []byte, v interface{}, opts ...axon.RequestOption) error {
+	if timeout := s.opts.Topic(topic).RequestTimeout; timeout > 0 {
+		opts = append([]axon.RequestOption{axon.WithTimeout(timeout)}, opts...)
+	}
+	req := axon.NewRequestPayload(topic, payload, opts...)
+	req.ServiceName = s.serviceName
+	req.ReplyPipe = s.prefix + "inbox:" + axon.GenerateRandomString()
+	data, err := req.Compact()
+	if err != nil {
+		return err
+	}
+
+	timeout := req.Timeout(defaultRequestTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.add(s.requestKey(topic), data); err != nil {
+		return err
+	}
+
+	// BLPOP counts in whole seconds, so the context enforces the exact timeout.
+	result, err := s.client.BLPop(ctx, timeout.Round(time.Second)+time.Second, req.ReplyPipe).Result()
+	if ctx.Err() != nil || errors.Is(err, redis.Nil) {
+		return context.DeadlineExceeded
+	}
+	if err != nil {
+		return s.track(err)
+	}
+
+	var reply axon.ReplyPayload
+	if err := json.Unmarshal([]byte(result[1]), &reply); err != nil {
+		log.Print("failed to unmarshal reply event into reply struct with the following errors: ", err)
+		return err
+	}
+	req.SetReplyHeaders(reply.Headers)
+	if replyErr := reply.GetError(); replyErr != nil {
+		return replyErr
+	}
+	return json.Unmarshal(reply.GetPayload(), v)
+}
+
+func (s *redisStore) Reply(topic string, handler axon.ReplyHandler) error {
+	return s.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+// ReplyContext answers the requests made on topic, load balanced across the instances of the service, blocking
+// until the store is closed.
+func (s *redisStore) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	key := s.requestKey(topic)
+	if err := s.createGroup(key); err != nil {
+		return err
+	}
+
+	return s.consume(key, defaultBatch, func(msg redis.XMessage) {
+		// Requests are acknowledged as they are read: by the time one would be redelivered its caller gave up.
+		ctx := context.Background()
+		_ = s.client.XAck(ctx, key, s.serviceName, msg.ID).Err()
+
+		go func() {
+			req, out, err := axon.ServeRequest(topic, messageData(msg), handler)
+			if err != nil {
+				log.Print("failed to handle incoming request payload with the following error: ", err)
+				return
+			}
+
+			pipe := s.client.TxPipeline()
+			pipe.RPush(ctx, req.GetReplyAddress(), out)
+			pipe.Expire(ctx, req.GetReplyAddress(), inboxTTL)
+			if _, err := pipe.Exec(ctx); err != nil {
+				log.Print("failed to reply data to the incoming request with the following error: ", s.track(err))
+			}
+		}()
+	})
+}
+
+func (s *redisStore) Run(ctx context.Context, handlers ...axon.EventHandler) {
+	for _, handler := range handlers {
+		go handler.Run()
+	}
+
+	<-ctx.Done()
+}
+
+// Close closes the connection. Consumer groups, and the messages pending in them, are kept for other instances.
+func (s *redisStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.SetState(axon.StateClosed)
+		close(s.closed)
+		err = s.client.Close()
+	})
+	return err
+}
@@ -0,0 +1,179 @@
+package redisstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newStore(t *testing.T, m *miniredis.Miniredis, serviceName string, options ...Option) axon.EventStore {
+	store, err := Init(axon.Options{ServiceName: serviceName, Address: "redis://" + m.Addr()}, options...)
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func receive(t *testing.T, events <-chan axon.Event) axon.Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+// subscribe runs Subscribe in the background and waits for the consumer group of the service to exist.
+func subscribe(t *testing.T, store axon.EventStore, topic string, handler axon.SubscriptionHandler) {
+	go func() { _ = store.Subscribe(topic, handler) }()
+	require.Eventually(t, func() bool {
+		groups, err := store.(*redisStore).client.XInfoGroups(context.Background(), topic).Result()
+		if err != nil {
+			return false
+		}
+		for _, g := range groups {
+			if g.Name == store.GetServiceName() {
+				return true
+			}
+		}
+		return false
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	m := miniredis.RunT(t)
+	publisher := newStore(t, m, "publisher")
+	first, second := newStore(t, m, "orders"), newStore(t, m, "orders")
+	billing := newStore(t, m, "billing")
+
+	var mu sync.Mutex
+	counts := map[string]int{}
+	done := make(chan struct{}, 20)
+	handler := func(name string) axon.SubscriptionHandler {
+		return func(e axon.Event) {
+			assert.Equal(t, "order.created", e.Topic())
+			mu.Lock()
+			counts[name]++
+			mu.Unlock()
+			e.Ack()
+			done <- struct{}{}
+		}
+	}
+	subscribe(t, first, "order.created", handler("first"))
+	subscribe(t, second, "order.created", handler("second"))
+	subscribe(t, billing, "order.created", handler("billing"))
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, publisher.Publish("order.created", []byte("order")))
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 10, counts["first"]+counts["second"], "each message is delivered to one orders instance")
+	assert.Equal(t, 10, counts["billing"])
+}
+
+func TestRedeliveryAndDurableGroups(t *testing.T) {
+	m := miniredis.RunT(t)
+	publisher := newStore(t, m, "publisher")
+	subscriber := newStore(t, m, "orders", AckWait(100*time.Millisecond))
+
+	events := make(chan axon.Event, 4)
+	subscribe(t, subscriber, "order.created", func(e axon.Event) { events <- e })
+
+	require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+	first := receive(t, events)
+	redelivered := receive(t, events)
+	assert.Equal(t, first.Data(), redelivered.Data())
+	redelivered.Ack()
+
+	// Messages published while no instance is running wait in the group for the next one, which also claims
+	// those the closed instance read without acknowledging.
+	require.Nil(t, subscriber.Close())
+	require.Nil(t, publisher.Publish("order.created", []byte("#2")))
+
+	resumed := newStore(t, m, "orders", AckWait(100*time.Millisecond))
+	subscribe(t, resumed, "order.created", func(e axon.Event) { events <- e })
+	e := receive(t, events)
+	assert.Equal(t, "#2", string(e.Data()))
+	e.Ack()
+}
+
+func TestRequestReply(t *testing.T) {
+	m := miniredis.RunT(t)
+	caller := newStore(t, m, "caller")
+	replier := newStore(t, m, "greeter")
+
+	go func() {
+		_ = replier.ReplyContext("greet", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			var in struct{ Name string }
+			if err := req.ParsePayload(&in); err != nil {
+				return axon.Response{}, err
+			}
+			if in.Name == "" {
+				return axon.Response{}, errors.New("name is required")
+			}
+			if in.Name == "sleepy" {
+				time.Sleep(500 * time.Millisecond)
+			}
+			out, _ := json.Marshal(map[string]string{"greeting": "hello " + in.Name, "from": req.ServiceName})
+			return axon.Response{Payload: out}, nil
+		})
+	}()
+	require.Eventually(t, func() bool { return m.Exists("greet:requests") }, 2*time.Second, 10*time.Millisecond)
+
+	var out map[string]string
+	require.Nil(t, caller.Request("greet", []byte(`{"Name":"axon"}`), &out))
+	assert.Equal(t, map[string]string{"greeting": "hello axon", "from": "caller"}, out)
+
+	err := caller.Request("greet", []byte(`{}`), &out)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "name is required")
+
+	err = caller.Request("greet", []byte(`{"Name":"sleepy"}`), &out, axon.WithTimeout(100*time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestOpen(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.RequireUserAuth("axon", "pa55")
+
+	_, err := axon.Open("redis://"+m.Addr(), axon.Options{ServiceName: "orders"})
+	assert.NotNil(t, err)
+
+	store, err := axon.Open("redis://"+m.Addr(), axon.Options{
+		ServiceName: "orders",
+		Auth:        &axon.AuthOptions{Username: "axon", Password: "pa55"},
+	})
+	require.Nil(t, err)
+	assert.Nil(t, store.Health())
+	require.Nil(t, store.Close())
+	assert.Equal(t, axon.ErrCloseConn, store.Health())
+}
+
+func TestConformance(t *testing.T) {
+	m := miniredis.RunT(t)
+	axontest.RunConformance(t, func(t *testing.T, opts axon.Options) axon.EventStore {
+		opts.Address = "redis://" + m.Addr()
+		store, err := Init(opts)
+		require.Nil(t, err)
+		t.Cleanup(func() { _ = store.Close() })
+		return store
+	}, axontest.AckWait(200*time.Millisecond))
+}
@@ -0,0 +1,15 @@
+package redisstream
+
+import (
+	"github.com/Just4Ease/axon"
+	"net/url"
+)
+
+func init() {
+	axon.Register("redis", open)
+	axon.Register("rediss", open)
+}
+
+func open(u *url.URL, opts axon.Options) (axon.EventStore, error) {
+	return Init(opts)
+}
@@ -0,0 +1,70 @@
+package axon
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory creates an EventStore for a URL whose scheme it was registered for. opts.Address holds the full URL.
+type Factory func(u *url.URL, opts Options) (EventStore, error)
+
+var ErrUnknownScheme = errors.New("Sorry, no event store backend is registered for this URL scheme")
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a backend available to Open under scheme. Backends call it from their init function, so a
+// backend must be imported, if only for side effects, before Open can use it. Register panics if a scheme is
+// registered twice or factory is nil.
+func Register(scheme string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if factory == nil {
+		panic("axon: Register factory is nil")
+	}
+	if _, dup := factories[scheme]; dup {
+		panic("axon: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Schemes returns the sorted list of registered URL schemes.
+func Schemes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open creates an EventStore with the backend registered for the scheme of rawURL, such as
+// `pulsar://localhost:6650`, `nats://localhost:4222?cluster=test-cluster` or `mem://`.
+func Open(rawURL string, opts Options) (EventStore, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return nil, ErrInvalidURL
+	}
+
+	factoriesMu.RLock()
+	factory, ok := factories[strings.ToLower(u.Scheme)]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownScheme, fmt.Sprintf("%q (registered: %s)", u.Scheme, strings.Join(Schemes(), ", ")))
+	}
+
+	opts.Address = rawURL
+	return factory(u, opts)
+}
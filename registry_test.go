@@ -0,0 +1,40 @@
+package axon_test
+
+import (
+	"github.com/Just4Ease/axon"
+	_ "github.com/Just4Ease/axon/mem"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	store, err := axon.Open("mem://registry-test", axon.Options{ServiceName: "orders"})
+	assert.Nil(t, err)
+	assert.Equal(t, "orders", store.GetServiceName())
+	assert.Nil(t, store.Close())
+
+	_, err = axon.Open("kafka://localhost:9092", axon.Options{ServiceName: "orders"})
+	assert.True(t, errors.Is(err, axon.ErrUnknownScheme))
+
+	_, err = axon.Open("localhost:4222", axon.Options{ServiceName: "orders"})
+	assert.NotNil(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	var got string
+	axon.Register("registry-test", func(u *url.URL, opts axon.Options) (axon.EventStore, error) {
+		got = opts.Address
+		return nil, nil
+	})
+	assert.Contains(t, axon.Schemes(), "registry-test")
+
+	_, err := axon.Open("registry-test://host/path?x=1", axon.Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, "registry-test://host/path?x=1", got)
+
+	assert.Panics(t, func() {
+		axon.Register("registry-test", func(u *url.URL, opts axon.Options) (axon.EventStore, error) { return nil, nil })
+	})
+}
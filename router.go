@@ -0,0 +1,144 @@
+package axon
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// PatternSubscriber is implemented by stores whose broker can subscribe to every topic matching a pattern,
+// including topics created after the subscription started.
+type PatternSubscriber interface {
+	SubscribePattern(pattern *regexp.Regexp, handler SubscriptionHandler) error
+}
+
+type route struct {
+	pattern *regexp.Regexp
+	handler SubscriptionHandler
+}
+
+// Router registers subscription handlers by topic pattern and mounts all of them on an EventStore in one call.
+//
+// Stores implementing PatternSubscriber get one subscription per route. Other stores only subscribe to the
+// declared topics, each declared topic being handled by the first route matching it.
+type Router struct {
+	mu     sync.RWMutex
+	routes []route
+	topics []string
+}
+
+func NewRouter(topics ...string) *Router {
+	return &Router{topics: topics}
+}
+
+// Declare adds topics that patterns are expanded against on stores that do not support pattern subscriptions.
+func (r *Router) Declare(topics ...string) *Router {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.topics = append(r.topics, topics...)
+	return r
+}
+
+// Handle registers handler for a NATS-style pattern, where `*` matches exactly one dot-separated token and a
+// trailing `>` matches one or more tokens, such as `orders.*` or `orders.>`.
+func (r *Router) Handle(pattern string, handler SubscriptionHandler) error {
+	expr, err := subjectToRegexp(pattern)
+	if err != nil {
+		return err
+	}
+	return r.HandleRegexp(expr, handler)
+}
+
+// HandleRegexp registers handler for every topic matching the regular expression expr in full.
+func (r *Router) HandleRegexp(expr string, handler SubscriptionHandler) error {
+	if !strings.HasPrefix(expr, "^") {
+		expr = "^" + expr
+	}
+	if !strings.HasSuffix(expr, "$") {
+		expr = expr + "$"
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid topic pattern %q: %v", expr, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, route{pattern: pattern, handler: handler})
+	return nil
+}
+
+// Match returns the handler of the first route matching topic.
+func (r *Router) Match(topic string) (SubscriptionHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rt := range r.routes {
+		if rt.pattern.MatchString(topic) {
+			return rt.handler, true
+		}
+	}
+	return nil, false
+}
+
+// Mount subscribes every route on store and blocks until one of the subscriptions returns.
+func (r *Router) Mount(store EventStore) error {
+	r.mu.RLock()
+	routes := append([]route(nil), r.routes...)
+	topics := append([]string(nil), r.topics...)
+	r.mu.RUnlock()
+
+	errChan := make(chan error, len(routes)+len(topics))
+	subscriptions := 0
+	if ps, ok := store.(PatternSubscriber); ok {
+		for _, rt := range routes {
+			subscriptions++
+			go func(rt route) {
+				errChan <- ps.SubscribePattern(rt.pattern, rt.handler)
+			}(rt)
+		}
+	} else {
+		seen := make(map[string]bool)
+		for _, topic := range topics {
+			if seen[topic] {
+				continue
+			}
+			seen[topic] = true
+
+			handler, ok := r.Match(topic)
+			if !ok {
+				continue
+			}
+			subscriptions++
+			go func(topic string, handler SubscriptionHandler) {
+				errChan <- store.Subscribe(topic, handler)
+			}(topic, handler)
+		}
+	}
+
+	if subscriptions == 0 {
+		return ErrNoRoutes
+	}
+	return <-errChan
+}
+
+func subjectToRegexp(subject string) (string, error) {
+	tokens := strings.Split(subject, ".")
+	parts := make([]string, 0, len(tokens))
+	for i, token := range tokens {
+		switch token {
+		case "":
+			return "", fmt.Errorf("invalid topic pattern %q: empty token", subject)
+		case "*":
+			parts = append(parts, `[^.]+`)
+		case ">":
+			if i != len(tokens)-1 {
+				return "", fmt.Errorf("invalid topic pattern %q: '>' must be the last token", subject)
+			}
+			parts = append(parts, `.+`)
+		default:
+			parts = append(parts, regexp.QuoteMeta(token))
+		}
+	}
+	return "^" + strings.Join(parts, `\.`) + "$", nil
+}
@@ -0,0 +1,78 @@
+package axon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type subscribeRecorder struct {
+	EventStore
+	mu     sync.Mutex
+	topics []string
+	calls  chan struct{}
+	done   chan struct{}
+}
+
+func (s *subscribeRecorder) Subscribe(topic string, handler SubscriptionHandler) error {
+	s.mu.Lock()
+	s.topics = append(s.topics, topic)
+	s.mu.Unlock()
+	s.calls <- struct{}{}
+	<-s.done
+	return ErrCloseConn
+}
+
+func TestRouter_Match(t *testing.T) {
+	r := NewRouter()
+	var matched string
+	assert.Nil(t, r.Handle("orders.*", func(event Event) { matched = "orders.*" }))
+	assert.Nil(t, r.Handle("payments.>", func(event Event) { matched = "payments.>" }))
+	assert.Nil(t, r.HandleRegexp(`users\.(created|deleted)`, func(event Event) { matched = "users" }))
+
+	cases := map[string]string{
+		"orders.created":          "orders.*",
+		"payments.card.captured":  "payments.>",
+		"users.deleted":           "users",
+		"orders.created.archived": "",
+		"payments":                "",
+		"users.updated":           "",
+	}
+	for topic, want := range cases {
+		matched = ""
+		handler, ok := r.Match(topic)
+		assert.Equal(t, want != "", ok, topic)
+		if ok {
+			handler(nil)
+		}
+		assert.Equal(t, want, matched, topic)
+	}
+
+	assert.NotNil(t, r.Handle("orders.>.created", nil))
+	assert.NotNil(t, r.Handle("orders..created", nil))
+}
+
+func TestRouter_MountExpandsDeclaredTopics(t *testing.T) {
+	store := &subscribeRecorder{calls: make(chan struct{}, 4), done: make(chan struct{})}
+	r := NewRouter("orders.created", "orders.paid", "users.created", "orders.created")
+	assert.Nil(t, r.Handle("orders.*", func(event Event) {}))
+
+	errChan := make(chan error)
+	go func() { errChan <- r.Mount(store) }()
+	<-store.calls
+	<-store.calls
+	close(store.done)
+	assert.Equal(t, ErrCloseConn, <-errChan)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	sort.Strings(store.topics)
+	assert.Equal(t, []string{"orders.created", "orders.paid"}, store.topics)
+}
+
+func TestRouter_MountWithoutMatches(t *testing.T) {
+	r := NewRouter("users.created")
+	assert.Nil(t, r.Handle("orders.*", func(event Event) {}))
+	assert.Equal(t, ErrNoRoutes, r.Mount(&subscribeRecorder{}))
+}
@@ -0,0 +1,335 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// instanceLock serializes the progress of one saga.
+type instanceLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Saga orchestrates the steps of one kind of saga.
+type Saga struct {
+	name   string
+	store  axon.EventStore
+	states StateStore
+	steps  []Step
+
+	mu    sync.Mutex
+	locks map[string]*instanceLock
+}
+
+// New returns the saga name running steps through store, whose states are kept by states. The name tells the
+// sagas of different kinds apart in states, and should not change between restarts.
+func New(name string, store axon.EventStore, states StateStore, steps ...Step) *Saga {
+	return &Saga{
+		name:   name,
+		store:  store,
+		states: states,
+		steps:  steps,
+		locks:  make(map[string]*instanceLock),
+	}
+}
+
+// Start starts the saga id with data, encoded as JSON, and runs its steps until one awaits a result or the saga
+// ends. It returns the state reached, failing with ErrSagaExists when id was already started.
+func (s *Saga) Start(ctx context.Context, id string, data interface{}) (State, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return State{}, errors.Wrap(err, "unable to encode the data of the saga")
+	}
+
+	unlock := s.lock(id)
+	defer unlock()
+	if _, err := s.states.LoadState(ctx, s.name, id); err != ErrSagaNotFound {
+		if err == nil {
+			return State{}, ErrSagaExists
+		}
+		return State{}, err
+	}
+
+	now := time.Now().UTC()
+	state := State{Saga: s.name, ID: id, Status: StatusRunning, Data: raw, Started: now}
+	if err := s.save(ctx, &state); err != nil {
+		if errors.Is(err, ErrStateConflict) {
+			return State{}, ErrSagaExists // Started by another process since it was looked up.
+		}
+		return State{}, err
+	}
+	err = s.advance(ctx, &state)
+	return state, err
+}
+
+// State returns the state of the saga id, or ErrSagaNotFound.
+func (s *Saga) State(ctx context.Context, id string) (State, error) {
+	return s.states.LoadState(ctx, s.name, id)
+}
+
+// Run resumes the sagas left unfinished by a previous run, then subscribes to the result topics of the steps
+// until ctx is done or a subscription fails. Results are acknowledged once the state they lead to is saved.
+func (s *Saga) Run(ctx context.Context) error {
+	if err := s.resume(ctx); err != nil {
+		return err
+	}
+
+	topics := make(map[string]bool)
+	for _, step := range s.steps {
+		for _, topic := range []string{step.Success, step.Failure} {
+			if topic != "" {
+				topics[topic] = true
+			}
+		}
+	}
+	errs := make(chan error, len(topics))
+	for topic := range topics {
+		go func(topic string) {
+			errs <- s.store.Subscribe(topic, func(e axon.Event) {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := s.handle(ctx, topic, e); err != nil {
+					log.Printf("saga %s failed to handle a message of %s with the following error: %v", s.name, topic, err)
+				}
+			})
+		}(topic)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errs:
+		return err
+	}
+}
+
+// resume carries on with the unfinished sagas, whose step or compensation was interrupted or which await a
+// result with a deadline.
+func (s *Saga) resume(ctx context.Context) error {
+	states, err := s.states.Unfinished(ctx, s.name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load the unfinished sagas of %s", s.name)
+	}
+	for _, state := range states {
+		state := state
+		unlock := s.lock(state.ID)
+		switch state.Status {
+		case StatusRunning:
+			err = s.advance(ctx, &state)
+		case StatusCompensating:
+			err = s.undo(ctx, &state)
+		case StatusAwaiting:
+			s.schedule(state)
+		}
+		unlock()
+		if err != nil {
+			return errors.Wrapf(err, "unable to resume saga %s", state.ID)
+		}
+	}
+	return nil
+}
+
+// handle moves the saga a result Message is correlated to past its awaited step. Results of sagas of another
+// kind, or which no longer await them, are acknowledged only.
+func (s *Saga) handle(ctx context.Context, topic string, e axon.Event) error {
+	msg, err := DecodeMessage(e.Data())
+	if err != nil {
+		log.Printf("saga %s ignored a message of %s which is not correlated to a saga", s.name, topic)
+		e.Ack()
+		return nil
+	}
+
+	unlock := s.lock(msg.CorrelationID)
+	defer unlock()
+	state, err := s.states.LoadState(ctx, s.name, msg.CorrelationID)
+	if err == ErrSagaNotFound {
+		e.Ack()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if state.Status == StatusRunning && state.Step < len(s.steps) && s.steps[state.Step].reports(topic) {
+		// The result arrived while another orchestrator runs the action of the step, before it saved the saga
+		// as awaiting. It is left unacknowledged to be delivered again.
+		return nil
+	}
+	if state.Status != StatusAwaiting {
+		e.Ack()
+		return nil
+	}
+
+	step := s.steps[state.Step]
+	switch topic {
+	case step.Success:
+		if step.OnSuccess != nil {
+			if err := step.OnSuccess(s.context(ctx, &state), msg); err != nil {
+				// The step took effect, so it is compensated along with the ones before it.
+				err = s.compensate(ctx, &state, state.Step, errors.Wrapf(err, "step %s failed", step.Name))
+				return s.ack(e, err)
+			}
+		}
+		state.Step++
+		state.Status = StatusRunning
+		state.Deadline = time.Time{}
+		if err := s.save(ctx, &state); err != nil {
+			return err
+		}
+		e.Ack()
+		return s.advance(ctx, &state)
+	case step.Failure:
+		err := s.compensate(ctx, &state, state.Step-1, errors.Wrapf(ErrStepFailed, "step %s failed with %s", step.Name, msg.Data))
+		return s.ack(e, err)
+	}
+	e.Ack()
+	return nil
+}
+
+// ack acknowledges e unless err tells the state it led to was not saved.
+func (s *Saga) ack(e axon.Event, err error) error {
+	if err == nil {
+		e.Ack()
+	}
+	return err
+}
+
+// advance runs the steps of a running saga until one awaits a result, fails or the saga completes. The caller
+// holds the lock of the saga.
+func (s *Saga) advance(ctx context.Context, state *State) error {
+	for state.Status == StatusRunning {
+		if state.Step >= len(s.steps) {
+			state.Status = StatusCompleted
+			return s.save(ctx, state)
+		}
+
+		step := s.steps[state.Step]
+		if step.Action != nil {
+			if err := step.Action(s.context(ctx, state)); err != nil {
+				return s.compensate(ctx, state, state.Step-1, errors.Wrapf(err, "step %s failed", step.Name))
+			}
+		}
+		if step.awaits() {
+			state.Status = StatusAwaiting
+			if step.Timeout > 0 {
+				state.Deadline = time.Now().Add(step.Timeout).UTC()
+			}
+			if err := s.save(ctx, state); err != nil {
+				return err
+			}
+			s.schedule(*state)
+			return nil
+		}
+		state.Step++
+		if err := s.save(ctx, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compensate undoes the steps from the step from backwards, after a failure. The caller holds the lock of the
+// saga.
+func (s *Saga) compensate(ctx context.Context, state *State, from int, cause error) error {
+	state.Status = StatusCompensating
+	state.Error = cause.Error()
+	state.Deadline = time.Time{}
+	state.Step = from
+	return s.undo(ctx, state)
+}
+
+// undo runs the compensating actions of a compensating saga from its current step backwards, saving its
+// progress before each of them. The caller holds the lock of the saga.
+func (s *Saga) undo(ctx context.Context, state *State) error {
+	for ; state.Step >= 0; state.Step-- {
+		step := s.steps[state.Step]
+		if step.Compensate == nil {
+			continue
+		}
+		if err := s.save(ctx, state); err != nil {
+			return err
+		}
+		if err := step.Compensate(s.context(ctx, state)); err != nil {
+			state.Status = StatusFailed
+			state.Error += "; " + errors.Wrapf(err, "compensating step %s failed", step.Name).Error()
+			return s.save(ctx, state)
+		}
+	}
+	state.Step = 0
+	state.Status = StatusCompensated
+	return s.save(ctx, state)
+}
+
+// schedule fails the awaited step of a saga once its deadline passes without result.
+func (s *Saga) schedule(state State) {
+	if state.Deadline.IsZero() {
+		return
+	}
+	time.AfterFunc(time.Until(state.Deadline), func() {
+		if err := s.expire(state.ID, state.Step); err != nil {
+			log.Printf("saga %s failed to time out step %d of %s with the following error: %v", s.name, state.Step, state.ID, err)
+		}
+	})
+}
+
+// expire compensates the saga id if it still awaits step past its deadline.
+func (s *Saga) expire(id string, step int) error {
+	ctx := context.Background()
+	unlock := s.lock(id)
+	defer unlock()
+	state, err := s.states.LoadState(ctx, s.name, id)
+	if err != nil {
+		return err
+	}
+	if state.Status != StatusAwaiting || state.Step != step || time.Now().Before(state.Deadline) {
+		return nil
+	}
+	return s.compensate(ctx, &state, step-1, errors.Wrapf(ErrStepTimeout, "step %s failed", s.steps[step].Name))
+}
+
+func (s *Saga) context(ctx context.Context, state *State) *Context {
+	c := &Context{Context: ctx, ID: state.ID, saga: s, state: state}
+	if state.Step >= 0 && state.Step < len(s.steps) {
+		c.Step = s.steps[state.Step].Name
+	}
+	return c
+}
+
+// save saves state and moves it to the revision saved. It fails with ErrStateConflict when another orchestrator
+// saved the saga since state was loaded, leaving the event being handled to be delivered again.
+func (s *Saga) save(ctx context.Context, state *State) error {
+	state.Updated = time.Now().UTC()
+	if err := s.states.SaveState(ctx, *state); err != nil {
+		return errors.Wrapf(err, "unable to save the state of saga %s", state.ID)
+	}
+	state.Revision++
+	return nil
+}
+
+// lock locks the saga id and returns its unlock function.
+func (s *Saga) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &instanceLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
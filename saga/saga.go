@@ -0,0 +1,184 @@
+// Package saga coordinates processes spanning several services on top of axon.
+//
+// A saga runs its steps in order. A step acts through an axon Request or Publish, and may then wait for an event
+// reporting its result. When a step fails, the steps completed before it are undone by their compensating actions
+// in reverse order. The state of each saga is persisted after every step, and the messages it exchanges carry its
+// id, so a restarted orchestrator resumes where it stopped.
+//
+//	orders := saga.New("order", store, states,
+//		saga.Step{
+//			Name:       "payment",
+//			Action:     func(c *saga.Context) error { return c.Publish("payments.charge", charge) },
+//			Success:    "payments.charged",
+//			Failure:    "payments.declined",
+//			Compensate: func(c *saga.Context) error { return c.Publish("payments.refund", refund) },
+//		},
+//		saga.Step{
+//			Name:   "shipping",
+//			Action: func(c *saga.Context) error { return c.Request("shipping.schedule", order, &shipment) },
+//		},
+//	)
+//	go orders.Run(ctx)
+//	_, err := orders.Start(ctx, orderID, order)
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"time"
+)
+
+var (
+	ErrSagaNotFound         = errors.New("Sorry, no saga was started with this id")
+	ErrSagaExists           = errors.New("Sorry, a saga was already started with this id")
+	ErrStepFailed           = errors.New("Sorry, the step reported a failure")
+	ErrStepTimeout          = errors.New("Sorry, the step reported no result in time")
+	ErrMissingCorrelationID = errors.New("Sorry, the message is not correlated to a saga")
+	ErrStateConflict        = errors.New("Sorry, the state of the saga was changed since it was loaded")
+)
+
+// CorrelationHeader is the request header naming the saga a request is sent by. The correlation id of the
+// request is the id of the saga.
+const CorrelationHeader = "axon-saga"
+
+// Status is the stage a saga is at.
+type Status string
+
+const (
+	StatusRunning      Status = "running"
+	StatusAwaiting     Status = "awaiting" // Waiting for the result event of a step.
+	StatusCompleted    Status = "completed"
+	StatusCompensating Status = "compensating"
+	StatusCompensated  Status = "compensated" // A step failed and the steps completed before it were undone.
+	StatusFailed       Status = "failed"      // A compensating action failed too, the saga needs a human.
+)
+
+// State is the persisted state of a saga.
+type State struct {
+	Saga     string          `json:"saga"`
+	ID       string          `json:"id"`
+	Status   Status          `json:"status"`
+	Step     int             `json:"step"` // Index of the step running, awaited or to compensate next.
+	Data     json.RawMessage `json:"data"`
+	Error    string          `json:"error,omitempty"`    // Why the saga was compensated or failed.
+	Deadline time.Time       `json:"deadline,omitempty"` // When the awaited step times out, zero for never.
+	Started  time.Time       `json:"started"`
+	Updated  time.Time       `json:"updated"`
+	Revision uint64          `json:"revision"` // Times the state was saved, zero for a saga not saved yet.
+}
+
+// Decode unmarshals the data of the saga into v.
+func (s State) Decode(v interface{}) error {
+	return json.Unmarshal(s.Data, v)
+}
+
+// Done tells whether the saga completed, was compensated or failed.
+func (s State) Done() bool {
+	return s.Status == StatusCompleted || s.Status == StatusCompensated || s.Status == StatusFailed
+}
+
+// Message is the envelope of the events exchanged with the participants of a saga, correlated by its id.
+type Message struct {
+	CorrelationID string          `json:"correlation_id"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Decode unmarshals the data of the message into v.
+func (m Message) Decode(v interface{}) error {
+	return json.Unmarshal(m.Data, v)
+}
+
+// DecodeMessage decodes the Message published on a topic, for participants to handle the commands of a saga.
+func DecodeMessage(data []byte) (Message, error) {
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Message{}, err
+	}
+	if m.CorrelationID == "" {
+		return Message{}, ErrMissingCorrelationID
+	}
+	return m, nil
+}
+
+// Publish publishes data, encoded as JSON, on topic in a Message correlated to the saga id. Participants use it
+// to report the result of a step.
+func Publish(store axon.EventStore, topic, id string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(Message{CorrelationID: id, Data: raw})
+	if err != nil {
+		return err
+	}
+	return store.Publish(topic, msg)
+}
+
+// Step is a step of a saga.
+type Step struct {
+	Name string
+	// Action performs the step, failing the saga when it returns an error. Actions may run again when the
+	// orchestrator restarts in the middle of a step, so they should be idempotent.
+	Action func(c *Context) error
+	// Compensate undoes the step when a later step fails. Steps without side effects leave it nil.
+	Compensate func(c *Context) error
+	// Success and Failure name the topics of the Messages the step waits for after its action. A step without
+	// them completes once its action returns.
+	Success string
+	Failure string
+	// OnSuccess handles the Message reporting the success of the step, usually to Update the data of the saga.
+	OnSuccess func(c *Context, result Message) error
+	// Timeout fails the step when no result arrived in time, zero for never.
+	Timeout time.Duration
+}
+
+func (s Step) awaits() bool {
+	return s.Success != "" || s.Failure != ""
+}
+
+// reports tells whether the Messages of topic report the result of the step.
+func (s Step) reports(topic string) bool {
+	return topic != "" && (topic == s.Success || topic == s.Failure)
+}
+
+// Context is handed to the actions of a step.
+type Context struct {
+	context.Context
+	ID    string // Id of the saga, correlating its messages.
+	Step  string
+	saga  *Saga
+	state *State
+}
+
+// Decode unmarshals the data of the saga into v.
+func (c *Context) Decode(v interface{}) error {
+	return c.state.Decode(v)
+}
+
+// Update replaces the data of the saga with v, encoded as JSON. It is persisted along with the step.
+func (c *Context) Update(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.state.Data = data
+	return nil
+}
+
+// Publish publishes data on topic in a Message correlated to the saga.
+func (c *Context) Publish(topic string, data interface{}) error {
+	return Publish(c.saga.store, topic, c.ID, data)
+}
+
+// Request sends data, encoded as JSON, to topic and decodes the reply into v. The request is correlated to the
+// saga by its correlation id and the CorrelationHeader.
+func (c *Context) Request(topic string, data interface{}, v interface{}, opts ...axon.RequestOption) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	opts = append([]axon.RequestOption{axon.WithCorrelationID(c.ID), axon.WithHeader(CorrelationHeader, c.saga.name)}, opts...)
+	return c.saga.store.Request(topic, payload, v, opts...)
+}
@@ -0,0 +1,379 @@
+package saga
+
+import (
+	"context"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/Just4Ease/axon/mem"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type order struct {
+	ID       string
+	Amount   int
+	ChargeID string
+}
+
+type charged struct {
+	ChargeID string
+}
+
+type shipment struct {
+	Tracking string
+}
+
+// flow is an order saga: stock is reserved locally, the payment service charges the order through events and
+// the shipping service schedules it through a request.
+type flow struct {
+	store    *axontest.Recorder
+	mu       sync.Mutex
+	reserved map[string]bool
+	shipped  map[string]string
+}
+
+func newFlow(t *testing.T) *flow {
+	store, err := mem.Init(axon.Options{ServiceName: "orders"}, mem.WithBroker(mem.NewBroker()))
+	require.Nil(t, err)
+	t.Cleanup(func() { store.Close() })
+	return &flow{store: axontest.Record(store), reserved: make(map[string]bool), shipped: make(map[string]string)}
+}
+
+func (f *flow) steps(paymentTimeout time.Duration) []Step {
+	return []Step{
+		{
+			Name: "stock",
+			Action: func(c *Context) error {
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				f.reserved[c.ID] = true
+				return nil
+			},
+			Compensate: func(c *Context) error {
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				delete(f.reserved, c.ID)
+				return nil
+			},
+		},
+		{
+			Name: "payment",
+			Action: func(c *Context) error {
+				var o order
+				if err := c.Decode(&o); err != nil {
+					return err
+				}
+				return c.Publish("payments.charge", o)
+			},
+			Success: "payments.charged",
+			Failure: "payments.declined",
+			OnSuccess: func(c *Context, result Message) error {
+				var o order
+				var ch charged
+				if err := c.Decode(&o); err != nil {
+					return err
+				}
+				if err := result.Decode(&ch); err != nil {
+					return err
+				}
+				o.ChargeID = ch.ChargeID
+				return c.Update(o)
+			},
+			Compensate: func(c *Context) error {
+				var o order
+				if err := c.Decode(&o); err != nil {
+					return err
+				}
+				return c.Publish("payments.refund", o)
+			},
+			Timeout: paymentTimeout,
+		},
+		{
+			Name: "shipping",
+			Action: func(c *Context) error {
+				var o order
+				if err := c.Decode(&o); err != nil {
+					return err
+				}
+				var s shipment
+				if err := c.Request("shipping.schedule", o, &s); err != nil {
+					return err
+				}
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				f.shipped[c.ID] = s.Tracking
+				return nil
+			},
+		},
+	}
+}
+
+// payments charges orders up to limit, reporting the result as a participant of the saga.
+func (f *flow) payments(t *testing.T, limit int) {
+	go f.store.Subscribe("payments.charge", func(e axon.Event) {
+		defer e.Ack()
+		msg, err := DecodeMessage(e.Data())
+		require.Nil(t, err)
+		var o order
+		require.Nil(t, msg.Decode(&o))
+		if o.Amount > limit {
+			require.Nil(t, Publish(f.store, "payments.declined", msg.CorrelationID, "insufficient funds"))
+			return
+		}
+		require.Nil(t, Publish(f.store, "payments.charged", msg.CorrelationID, charged{ChargeID: "ch-" + o.ID}))
+	})
+}
+
+// shipping schedules orders, or fails when err is set.
+func (f *flow) shipping(t *testing.T, err error) {
+	go f.store.ReplyContext("shipping.schedule", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+		assert.Equal(t, "order", req.Headers[CorrelationHeader])
+		if err != nil {
+			return axon.Response{}, err
+		}
+		return axon.Response{Payload: []byte(`{"Tracking":"track-` + req.CorrelationID + `"}`)}, nil
+	})
+}
+
+func waitStatus(t *testing.T, s *Saga, id string, status Status) State {
+	var state State
+	require.Eventually(t, func() bool {
+		var err error
+		state, err = s.State(context.Background(), id)
+		return err == nil && state.Status == status
+	}, 2*time.Second, 5*time.Millisecond)
+	return state
+}
+
+func TestSaga_Completes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFlow(t)
+	f.payments(t, 100)
+	f.shipping(t, nil)
+	orders := New("order", f.store, NewMemoryStateStore(), f.steps(0)...)
+	go orders.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	state, err := orders.Start(ctx, "o1", order{ID: "o1", Amount: 60})
+	require.Nil(t, err)
+	assert.Equal(t, StatusAwaiting, state.Status)
+	assert.Equal(t, 1, state.Step)
+
+	state = waitStatus(t, orders, "o1", StatusCompleted)
+	var o order
+	require.Nil(t, state.Decode(&o))
+	assert.Equal(t, "ch-o1", o.ChargeID)
+	assert.True(t, f.reserved["o1"])
+	assert.Equal(t, "track-o1", f.shipped["o1"])
+	assert.Len(t, f.store.Published("payments.charge"), 1)
+	assert.Empty(t, f.store.Published("payments.refund"))
+
+	_, err = orders.Start(ctx, "o1", order{ID: "o1"})
+	assert.Equal(t, ErrSagaExists, err)
+}
+
+func TestSaga_CompensatesFailedRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFlow(t)
+	f.payments(t, 100)
+	f.shipping(t, errors.New("no carrier available"))
+	orders := New("order", f.store, NewMemoryStateStore(), f.steps(0)...)
+	go orders.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	_, err := orders.Start(ctx, "o2", order{ID: "o2", Amount: 60})
+	require.Nil(t, err)
+
+	state := waitStatus(t, orders, "o2", StatusCompensated)
+	assert.Contains(t, state.Error, "step shipping failed")
+	assert.Contains(t, state.Error, "no carrier available")
+	refunds, err := f.store.WaitPublished("payments.refund", 1, time.Second)
+	require.Nil(t, err)
+	msg, err := DecodeMessage(refunds[0].Data)
+	require.Nil(t, err)
+	var o order
+	require.Nil(t, msg.Decode(&o))
+	assert.Equal(t, "o2", msg.CorrelationID)
+	assert.Equal(t, "ch-o2", o.ChargeID)
+	assert.False(t, f.reserved["o2"])
+}
+
+func TestSaga_CompensatesFailureEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFlow(t)
+	f.payments(t, 100)
+	orders := New("order", f.store, NewMemoryStateStore(), f.steps(0)...)
+	go orders.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	_, err := orders.Start(ctx, "o3", order{ID: "o3", Amount: 500})
+	require.Nil(t, err)
+
+	state := waitStatus(t, orders, "o3", StatusCompensated)
+	assert.Contains(t, state.Error, "insufficient funds")
+	assert.False(t, f.reserved["o3"])
+	assert.Empty(t, f.store.Published("payments.refund"))
+}
+
+func TestSaga_Timeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFlow(t)
+	orders := New("order", f.store, NewMemoryStateStore(), f.steps(50*time.Millisecond)...)
+	go orders.Run(ctx)
+
+	state, err := orders.Start(ctx, "o4", order{ID: "o4", Amount: 60})
+	require.Nil(t, err)
+	assert.False(t, state.Deadline.IsZero())
+
+	state = waitStatus(t, orders, "o4", StatusCompensated)
+	assert.Contains(t, state.Error, ErrStepTimeout.Error())
+	assert.False(t, f.reserved["o4"])
+}
+
+func TestSaga_CompensationFails(t *testing.T) {
+	ctx := context.Background()
+	f := newFlow(t)
+	steps := []Step{
+		{Name: "first", Action: func(c *Context) error { return nil }, Compensate: func(c *Context) error { return errors.New("refund refused") }},
+		{Name: "second", Action: func(c *Context) error { return errors.New("out of stock") }},
+	}
+	orders := New("order", f.store, NewMemoryStateStore(), steps...)
+
+	state, err := orders.Start(ctx, "o5", nil)
+	require.Nil(t, err)
+	assert.Equal(t, StatusFailed, state.Status)
+	assert.Equal(t, 0, state.Step)
+	assert.True(t, strings.Contains(state.Error, "out of stock") && strings.Contains(state.Error, "refund refused"))
+}
+
+func TestSaga_Resume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states, err := NewFileStateStore(t.TempDir())
+	require.Nil(t, err)
+	f := newFlow(t)
+	f.shipping(t, nil)
+	f.reserved["o6"] = true
+	time.Sleep(50 * time.Millisecond)
+
+	// A previous orchestrator stopped while awaiting the payment of o6, and in the middle of the shipping of o7.
+	now := time.Now().UTC()
+	require.Nil(t, states.SaveState(ctx, State{Saga: "order", ID: "o6", Status: StatusAwaiting, Step: 1, Data: []byte(`{"ID":"o6"}`), Started: now}))
+	require.Nil(t, states.SaveState(ctx, State{Saga: "order", ID: "o7", Status: StatusRunning, Step: 2, Data: []byte(`{"ID":"o7"}`), Started: now.Add(time.Second)}))
+	require.Nil(t, states.SaveState(ctx, State{Saga: "order", ID: "o8", Status: StatusCompleted, Step: 3, Started: now}))
+	unfinished, err := states.Unfinished(ctx, "order")
+	require.Nil(t, err)
+	require.Len(t, unfinished, 2)
+	assert.Equal(t, "o6", unfinished[0].ID)
+
+	orders := New("order", f.store, states, f.steps(0)...)
+	go orders.Run(ctx)
+	waitStatus(t, orders, "o7", StatusCompleted)
+	assert.Equal(t, "track-o7", f.shipped["o7"])
+	time.Sleep(50 * time.Millisecond)
+
+	require.Nil(t, Publish(f.store, "payments.charged", "o6", charged{ChargeID: "ch-o6"}))
+	state := waitStatus(t, orders, "o6", StatusCompleted)
+	var o order
+	require.Nil(t, state.Decode(&o))
+	assert.Equal(t, "ch-o6", o.ChargeID)
+
+	_, err = states.LoadState(ctx, "order", "o9")
+	assert.Equal(t, ErrSagaNotFound, err)
+}
+
+func TestStateStore_Conflict(t *testing.T) {
+	ctx := context.Background()
+	files, err := NewFileStateStore(t.TempDir())
+	require.Nil(t, err)
+	for name, states := range map[string]StateStore{"Memory": NewMemoryStateStore(), "File": files} {
+		t.Run(name, func(t *testing.T) {
+			state := State{Saga: "order", ID: "o9", Status: StatusRunning}
+			require.Nil(t, states.SaveState(ctx, state))
+			assert.Equal(t, ErrStateConflict, states.SaveState(ctx, state))
+
+			loaded, err := states.LoadState(ctx, "order", "o9")
+			require.Nil(t, err)
+			assert.Equal(t, uint64(1), loaded.Revision)
+			loaded.Status = StatusAwaiting
+			require.Nil(t, states.SaveState(ctx, loaded))
+			// A second orchestrator which loaded the saga before loses.
+			assert.Equal(t, ErrStateConflict, states.SaveState(ctx, loaded))
+		})
+	}
+}
+
+func TestSaga_StartRace(t *testing.T) {
+	states := NewMemoryStateStore()
+	var started sync.WaitGroup
+	step := Step{Name: "stock", Action: func(c *Context) error {
+		started.Done()
+		return nil
+	}}
+	// Orchestrators of two processes share the state store, but not their locks.
+	first, second := New("order", nil, states, step), New("order", nil, states, step)
+
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		started.Add(1)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		for _, s := range []*Saga{first, second} {
+			go func(s *Saga, id string) {
+				defer wg.Done()
+				_, err := s.Start(context.Background(), id, order{ID: id})
+				errs <- err
+			}(s, "o"+strings.Repeat("x", i))
+		}
+		wg.Wait()
+	}
+	started.Wait()
+
+	var exists int
+	for i := 0; i < 20; i++ {
+		if err := <-errs; err != nil {
+			assert.Equal(t, ErrSagaExists, err)
+			exists++
+		}
+	}
+	assert.Equal(t, 10, exists)
+}
+
+func TestSaga_ResultBeforeAwaiting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store, err := mem.Init(axon.Options{ServiceName: "orders"}, mem.WithBroker(mem.NewBroker()), mem.AckWait(100*time.Millisecond))
+	require.Nil(t, err)
+	defer store.Close()
+	states := NewMemoryStateStore()
+	steps := []Step{{
+		Name: "payment",
+		Action: func(c *Context) error {
+			// The participant answers before the step is saved as awaiting.
+			if err := Publish(store, "payments.charged", c.ID, charged{ChargeID: "ch-" + c.ID}); err != nil {
+				return err
+			}
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		},
+		Success: "payments.charged",
+		Failure: "payments.declined",
+	}}
+	// Orchestrators of two processes share the state store, and the result reaches the one not running the step.
+	first, second := New("order", store, states, steps...), New("order", store, states, steps...)
+	go second.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = first.Start(ctx, "o10", order{ID: "o10"})
+	require.Nil(t, err)
+	waitStatus(t, second, "o10", StatusCompleted)
+}
@@ -0,0 +1,195 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const stateExt = ".json"
+
+// StateStore keeps the states of sagas. Orchestrators of the same saga running in several processes share it,
+// so states are saved with optimistic concurrency.
+type StateStore interface {
+	// SaveState saves state at the next revision, failing with ErrStateConflict unless the state stored is still
+	// at state.Revision, zero meaning that none is.
+	SaveState(ctx context.Context, state State) error
+	// LoadState returns the state of the saga id of kind saga, or ErrSagaNotFound.
+	LoadState(ctx context.Context, saga, id string) (State, error)
+	// Unfinished returns the states of the sagas of kind saga which are not done, oldest first.
+	Unfinished(ctx context.Context, saga string) ([]State, error)
+}
+
+type memoryStateStore struct {
+	mu     sync.RWMutex
+	states map[string]map[string]State
+}
+
+// NewMemoryStateStore returns a StateStore keeping the states in memory, for tests and prototypes.
+func NewMemoryStateStore() StateStore {
+	return &memoryStateStore{states: make(map[string]map[string]State)}
+}
+
+func (s *memoryStateStore) SaveState(ctx context.Context, state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states[state.Saga] == nil {
+		s.states[state.Saga] = make(map[string]State)
+	}
+	if s.states[state.Saga][state.ID].Revision != state.Revision {
+		return ErrStateConflict
+	}
+	state.Revision++
+	s.states[state.Saga][state.ID] = state
+	return nil
+}
+
+func (s *memoryStateStore) LoadState(ctx context.Context, saga, id string) (State, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.states[saga][id]
+	if !ok {
+		return State{}, ErrSagaNotFound
+	}
+	return state, nil
+}
+
+func (s *memoryStateStore) Unfinished(ctx context.Context, saga string) ([]State, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var states []State
+	for _, state := range s.states[saga] {
+		if !state.Done() {
+			states = append(states, state)
+		}
+	}
+	sortStates(states)
+	return states, nil
+}
+
+type fileStateStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStateStore returns a StateStore keeping the state of each saga in a JSON file, under a directory of dir
+// per kind of saga. The directory is created when missing. Processes saving to the same directory lock it while
+// they compare revisions, where flock is available.
+func NewFileStateStore(dir string) (StateStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "unable to create the saga directory")
+	}
+	return &fileStateStore{dir: dir}, nil
+}
+
+func (s *fileStateStore) path(saga, id string) string {
+	return filepath.Join(s.dir, url.PathEscape(saga), url.PathEscape(id)+stateExt)
+}
+
+func (s *fileStateStore) SaveState(ctx context.Context, state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := filepath.Join(s.dir, url.PathEscape(state.Saga))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	unlock, err := lockFile(filepath.Join(dir, ".lock"))
+	if err != nil {
+		return errors.Wrap(err, "unable to lock the saga directory")
+	}
+	defer unlock()
+
+	stored, err := s.read(s.path(state.Saga, state.ID))
+	if err != nil && err != ErrSagaNotFound {
+		return err
+	}
+	if stored.Revision != state.Revision {
+		return ErrStateConflict
+	}
+	state.Revision++
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// The state replaces the previous one at once, so a crash never leaves half of it.
+	tmp, err := ioutil.TempFile(dir, ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(state.Saga, state.ID))
+}
+
+func (s *fileStateStore) LoadState(ctx context.Context, saga, id string) (State, error) {
+	return s.read(s.path(saga, id))
+}
+
+func (s *fileStateStore) read(path string) (State, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return State{}, ErrSagaNotFound
+	}
+	if err != nil {
+		return State{}, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return State{}, errors.Wrapf(err, "the state in %s is corrupt", path)
+	}
+	return state, nil
+}
+
+func (s *fileStateStore) Unfinished(ctx context.Context, saga string) ([]State, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, url.PathEscape(saga)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var states []State
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), stateExt) {
+			continue
+		}
+		state, err := s.read(filepath.Join(s.dir, url.PathEscape(saga), f.Name()))
+		if err == ErrSagaNotFound {
+			continue // Renamed over since listed.
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !state.Done() {
+			states = append(states, state)
+		}
+	}
+	sortStates(states)
+	return states, nil
+}
+
+func sortStates(states []State) {
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Started.Before(states[j].Started)
+	})
+}
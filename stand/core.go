@@ -0,0 +1,174 @@
+package stand
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/internal/natsconn"
+	"github.com/nats-io/nats.go"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coreStore implements axon.EventStore on core NATS alone, without a streaming cluster.
+//
+// Delivery is at-most-once: messages are not stored, so subscribers only receive what is published while they
+// are connected, Ack is a no-op and nothing is redelivered. It suits low latency ephemeral events and RPC.
+type coreStore struct {
+	*axon.StateTracker
+	natsClient  *nats.Conn
+	serviceName string
+	opts        axon.Options
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+func newCoreStore(nc *nats.Conn, tracker *axon.StateTracker, name string, opts axon.Options) *coreStore {
+	return &coreStore{
+		StateTracker: tracker,
+		natsClient:   nc,
+		serviceName:  name,
+		opts:         opts,
+		closed:       make(chan struct{}),
+	}
+}
+
+// InitCore connects to the NATS server at opts.Address without NATS Streaming. See coreStore for its
+// at-most-once delivery guarantees.
+func InitCore(opts axon.Options) (axon.EventStore, error) {
+	addr := strings.TrimSpace(opts.Address)
+	if addr == "" {
+		return nil, axon.ErrInvalidURL
+	}
+
+	name := strings.TrimSpace(opts.ServiceName)
+	if name == "" {
+		return nil, axon.ErrEmptyStoreName
+	}
+
+	connOptions, err := natsconn.Options(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := axon.NewStateTracker(axon.StateConnected)
+	connOptions = append(connOptions, nats.Name(name))
+	connOptions = append(connOptions, natsconn.TrackState(tracker, nil)...)
+	nc, err := nats.Connect(addr, connOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return newCoreStore(nc, tracker, name, opts), nil
+}
+
+func (s *coreStore) Publish(topic string, message []byte) error {
+	return s.natsClient.Publish(topic, message)
+}
+
+// Subscribe delivers the messages published on topic while the store is connected to one instance of the
+// service, blocking until the store is closed.
+func (s *coreStore) Subscribe(topic string, handler axon.SubscriptionHandler) error {
+	limiter := axon.NewLimiter(s.opts.Topic(topic).Concurrency)
+	_, err := s.natsClient.QueueSubscribe(topic, s.serviceName, func(msg *nats.Msg) {
+		limiter.Go(handler, newNatsEvent(msg))
+	})
+	if err != nil {
+		return err
+	}
+	<-s.closed
+	return axon.ErrCloseConn
+}
+
+func (s *coreStore) Request(requestURI string, payload []byte, v interface{}, opts ...axon.RequestOption) error {
+	nc := s.natsClient
+
+	if timeout := s.opts.Topic(requestURI).RequestTimeout; timeout > 0 {
+		opts = append([]axon.RequestOption{axon.WithTimeout(timeout)}, opts...)
+	}
+	req := axon.NewRequestPayload(requestURI, payload, opts...)
+	req.ServiceName = s.serviceName
+	data, err := req.Compact()
+	if err != nil {
+		return err
+	}
+	msg, err := nc.Request(requestURI, data, req.Timeout(time.Second*1))
+	if err != nil {
+		log.Print("Error making eventful request: ", err)
+		return err
+	}
+
+	event := newNatsEvent(msg)
+	var reply axon.ReplyPayload
+	if err := json.Unmarshal(event.Data(), &reply); err != nil {
+		log.Print("failed to unmarshal reply event into reply struct with the following errors: ", err)
+		event.Ack()
+		return err
+	}
+
+	// Check if reply has an issue.
+	req.SetReplyHeaders(reply.Headers)
+	if replyErr := reply.GetError(); replyErr != nil {
+		event.Ack()
+		return replyErr
+	}
+
+	// Unpack Reply's payload.
+	if err := json.Unmarshal(reply.GetPayload(), v); err != nil {
+		log.Print("failed to unmarshal reply payload into struct with the following errors: ", err)
+		event.Ack()
+		return err
+	}
+
+	event.Ack()
+	return nil
+}
+
+func (s *coreStore) Reply(topic string, handler axon.ReplyHandler) error {
+	return s.ReplyContext(topic, axon.WrapReplyHandler(handler))
+}
+
+// ReplyContext answers the requests made on topic, load balanced across the instances of the service, blocking
+// until the store is closed.
+func (s *coreStore) ReplyContext(topic string, handler axon.ContextReplyHandler) error {
+	_, err := s.natsClient.QueueSubscribe(topic, s.serviceName, func(msg *nats.Msg) {
+		event := newNatsEvent(msg)
+		_, data, err := axon.ServeRequest(topic, event.Data(), handler)
+		if err != nil {
+			log.Print("failed to handle incoming request payload with the following error: ", err)
+			return
+		}
+
+		if err := msg.Respond(data); err != nil {
+			log.Print("failed to reply data to the incoming request with the following error: ", err)
+			return
+		}
+	})
+	if err != nil {
+		return err
+	}
+	<-s.closed
+	return axon.ErrCloseConn
+}
+
+func (s *coreStore) GetServiceName() string {
+	return s.serviceName
+}
+
+func (s *coreStore) Run(ctx context.Context, handlers ...axon.EventHandler) {
+	for _, handler := range handlers {
+		go handler.Run()
+	}
+
+	<-ctx.Done()
+}
+
+func (s *coreStore) Close() error {
+	s.closeOnce.Do(func() {
+		s.natsClient.Close()
+		s.SetState(axon.StateClosed)
+		close(s.closed)
+	})
+	return nil
+}
@@ -0,0 +1,120 @@
+package stand
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func runServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	require.Nil(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second), "nats server did not start")
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func openCoreStore(t *testing.T, s *server.Server, serviceName string) axon.EventStore {
+	store, err := axon.Open("nats+core://"+s.Addr().String(), axon.Options{ServiceName: serviceName})
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestCore_PublishSubscribe(t *testing.T) {
+	s := runServer(t)
+	publisher := openCoreStore(t, s, "publisher")
+	first, second := openCoreStore(t, s, "orders"), openCoreStore(t, s, "orders")
+	billing := openCoreStore(t, s, "billing")
+
+	var mu sync.Mutex
+	counts := map[string]int{}
+	done := make(chan struct{}, 20)
+	handler := func(name string) axon.SubscriptionHandler {
+		return func(e axon.Event) {
+			mu.Lock()
+			counts[name]++
+			mu.Unlock()
+			e.Ack()
+			done <- struct{}{}
+		}
+	}
+	go func() { _ = first.Subscribe("order.created", handler("orders")) }()
+	go func() { _ = second.Subscribe("order.created", handler("orders")) }()
+	go func() { _ = billing.Subscribe("order.created", handler("billing")) }()
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, publisher.Publish("order.created", []byte("order")))
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{"orders": 10, "billing": 10}, counts, "each service receives every message once")
+}
+
+func TestCore_RequestReply(t *testing.T) {
+	s := runServer(t)
+	caller := openCoreStore(t, s, "caller")
+	replier := openCoreStore(t, s, "greeter")
+
+	go func() {
+		_ = replier.ReplyContext("greet", func(ctx context.Context, req axon.Request) (axon.Response, error) {
+			var in struct{ Name string }
+			if err := req.ParsePayload(&in); err != nil || in.Name == "" {
+				return axon.Response{}, errors.New("name is required")
+			}
+			out, _ := json.Marshal(map[string]string{"greeting": "hello " + in.Name, "from": req.ServiceName})
+			return axon.Response{Payload: out}, nil
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	var out map[string]string
+	require.Nil(t, caller.Request("greet", []byte(`{"Name":"axon"}`), &out))
+	assert.Equal(t, map[string]string{"greeting": "hello axon", "from": "caller"}, out)
+
+	err := caller.Request("greet", []byte(`{}`), &out)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "name is required")
+}
+
+func TestCore_Close(t *testing.T) {
+	store := openCoreStore(t, runServer(t), "orders")
+	assert.Nil(t, store.Health())
+
+	subscribed := make(chan error, 1)
+	go func() { subscribed <- store.Subscribe("order.created", func(axon.Event) {}) }()
+	time.Sleep(50 * time.Millisecond)
+
+	require.Nil(t, store.Close())
+	assert.Equal(t, axon.ErrCloseConn, store.Health())
+	assert.Equal(t, axon.ErrCloseConn, <-subscribed)
+}
+
+func TestCore_Conformance(t *testing.T) {
+	s := runServer(t)
+	axontest.RunConformance(t, func(t *testing.T, opts axon.Options) axon.EventStore {
+		opts.Address = s.ClientURL()
+		store, err := InitCore(opts)
+		require.Nil(t, err)
+		t.Cleanup(func() { _ = store.Close() })
+		return store
+	}, axontest.AckWait(200*time.Millisecond), axontest.AtMostOnce())
+}
@@ -0,0 +1,127 @@
+package stand
+
+import (
+	"github.com/Just4Ease/axon"
+	"github.com/nats-io/nats-server/v2/server"
+	streaming "github.com/nats-io/nats-streaming-server/server"
+	"github.com/nats-io/nats-streaming-server/stores"
+	"github.com/nats-io/stan.go"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+const defaultEmbeddedClusterID = "axon"
+
+var ErrMissingStoreDir = errors.New("Sorry, file storage requires a directory to store the messages in")
+
+// Storage selects where an embedded server keeps its messages.
+type Storage string
+
+const (
+	MemoryStorage Storage = stores.TypeMemory
+	FileStorage   Storage = stores.TypeFile
+)
+
+// EmbeddedConfig configures the NATS Streaming server started in process by StartEmbedded and InitEmbedded.
+type EmbeddedConfig struct {
+	ClusterID string  // Defaults to "axon".
+	Storage   Storage // Defaults to MemoryStorage.
+	Dir       string  // Directory of FileStorage, created when missing.
+	Host      string  // Defaults to 127.0.0.1.
+	Port      int     // Defaults to a random free port. Set it for other processes to connect.
+	Logging   bool    // Logs the server to stderr.
+}
+
+// EmbeddedServer is a NATS Streaming server, and the NATS server it runs on, started in process.
+type EmbeddedServer struct {
+	server *streaming.StanServer
+}
+
+// StartEmbedded starts a NATS Streaming server in process, for single binary deployments and tests without a
+// cluster. Stores of the process connect to it with Connect, other processes with ClientURL and ClusterID.
+func StartEmbedded(config EmbeddedConfig) (*EmbeddedServer, error) {
+	stanOpts := streaming.GetDefaultOptions()
+	stanOpts.ID = config.ClusterID
+	if stanOpts.ID == "" {
+		stanOpts.ID = defaultEmbeddedClusterID
+	}
+	stanOpts.EnableLogging = config.Logging
+
+	switch config.Storage {
+	case "", MemoryStorage:
+		stanOpts.StoreType = stores.TypeMemory
+	case FileStorage:
+		if strings.TrimSpace(config.Dir) == "" {
+			return nil, ErrMissingStoreDir
+		}
+		stanOpts.StoreType = stores.TypeFile
+		stanOpts.FilestoreDir = config.Dir
+	default:
+		return nil, errors.Errorf("unknown storage %q, use stand.MemoryStorage or stand.FileStorage", config.Storage)
+	}
+
+	natsOpts := streaming.NewNATSOptions()
+	natsOpts.Host = config.Host
+	if natsOpts.Host == "" {
+		natsOpts.Host = "127.0.0.1"
+	}
+	natsOpts.Port = config.Port
+	if natsOpts.Port == 0 {
+		natsOpts.Port = server.RANDOM_PORT
+	}
+	natsOpts.NoSigs = true
+
+	s, err := streaming.RunServerWithOpts(stanOpts, natsOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start the embedded NATS Streaming server")
+	}
+	return &EmbeddedServer{server: s}, nil
+}
+
+// ClientURL returns the address of the NATS server, such as `nats://127.0.0.1:4222`.
+func (e *EmbeddedServer) ClientURL() string {
+	return e.server.ClientURL()
+}
+
+func (e *EmbeddedServer) ClusterID() string {
+	return e.server.ClusterID()
+}
+
+// Connect opens a store on the server. opts.Address and opts.ClusterID are ignored.
+func (e *EmbeddedServer) Connect(opts axon.Options, options ...stan.Option) (axon.EventStore, error) {
+	opts.Address = e.ClientURL()
+	return Init(opts, e.ClusterID(), options...)
+}
+
+// Shutdown stops the server. Messages of FileStorage stay in its directory for the next start.
+func (e *EmbeddedServer) Shutdown() {
+	e.server.Shutdown()
+}
+
+// embeddedStore is a store owning the embedded server it is connected to.
+type embeddedStore struct {
+	*natsStore
+	server *EmbeddedServer
+}
+
+// InitEmbedded starts a NATS Streaming server in process with config and connects to it. Closing the store shuts
+// the server down; use StartEmbedded to share a server between several stores.
+func InitEmbedded(opts axon.Options, config EmbeddedConfig, options ...stan.Option) (axon.EventStore, error) {
+	s, err := StartEmbedded(config)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := s.Connect(opts, options...)
+	if err != nil {
+		s.Shutdown()
+		return nil, err
+	}
+	return &embeddedStore{natsStore: store.(*natsStore), server: s}, nil
+}
+
+func (s *embeddedStore) Close() error {
+	err := s.natsStore.Close()
+	s.server.Shutdown()
+	return err
+}
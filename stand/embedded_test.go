@@ -0,0 +1,121 @@
+package stand
+
+import (
+	"context"
+	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/axontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, events <-chan axon.Event) axon.Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+func TestInitEmbedded(t *testing.T) {
+	store, err := InitEmbedded(axon.Options{ServiceName: "orders"}, EmbeddedConfig{})
+	require.Nil(t, err)
+	defer store.Close()
+
+	events := make(chan axon.Event, 1)
+	go func() { _ = store.Subscribe("order.created", func(e axon.Event) { events <- e }) }()
+	time.Sleep(100 * time.Millisecond)
+
+	require.Nil(t, store.Publish("order.created", []byte("#1")))
+	e := receiveEvent(t, events)
+	assert.Equal(t, "#1", string(e.Data()))
+	e.Ack()
+	assert.Nil(t, store.Health())
+
+	_, err = InitEmbedded(axon.Options{ServiceName: "orders"}, EmbeddedConfig{Storage: FileStorage})
+	assert.Equal(t, ErrMissingStoreDir, err)
+}
+
+func TestEmbeddedFileStorageSurvivesRestart(t *testing.T) {
+	config := EmbeddedConfig{ClusterID: "edge", Storage: FileStorage, Dir: t.TempDir()}
+	s, err := StartEmbedded(config)
+	require.Nil(t, err)
+	assert.Equal(t, "edge", s.ClusterID())
+
+	// The durable subscription of the service is created, then the service goes away.
+	subscriber, err := s.Connect(axon.Options{ServiceName: "orders"})
+	require.Nil(t, err)
+	go func() { _ = subscriber.Subscribe("order.created", func(e axon.Event) { e.Ack() }) }()
+	time.Sleep(100 * time.Millisecond)
+	require.Nil(t, subscriber.Close())
+
+	publisher, err := s.Connect(axon.Options{ServiceName: "publisher"})
+	require.Nil(t, err)
+	require.Nil(t, publisher.Publish("order.created", []byte("#1")))
+	require.Nil(t, publisher.Close())
+	s.Shutdown()
+
+	// The message and the subscription are read back from the directory.
+	s, err = StartEmbedded(config)
+	require.Nil(t, err)
+	defer s.Shutdown()
+	resumed, err := s.Connect(axon.Options{ServiceName: "orders"})
+	require.Nil(t, err)
+	defer resumed.Close()
+
+	events := make(chan axon.Event, 1)
+	go func() { _ = resumed.Subscribe("order.created", func(e axon.Event) { events <- e }) }()
+	e := receiveEvent(t, events)
+	assert.Equal(t, "#1", string(e.Data()))
+	e.Ack()
+}
+
+func TestReplay(t *testing.T) {
+	store, err := InitEmbedded(axon.Options{ServiceName: "orders"}, EmbeddedConfig{})
+	require.Nil(t, err)
+	defer store.Close()
+	require.Nil(t, store.Publish("order.created", []byte("#1")))
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	require.Nil(t, store.Publish("order.created", []byte("#2")))
+	require.Nil(t, store.Publish("order.created", []byte("#3")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replay := func(from axon.Position, want ...string) []axon.Event {
+		events := make(chan axon.Event, 8)
+		go func() { _ = axon.Replay(ctx, store, "order.created", from, func(e axon.Event) { events <- e }) }()
+		var received []axon.Event
+		for _, data := range want {
+			e := receiveEvent(t, events)
+			assert.Equal(t, data, string(e.Data()))
+			received = append(received, e)
+		}
+		return received
+	}
+
+	all := replay(axon.Earliest(), "#1", "#2", "#3")
+	assert.Equal(t, axon.AtSequence(2), all[1].(axon.PositionedEvent).Position())
+	replay(axon.AtSequence(2), "#2", "#3")
+	replay(axon.AtTime(since), "#2", "#3")
+
+	err = axon.Replay(ctx, store, "order.created", axon.AtMessageID([]byte("2")), func(axon.Event) {})
+	assert.ErrorIs(t, err, axon.ErrUnsupportedPosition)
+}
+
+func TestConformance(t *testing.T) {
+	s, err := StartEmbedded(EmbeddedConfig{})
+	require.Nil(t, err)
+	t.Cleanup(s.Shutdown)
+	// NATS Streaming does not accept an ack wait below a second, the default of the suite.
+	axontest.RunConformance(t, func(t *testing.T, opts axon.Options) axon.EventStore {
+		store, err := s.Connect(opts)
+		require.Nil(t, err)
+		t.Cleanup(func() { _ = store.Close() })
+		return store
+	})
+}
@@ -0,0 +1,39 @@
+package stand
+
+import (
+	"github.com/Just4Ease/axon"
+	"github.com/pkg/errors"
+	"net/url"
+)
+
+func init() {
+	axon.Register("nats", open)
+	axon.Register("nats+core", openCore)
+}
+
+// open connects to the NATS Streaming cluster named by the `cluster` query parameter of u, such as
+// `nats://localhost:4222?cluster=test-cluster`, or else by opts.ClusterID.
+func open(u *url.URL, opts axon.Options) (axon.EventStore, error) {
+	query := u.Query()
+	clusterId := query.Get("cluster")
+	if clusterId == "" {
+		clusterId = opts.ClusterID
+	}
+	if clusterId == "" {
+		return nil, errors.Wrap(axon.ErrInvalidURL, "the cluster query parameter or cluster id option is required")
+	}
+
+	query.Del("cluster")
+	address := *u
+	address.RawQuery = query.Encode()
+	opts.Address = address.String()
+	return Init(opts, clusterId)
+}
+
+// openCore connects to the NATS server of a `nats+core://localhost:4222` URL without NATS Streaming.
+func openCore(u *url.URL, opts axon.Options) (axon.EventStore, error) {
+	address := *u
+	address.Scheme = "nats"
+	opts.Address = address.String()
+	return InitCore(opts)
+}
@@ -22,6 +22,11 @@ func (s stanEvent) Topic() string {
 	return s.m.Subject
 }
 
+// Position returns the sequence of the message in its channel, to replay the channel from.
+func (s stanEvent) Position() axon.Position {
+	return axon.AtSequence(s.m.Sequence)
+}
+
 func newEvent(msg *stan.Msg) axon.Event {
 	return &stanEvent{
 		m: msg,
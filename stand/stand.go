@@ -1,121 +1,91 @@
 package stand
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/Just4Ease/axon"
+	"github.com/Just4Ease/axon/internal/natsconn"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
 	"log"
 	"strings"
-	"time"
+	"sync/atomic"
 )
 
+// natsStore adds NATS Streaming subscriptions to the request/reply of the core NATS store.
 type natsStore struct {
-	stanClient  stan.Conn
-	natsClient  *nats.Conn
-	serviceName string
+	*coreStore
+	stanClient stan.Conn
 }
 
 func (s *natsStore) Publish(topic string, message []byte) error {
 	return s.stanClient.Publish(topic, message)
 }
 
+// Subscribe joins the durable queue subscription of the service on topic, blocking until the store is closed.
 func (s *natsStore) Subscribe(topic string, handler axon.SubscriptionHandler) error {
-	errChan := make(chan error)
-	go func(errChan chan<- error) {
-		_, err := s.stanClient.QueueSubscribe(topic, s.serviceName, func(msg *stan.Msg) {
-			event := newEvent(msg)
-			go handler(event)
-		}, stan.DurableName(s.serviceName), stan.SetManualAckMode())
-		if err != nil {
-			errChan <- err
-		}
-
-		<-make(chan bool) // Sig Wait.
-	}(errChan)
-
-	return <-errChan
-}
-
-func (s *natsStore) Request(requestURI string, payload []byte, v interface{}) error {
-	nc := s.natsClient
+	topicOpts := s.opts.Topic(topic)
+	limiter := axon.NewLimiter(topicOpts.Concurrency)
+	subOpts := []stan.SubscriptionOption{stan.DurableName(s.serviceName), stan.SetManualAckMode()}
+	if topicOpts.AckWait > 0 {
+		subOpts = append(subOpts, stan.AckWait(topicOpts.AckWait))
+	}
+	if topicOpts.Concurrency > 0 {
+		subOpts = append(subOpts, stan.MaxInflight(topicOpts.Concurrency))
+	}
 
-	req := axon.NewRequestPayload(requestURI, payload)
-	data, err := req.Compact()
-	msg, err := nc.Request(requestURI, data, time.Second*1)
+	_, err := s.stanClient.QueueSubscribe(topic, s.serviceName, func(msg *stan.Msg) {
+		limiter.Go(handler, newEvent(msg))
+	}, subOpts...)
 	if err != nil {
-		log.Print("Error making eventful request: ", err)
 		return err
 	}
 
-	event := newNatsEvent(msg)
-	var reply axon.ReplyPayload
-	if err := json.Unmarshal(event.Data(), &reply); err != nil {
-		log.Print("failed to unmarshal reply event into reply struct with the following errors: ", err)
-		event.Ack()
-		return err
-	}
+	<-s.closed
+	return axon.ErrCloseConn
+}
+
+// Replay reads topic again with a non durable subscription, from axon.Earliest, axon.AtSequence, axon.AtTime or
+// axon.Latest, and follows the messages published afterwards until the store is closed. The durable subscriptions of
+// the service are unaffected.
+func (s *natsStore) Replay(topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	return s.ReplayContext(context.Background(), topic, from, handler)
+}
 
-	// Check if reply has an issue.
-	if replyErr := reply.GetError(); replyErr != nil {
-		event.Ack()
-		return replyErr
+func (s *natsStore) ReplayContext(ctx context.Context, topic string, from axon.Position, handler axon.SubscriptionHandler) error {
+	var start stan.SubscriptionOption
+	switch from.Kind {
+	case axon.PositionEarliest:
+		start = stan.DeliverAllAvailable()
+	case axon.PositionSequence:
+		start = stan.StartAtSequence(from.Sequence)
+	case axon.PositionTime:
+		start = stan.StartAtTime(from.Time)
+	case axon.PositionLatest:
+		start = stan.StartAt(pb.StartPosition_NewOnly)
+	default:
+		return from.Unsupported()
 	}
 
-	// Unpack Reply's payload.
-	if err := json.Unmarshal(reply.GetPayload(), v); err != nil {
-		log.Print("failed to unmarshal reply payload into struct with the following errors: ", err)
-		event.Ack()
+	// Messages of a subscription are delivered one at a time, so the handler sees them in order.
+	sub, err := s.stanClient.Subscribe(topic, func(msg *stan.Msg) {
+		handler(newEvent(msg))
+	}, start)
+	if err != nil {
 		return err
 	}
 
-	event.Ack()
-	return nil
-}
-
-func (s *natsStore) Reply(topic string, handler axon.ReplyHandler) error {
-	errChan := make(chan error)
-	go func(errChan chan<- error) {
-		_, err := s.natsClient.QueueSubscribe(topic, s.serviceName, func(msg *nats.Msg) {
-			event := newNatsEvent(msg)
-			var reqPl axon.RequestPayload
-			decoder := json.NewDecoder(bytes.NewBuffer(event.Data()))
-			decoder.UseNumber()
-			if err := decoder.Decode(&reqPl); err != nil {
-				log.Print("failed to decode incoming request payload []bytes with the following error: ", err)
-				return
-			}
-
-			out, err := handler(reqPl.GetPayload())
-			rl := axon.NewReply(out, err)
-
-			data, err := rl.Compact()
-			if err != nil {
-				log.Print("failed to encode reply payload into []bytes with the following error: ", err)
-				return
-			}
-
-			if err := msg.Respond(data); err != nil {
-				log.Print("failed to reply data to the incoming request with the following error: ", err)
-				return
-			}
-		})
-		if err != nil {
-			errChan <- err
-		}
-	}(errChan)
-	return <-errChan
-}
-
-func (s *natsStore) GetServiceName() string {
-	return s.serviceName
+	select {
+	case <-s.closed:
+		return axon.ErrCloseConn
+	case <-ctx.Done():
+		_ = sub.Unsubscribe()
+		return ctx.Err()
+	}
 }
 
 func Init(opts axon.Options, clusterId string, options ...stan.Option) (axon.EventStore, error) {
-
 	addr := strings.TrimSpace(opts.Address)
 	if addr == "" {
 		return nil, axon.ErrInvalidURL
@@ -125,34 +95,46 @@ func Init(opts axon.Options, clusterId string, options ...stan.Option) (axon.Eve
 	if name == "" {
 		return nil, axon.ErrEmptyStoreName
 	}
-	//if opts.AuthenticationToken != "" {
-	//	clientOptions.Authentication = pulsar.NewAuthenticationToken(opts.AuthenticationToken)
-	//}
 
-	nc, err := nats.Connect(opts.Address, nats.Name(name))
+	connOptions, err := natsconn.Options(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := axon.NewStateTracker(axon.StateConnected)
+	var streamingLost int32 // A lost streaming connection is not restored by NATS reconnecting.
+	connOptions = append(connOptions, nats.Name(name))
+	connOptions = append(connOptions, natsconn.TrackState(tracker, func() bool {
+		return atomic.LoadInt32(&streamingLost) == 0
+	})...)
+	nc, err := nats.Connect(addr, connOptions...)
 	if err != nil {
 		return nil, err
 	}
 
 	var optsList []stan.Option
 	optsList = append(optsList, stan.NatsConn(nc))
+	optsList = append(optsList, stan.SetConnectionLostHandler(func(conn stan.Conn, err error) {
+		log.Print("lost connection to the NATS Streaming server with the following error: ", err)
+		atomic.StoreInt32(&streamingLost, 1)
+		tracker.SetState(axon.StateDisconnected)
+	}))
 	optsList = append(optsList, options...)
 	st, err := stan.Connect(clusterId, fmt.Sprintf("%s-%s", name, axon.GenerateRandomString()), optsList...)
 	if err != nil {
+		nc.Close()
 		return nil, fmt.Errorf("unable to connect with NATS with the provided configuration. failed with error: %v", err)
 	}
 
 	return &natsStore{
-		stanClient:  st,
-		natsClient:  nc,
-		serviceName: name,
+		coreStore:  newCoreStore(nc, tracker, name, opts),
+		stanClient: st,
 	}, nil
 }
 
-func (s *natsStore) Run(ctx context.Context, handlers ...axon.EventHandler) {
-	for _, handler := range handlers {
-		go handler.Run()
-	}
-
-	<-ctx.Done()
+// Close closes the streaming connection along with the NATS connection it runs on.
+func (s *natsStore) Close() error {
+	err := s.stanClient.Close()
+	_ = s.coreStore.Close()
+	return err
 }
@@ -0,0 +1,90 @@
+package axon
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// State is the connection state of an EventStore with its broker.
+type State int
+
+const (
+	StateConnected State = iota
+	StateReconnecting
+	StateDisconnected
+	StateClosed
+)
+
+var (
+	ErrReconnecting = errors.New("Sorry, the store is reconnecting to its broker")
+	ErrDisconnected = errors.New("Sorry, the store is disconnected from its broker")
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateTracker keeps the connection state of a store and notifies listeners when it changes.
+// Stores embed it to implement Health and OnStateChange.
+type StateTracker struct {
+	mu        sync.RWMutex
+	state     State
+	listeners []func(State)
+}
+
+func NewStateTracker(initial State) *StateTracker {
+	return &StateTracker{state: initial}
+}
+
+func (t *StateTracker) State() State {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.state
+}
+
+// SetState records a new state and notifies the listeners if it differs from the current one.
+// A closed tracker stays closed.
+func (t *StateTracker) SetState(state State) {
+	t.mu.Lock()
+	if t.state == state || t.state == StateClosed {
+		t.mu.Unlock()
+		return
+	}
+	t.state = state
+	listeners := append([]func(State){}, t.listeners...)
+	t.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(state)
+	}
+}
+
+// OnStateChange registers fn to be called with every new state.
+func (t *StateTracker) OnStateChange(fn func(State)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listeners = append(t.listeners, fn)
+}
+
+// Health returns nil while connected and an error describing the state otherwise.
+func (t *StateTracker) Health() error {
+	switch t.State() {
+	case StateConnected:
+		return nil
+	case StateReconnecting:
+		return ErrReconnecting
+	case StateClosed:
+		return ErrCloseConn
+	}
+	return ErrDisconnected
+}
@@ -9,6 +9,7 @@ import (
 
 type SubscriptionHandler func(event Event)
 type ReplyHandler func(input []byte) ([]byte, error)
+type ContextReplyHandler func(ctx context.Context, req Request) (Response, error)
 type EventHandler func() error
 
 var (
@@ -16,19 +17,22 @@ var (
 	ErrInvalidURL              = errors.New("Sorry, you must provide a valid store URL")
 	ErrInvalidTlsConfiguration = errors.New("Sorry, you have provided an invalid tls configuration")
 	ErrCloseConn               = errors.New("connection closed")
+	ErrNoRoutes                = errors.New("Sorry, the router has no route matching any topic")
 )
 
 type EventStore interface {
 	Publish(topic string, message []byte) error
 	Subscribe(topic string, handler SubscriptionHandler) error
-	Request(requestURI string, payload []byte, v interface{}) error
+	Request(requestURI string, payload []byte, v interface{}, opts ...RequestOption) error
 	Reply(topic string, handler ReplyHandler) error
+	ReplyContext(topic string, handler ContextReplyHandler) error
 	GetServiceName() string
 	Run(ctx context.Context, handlers ...EventHandler)
+	Health() error
+	OnStateChange(fn func(State))
+	Close() error
 }
 
-
-
 func (f EventHandler) Run() {
 	for {
 		err := f()
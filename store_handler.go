@@ -0,0 +1,115 @@
+package axon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Request is the view of an incoming request given to a ContextReplyHandler.
+type Request struct {
+	Topic         string
+	ServiceName   string // Name of the calling service.
+	CorrelationID string
+	Headers       map[string]string
+	Deadline      time.Time // Zero when the caller set none; also carried by the handler's context.
+	Payload       []byte
+}
+
+func (r Request) ParsePayload(v interface{}) error {
+	return json.Unmarshal(r.Payload, v)
+}
+
+// Response is what a ContextReplyHandler sends back to the caller.
+type Response struct {
+	Payload []byte
+	Headers map[string]string
+}
+
+// WrapReplyHandler adapts a plain ReplyHandler into a ContextReplyHandler.
+func WrapReplyHandler(handler ReplyHandler) ContextReplyHandler {
+	return func(ctx context.Context, req Request) (Response, error) {
+		out, err := handler(req.Payload)
+		return Response{Payload: out}, err
+	}
+}
+
+// ServeRequest decodes a request envelope received on topic, runs the handler within the caller's deadline
+// and returns the decoded envelope along with the encoded ReplyPayload to send to its reply address.
+func ServeRequest(topic string, data []byte, handler ContextReplyHandler) (*RequestPayload, []byte, error) {
+	var reqPl RequestPayload
+	decoder := json.NewDecoder(bytes.NewBuffer(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&reqPl); err != nil {
+		return nil, nil, err
+	}
+
+	ctx := context.Background()
+	deadline, ok := reqPl.GetDeadline()
+	if ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	res, handlerError := handler(ctx, Request{
+		Topic:         topic,
+		ServiceName:   reqPl.ServiceName,
+		CorrelationID: reqPl.CorrelationID,
+		Headers:       reqPl.Headers,
+		Deadline:      deadline,
+		Payload:       reqPl.GetPayload(),
+	})
+	reply := NewReply(res.Payload, handlerError)
+	reply.Headers = res.Headers
+	out, err := reply.Compact()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &reqPl, out, nil
+}
+
+// LimitHandler returns a handler running at most n invocations of handler at once; n <= 0 leaves it unbounded.
+// Callers over the limit wait for their turn, so it only holds receivers back when they call it in their own
+// goroutine. Receive loops starting a goroutine per message use a Limiter instead.
+func LimitHandler(n int, handler SubscriptionHandler) SubscriptionHandler {
+	if n <= 0 {
+		return handler
+	}
+
+	sem := make(chan struct{}, n)
+	return func(event Event) {
+		sem <- struct{}{}
+		defer func() { <-sem }()
+		handler(event)
+	}
+}
+
+// Limiter bounds how many handlers of a subscription run at once. Receive loops start handlers with Go, which
+// waits for a free slot first, so a subscription busy with TopicOptions.Concurrency handlers stops receiving
+// messages instead of parking a goroutine for each.
+type Limiter struct {
+	sem chan struct{}
+}
+
+// NewLimiter returns a Limiter running at most n handlers at once; n <= 0 leaves it unbounded.
+func NewLimiter(n int) *Limiter {
+	if n <= 0 {
+		return &Limiter{}
+	}
+	return &Limiter{sem: make(chan struct{}, n)}
+}
+
+// Go waits until fewer than n handlers run, then runs handler with event in a new goroutine.
+func (l *Limiter) Go(handler SubscriptionHandler, event Event) {
+	if l.sem == nil {
+		go handler(event)
+		return
+	}
+	l.sem <- struct{}{}
+	go func() {
+		defer func() { <-l.sem }()
+		handler(event)
+	}()
+}
@@ -0,0 +1,112 @@
+package axon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServeRequest(t *testing.T) {
+	req := NewRequestPayload("greet", []byte(`{"name":"axon"}`), WithHeader("x-tenant", "acme"), WithTimeout(time.Minute))
+	req.ServiceName = "caller"
+	data, err := req.Compact()
+	assert.Nil(t, err)
+
+	reqPl, out, err := ServeRequest("greet", data, func(ctx context.Context, r Request) (Response, error) {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.Equal(t, r.Deadline, deadline)
+		assert.Equal(t, "greet", r.Topic)
+		assert.Equal(t, "caller", r.ServiceName)
+		assert.Equal(t, req.CorrelationID, r.CorrelationID)
+		assert.Equal(t, "acme", r.Headers["x-tenant"])
+		return Response{Payload: []byte(`"hello"`), Headers: map[string]string{"x-served-by": "replier"}}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, req.ReplyPipe, reqPl.GetReplyAddress())
+
+	var reply ReplyPayload
+	assert.Nil(t, json.Unmarshal(out, &reply))
+	assert.Nil(t, reply.GetError())
+	assert.Equal(t, `"hello"`, string(reply.GetPayload()))
+	assert.Equal(t, "replier", reply.Headers["x-served-by"])
+}
+
+func TestWrapReplyHandler(t *testing.T) {
+	data, _ := NewRequestPayload("fail", []byte(`{}`)).Compact()
+	_, out, err := ServeRequest("fail", data, WrapReplyHandler(func(input []byte) ([]byte, error) {
+		return nil, errors.New("boom")
+	}))
+	assert.Nil(t, err)
+
+	var reply ReplyPayload
+	assert.Nil(t, json.Unmarshal(out, &reply))
+	assert.EqualError(t, reply.GetError(), "boom")
+}
+
+func TestLimitHandler(t *testing.T) {
+	var running, peak int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	handler := LimitHandler(2, func(Event) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		wg.Done()
+	})
+
+	wg.Add(5)
+	for i := 0; i < 5; i++ {
+		go handler(nil)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&running))
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&peak))
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(2)
+	release := make(chan struct{})
+	var running int32
+	handler := func(Event) {
+		atomic.AddInt32(&running, 1)
+		<-release
+		atomic.AddInt32(&running, -1)
+	}
+
+	// The receive loop itself waits once two handlers run, instead of starting a third.
+	received := make(chan int, 5)
+	go func() {
+		for i := 0; i < 5; i++ {
+			limiter.Go(handler, nil)
+			received <- i
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, received, 2)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&running))
+	close(release)
+	assert.Eventually(t, func() bool { return len(received) == 5 && atomic.LoadInt32(&running) == 0 }, time.Second, 5*time.Millisecond)
+
+	unbounded := NewLimiter(0)
+	done := make(chan struct{}, 3)
+	for i := 0; i < 3; i++ {
+		unbounded.Go(func(Event) { done <- struct{}{} }, nil)
+	}
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+}
@@ -1,8 +1,60 @@
 package axon
 
+import "time"
+
+// Options configures an EventStore. Build it with NewOptions to load it from files or the environment and have
+// it validated, or fill it in directly.
 type Options struct {
-	ServiceName         string
-	Address             string
-	CertContent         string
-	AuthenticationToken string
+	ServiceName         string                  `yaml:"service_name"`
+	Address             string                  `yaml:"address"`
+	ClusterID           string                  `yaml:"cluster_id"`   // NATS Streaming cluster id, when not set in the address.
+	CertContent         string                  `yaml:"cert_content"` // PEM encoded CA certificate trusted for the broker. Prefer TLS.CACertContent.
+	AuthenticationToken string                  `yaml:"token"`
+	Auth                *AuthOptions            `yaml:"auth"`
+	TLS                 *TLSOptions             `yaml:"tls"`
+	RequestTimeout      time.Duration           `yaml:"request_timeout"` // How long Request waits for a reply by default.
+	Concurrency         int                     `yaml:"concurrency"`     // Maximum handlers running at once per subscription, unbounded when zero.
+	Topics              map[string]TopicOptions `yaml:"topics"`          // Per topic overrides.
+}
+
+// TopicOptions overrides the store wide options for a single topic. Zero values keep the store wide setting.
+type TopicOptions struct {
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	Concurrency    int           `yaml:"concurrency"`
+	AckWait        time.Duration `yaml:"ack_wait"` // How long a delivered message may stay unacknowledged, where supported.
+}
+
+// AuthOptions holds the credentials used to authenticate with the broker. Backends use the ones they support.
+type AuthOptions struct {
+	Username        string                 `yaml:"username"` // NATS user or Pulsar basic auth user.
+	Password        string                 `yaml:"password"`
+	CredentialsFile string                 `yaml:"credentials_file"` // NATS credentials file holding a user JWT and NKey seed.
+	NKeySeedFile    string                 `yaml:"nkey_seed_file"`   // NATS NKey seed file.
+	TokenFile       string                 `yaml:"token_file"`       // Re-read whenever the broker asks for fresh credentials.
+	TokenSupplier   func() (string, error) `yaml:"-"`                // Called whenever the broker asks for fresh credentials.
+	OAuth2          *OAuth2Options         `yaml:"oauth2"`
+}
+
+// OAuth2Options configures the OAuth2 client credentials flow. Either set KeyFile, a Pulsar key file from which
+// the token endpoint is discovered through IssuerURL, or TokenURL along with the client id and secret.
+type OAuth2Options struct {
+	IssuerURL    string   `yaml:"issuer_url"`
+	KeyFile      string   `yaml:"key_file"`
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Audience     string   `yaml:"audience"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// Topic returns the options of topic, with its overrides applied over the store wide settings.
+func (o Options) Topic(topic string) TopicOptions {
+	t := o.Topics[topic]
+	if t.RequestTimeout == 0 {
+		t.RequestTimeout = o.RequestTimeout
+	}
+	if t.Concurrency == 0 {
+		t.Concurrency = o.Concurrency
+	}
+	return t
 }
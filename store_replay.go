@@ -0,0 +1,113 @@
+package axon
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"time"
+)
+
+var (
+	ErrReplayUnsupported   = errors.New("Sorry, this store cannot replay the history of a topic")
+	ErrUnsupportedPosition = errors.New("Sorry, this store cannot replay from this kind of position")
+)
+
+// PositionKind tells what a Position refers to.
+type PositionKind int
+
+const (
+	PositionEarliest PositionKind = iota
+	PositionMessageID
+	PositionSequence
+	PositionTime
+	PositionLatest
+)
+
+func (k PositionKind) String() string {
+	switch k {
+	case PositionEarliest:
+		return "earliest"
+	case PositionMessageID:
+		return "message id"
+	case PositionSequence:
+		return "sequence"
+	case PositionTime:
+		return "time"
+	case PositionLatest:
+		return "latest"
+	}
+	return "unknown"
+}
+
+// Position is where a replay starts in the history of a topic, inclusively. The zero Position is the earliest
+// message still retained by the broker.
+type Position struct {
+	Kind      PositionKind
+	MessageID []byte    // Backend specific, such as a serialized Pulsar message ID.
+	Sequence  uint64    // Sequence of the message in the topic, numbered by the backend.
+	Time      time.Time // Publish time of the first message to replay.
+}
+
+func Earliest() Position {
+	return Position{Kind: PositionEarliest}
+}
+
+func AtMessageID(id []byte) Position {
+	return Position{Kind: PositionMessageID, MessageID: id}
+}
+
+func AtSequence(seq uint64) Position {
+	return Position{Kind: PositionSequence, Sequence: seq}
+}
+
+func AtTime(t time.Time) Position {
+	return Position{Kind: PositionTime, Time: t}
+}
+
+// Latest is the end of the topic, to follow only the messages published from now on.
+func Latest() Position {
+	return Position{Kind: PositionLatest}
+}
+
+func (p Position) String() string {
+	switch p.Kind {
+	case PositionMessageID:
+		return fmt.Sprintf("message id %x", p.MessageID)
+	case PositionSequence:
+		return fmt.Sprintf("sequence %d", p.Sequence)
+	case PositionTime:
+		return "time " + p.Time.Format(time.RFC3339Nano)
+	}
+	return p.Kind.String()
+}
+
+// Unsupported returns ErrUnsupportedPosition naming the position, for stores to reject it.
+func (p Position) Unsupported() error {
+	return errors.Wrap(ErrUnsupportedPosition, p.String())
+}
+
+// Replayer is implemented by the stores able to read the history of a topic again, to rebuild read models or
+// debug incidents. A replay uses no durable subscription, so the subscriptions of the service are unaffected.
+//
+// Replay delivers the messages of topic from the position onwards in order, then follows the messages published
+// afterwards, blocking until the store is closed. The handler runs synchronously and Ack is optional.
+// ReplayContext also returns ctx.Err() once ctx is done.
+type Replayer interface {
+	Replay(topic string, from Position, handler SubscriptionHandler) error
+	ReplayContext(ctx context.Context, topic string, from Position, handler SubscriptionHandler) error
+}
+
+// PositionedEvent is implemented by the events delivered by a Replayer, to resume a replay from an event.
+type PositionedEvent interface {
+	Event
+	Position() Position
+}
+
+// Replay replays topic with store, or fails with ErrReplayUnsupported when the store is not a Replayer.
+func Replay(ctx context.Context, store EventStore, topic string, from Position, handler SubscriptionHandler) error {
+	r, ok := store.(Replayer)
+	if !ok {
+		return ErrReplayUnsupported
+	}
+	return r.ReplayContext(ctx, topic, from, handler)
+}
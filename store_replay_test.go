@@ -0,0 +1,24 @@
+package axon
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPosition_String(t *testing.T) {
+	at := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	assert.Equal(t, "earliest", Position{}.String())
+	assert.Equal(t, "message id 0a0b", AtMessageID([]byte{10, 11}).String())
+	assert.Equal(t, "sequence 42", AtSequence(42).String())
+	assert.Equal(t, "time 2021-03-04T05:06:07Z", AtTime(at).String())
+	assert.Equal(t, "latest", Latest().String())
+	assert.EqualError(t, AtTime(at).Unsupported(), "time 2021-03-04T05:06:07Z: "+ErrUnsupportedPosition.Error())
+}
+
+func TestReplay(t *testing.T) {
+	var store struct{ EventStore } // Not a Replayer.
+	err := Replay(context.Background(), store, "order.created", Earliest(), func(Event) {})
+	assert.Equal(t, ErrReplayUnsupported, err)
+}
@@ -4,8 +4,9 @@ import "encoding/json"
 import "github.com/pkg/errors"
 
 type ReplyPayload struct {
-	ErrorMessage string          `json:"error_message"`
-	Payload      json.RawMessage `json:"payload"`
+	ErrorMessage string            `json:"error_message"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Payload      json.RawMessage   `json:"payload"`
 }
 
 func NewReply(payload []byte, err error) *ReplyPayload {
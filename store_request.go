@@ -3,18 +3,73 @@ package axon
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 type RequestPayload struct {
-	ReplyPipe string          `json:"reply_pipe"`
-	Payload   json.RawMessage `json:"payload"`
+	ReplyPipe     string            `json:"reply_pipe"`
+	ServiceName   string            `json:"service_name,omitempty"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Deadline      int64             `json:"deadline,omitempty"` // Unix time in nanoseconds, zero when the caller set none.
+	Payload       json.RawMessage   `json:"payload"`
+
+	replyHeaders []*map[string]string
+}
+
+// RequestOption customises the envelope of an outgoing request.
+type RequestOption func(*RequestPayload)
+
+// WithHeader attaches a key/value header to the request.
+func WithHeader(key, value string) RequestOption {
+	return func(r *RequestPayload) {
+		if r.Headers == nil {
+			r.Headers = make(map[string]string)
+		}
+		r.Headers[key] = value
+	}
+}
+
+// WithCorrelationID overrides the generated correlation id of the request.
+func WithCorrelationID(id string) RequestOption {
+	return func(r *RequestPayload) {
+		r.CorrelationID = id
+	}
+}
+
+// WithTimeout bounds how long the caller waits for a reply. The resulting deadline is passed on to the replier.
+func WithTimeout(timeout time.Duration) RequestOption {
+	return func(r *RequestPayload) {
+		r.Deadline = time.Now().Add(timeout).UnixNano()
+	}
+}
+
+// WithReplyHeaders stores the headers of the reply in headers once it arrives, such as the Response headers set by
+// the replier. Stores wrapping another pass their own headers option along with the caller's.
+func WithReplyHeaders(headers *map[string]string) RequestOption {
+	return func(r *RequestPayload) {
+		r.replyHeaders = append(r.replyHeaders, headers)
+	}
 }
 
-func NewRequestPayload(topic string, message []byte) *RequestPayload {
+func NewRequestPayload(topic string, message []byte, opts ...RequestOption) *RequestPayload {
 	replyPipe := fmt.Sprintf("%s::%s", topic, GenerateRandomString()) // TODO: Generate Randomness for reply pipe.
-	return &RequestPayload{
-		ReplyPipe: replyPipe,
-		Payload:   message,
+	r := &RequestPayload{
+		ReplyPipe:     replyPipe,
+		CorrelationID: GenerateRandomString(),
+		Payload:       message,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// SetReplyHeaders hands the headers of the reply to the callers which asked for them with WithReplyHeaders.
+// Stores call it once they decoded the reply, before its error.
+func (r *RequestPayload) SetReplyHeaders(headers map[string]string) {
+	for _, h := range r.replyHeaders {
+		*h = headers
 	}
 }
 
@@ -26,6 +81,23 @@ func (r *RequestPayload) GetPayload() []byte {
 	return r.Payload
 }
 
+// GetDeadline returns the deadline set by the caller, if any.
+func (r *RequestPayload) GetDeadline() (time.Time, bool) {
+	if r.Deadline == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, r.Deadline), true
+}
+
+// Timeout returns the time left before the deadline, or fallback when the caller set none.
+func (r *RequestPayload) Timeout(fallback time.Duration) time.Duration {
+	deadline, ok := r.GetDeadline()
+	if !ok {
+		return fallback
+	}
+	return time.Until(deadline)
+}
+
 func (r *RequestPayload) ParsePayload(v interface{}) error {
 	return json.Unmarshal(r.Payload, v) // Change to ioutils.readAll not json buffer thingy.
 }
@@ -0,0 +1,95 @@
+package axon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"io/ioutil"
+)
+
+// TLSOptions configures TLS towards the broker. Certificates may be given as PEM content or file paths.
+type TLSOptions struct {
+	CACertContent      string `yaml:"ca_cert"`
+	CACertFile         string `yaml:"ca_cert_file"`
+	ClientCertContent  string `yaml:"client_cert"`
+	ClientKeyContent   string `yaml:"client_key"`
+	ClientCertFile     string `yaml:"client_cert_file"`
+	ClientKeyFile      string `yaml:"client_key_file"`
+	ServerName         string `yaml:"server_name"`          // Overrides the host name verified against the broker certificate. Ignored by pulse.
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // Disables certificate and host name verification. Never use it in production.
+}
+
+// ResolvedTLS returns the TLS options of o, honouring the legacy CertContent field.
+func (o Options) ResolvedTLS() *TLSOptions {
+	if o.TLS == nil && o.CertContent == "" {
+		return nil
+	}
+
+	t := &TLSOptions{}
+	if o.TLS != nil {
+		*t = *o.TLS
+	}
+	if t.CACertContent == "" {
+		t.CACertContent = o.CertContent
+	}
+	return t
+}
+
+// CACertPEM returns the PEM encoded CA certificates, reading CACertFile when no content is set.
+func (t *TLSOptions) CACertPEM() ([]byte, error) {
+	if t.CACertContent != "" {
+		return []byte(t.CACertContent), nil
+	}
+	if t.CACertFile != "" {
+		return ioutil.ReadFile(t.CACertFile)
+	}
+	return nil, nil
+}
+
+// ClientCertificate loads the client certificate used for mutual TLS. It returns nil when none is configured.
+func (t *TLSOptions) ClientCertificate() (*tls.Certificate, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case t.ClientCertContent != "" || t.ClientKeyContent != "":
+		cert, err = tls.X509KeyPair([]byte(t.ClientCertContent), []byte(t.ClientKeyContent))
+	case t.ClientCertFile != "" || t.ClientKeyFile != "":
+		cert, err = tls.LoadX509KeyPair(t.ClientCertFile, t.ClientKeyFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidTlsConfiguration, err.Error())
+	}
+	return &cert, nil
+}
+
+// Config builds a tls.Config that keeps the CA pool in memory.
+func (t *TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	ca, err := t.CACertPEM()
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidTlsConfiguration, err.Error())
+	}
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Wrap(ErrInvalidTlsConfiguration, "no valid CA certificate found")
+		}
+		config.RootCAs = pool
+	}
+
+	cert, err := t.ClientCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return config, nil
+}